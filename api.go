@@ -0,0 +1,546 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	bsonvalidator "glowstickdb/pkgs/bson-validator"
+	dbservice "glowstickdb/pkgs/db_service"
+	"glowstickdb/pkgs/embedder"
+	"glowstickdb/pkgs/wiredtiger"
+
+	"github.com/valyala/fasthttp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// apiServer serves the REST API. Handlers under /databases/{db} get a
+// DBService bound to that database.
+type apiServer struct {
+	kv      wiredtiger.WTService
+	indexes *dbservice.IndexManager
+}
+
+type createDatabaseRequest struct {
+	Name string `json:"name" bson:"name"`
+}
+
+type databaseResponse struct {
+	Name string `json:"name" bson:"name"`
+	UUID string `json:"uuid" bson:"uuid"`
+}
+
+type listDatabasesResponse struct {
+	Databases []databaseResponse `json:"databases" bson:"databases"`
+}
+
+type createCollectionRequest struct {
+	Name         string `json:"name" bson:"name"`
+	Dimension    int    `json:"dimension,omitempty" bson:"dimension,omitempty"`
+	Metric       string `json:"metric,omitempty" bson:"metric,omitempty"`
+	IndexType    string `json:"index_type,omitempty" bson:"index_type,omitempty"`
+	TrainSize    int    `json:"train_size,omitempty" bson:"train_size,omitempty"`
+	TextAnalyzer string `json:"text_analyzer,omitempty" bson:"text_analyzer,omitempty"`
+
+	Embedder *embedderRequest      `json:"embedder,omitempty" bson:"embedder,omitempty"`
+	Schema   *bsonvalidator.Schema `json:"schema,omitempty" bson:"schema,omitempty"`
+}
+
+// embedderRequest configures the embedding provider of a new collection.
+type embedderRequest struct {
+	Provider  string `json:"provider" bson:"provider"`
+	Model     string `json:"model,omitempty" bson:"model,omitempty"`
+	BaseURL   string `json:"base_url,omitempty" bson:"base_url,omitempty"`
+	APIKeyEnv string `json:"api_key_env,omitempty" bson:"api_key_env,omitempty"`
+	Dimension int    `json:"dimension,omitempty" bson:"dimension,omitempty"`
+}
+
+type collectionResponse struct {
+	Name      string     `json:"name" bson:"name"`
+	ID        string     `json:"_id,omitempty" bson:"_id,omitempty"`
+	Indexes   []string   `json:"indexes,omitempty" bson:"indexes,omitempty"`
+	Dimension int        `json:"dimension,omitempty" bson:"dimension,omitempty"`
+	Metric    string     `json:"metric,omitempty" bson:"metric,omitempty"`
+	IndexType string     `json:"index_type,omitempty" bson:"index_type,omitempty"`
+	Embedder  string     `json:"embedder,omitempty" bson:"embedder,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
+}
+
+type listCollectionsResponse struct {
+	Collections []collectionResponse `json:"collections" bson:"collections"`
+}
+
+type dropResponse struct {
+	Dropped string `json:"dropped" bson:"dropped"`
+}
+
+type statsResponse struct {
+	DocCount        int     `json:"doc_count" bson:"doc_count"`
+	VectorIndexSize float64 `json:"vector_index_size" bson:"vector_index_size"`
+	TombstoneCount  int     `json:"tombstone_count" bson:"tombstone_count"`
+}
+
+// documentPayload is the wire form of a GlowstickDocument in requests and responses.
+type documentPayload struct {
+	ID        string      `json:"_id,omitempty" bson:"_id,omitempty"`
+	Content   string      `json:"content" bson:"content"`
+	Embedding vector      `json:"embedding" bson:"embedding"`
+	Metadata  interface{} `json:"metadata,omitempty" bson:"metadata,omitempty"`
+
+	// Set only on query results.
+	Distance *float32 `json:"distance,omitempty" bson:"distance,omitempty"`
+	Score    *float32 `json:"score,omitempty" bson:"score,omitempty"`
+	Label    *int64   `json:"label,omitempty" bson:"label,omitempty"`
+
+	// Set only on hybrid query results.
+	VectorScore *float32 `json:"vector_score,omitempty" bson:"vector_score,omitempty"`
+	TextScore   *float32 `json:"text_score,omitempty" bson:"text_score,omitempty"`
+}
+
+type insertDocumentsRequest struct {
+	Documents []documentPayload `json:"documents" bson:"documents"`
+}
+
+type insertDocumentsResponse struct {
+	Inserted int      `json:"inserted" bson:"inserted"`
+	IDs      []string `json:"ids" bson:"ids"`
+}
+
+type deleteDocumentsResponse struct {
+	Deleted int `json:"deleted" bson:"deleted"`
+}
+
+type queryRequest struct {
+	TopK           int32                  `json:"top_k" bson:"top_k"`
+	MaxDistance    float32                `json:"max_distance,omitempty" bson:"max_distance,omitempty"`
+	QueryEmbedding vector                 `json:"query_embedding" bson:"query_embedding"`
+	Filters        map[string]interface{} `json:"filters,omitempty" bson:"filters,omitempty"`
+	QueryContent   string                 `json:"query_content,omitempty" bson:"query_content,omitempty"`
+	QueryText      string                 `json:"query_text,omitempty" bson:"query_text,omitempty"`
+	Fusion         string                 `json:"fusion,omitempty" bson:"fusion,omitempty"`
+	VectorWeight   float32                `json:"vector_weight,omitempty" bson:"vector_weight,omitempty"`
+	RRFConstant    int                    `json:"rrf_k,omitempty" bson:"rrf_k,omitempty"`
+}
+
+type searchRequest struct {
+	Text      string                 `json:"text" bson:"text"`
+	TopK      int32                  `json:"top_k" bson:"top_k"`
+	Filters   map[string]interface{} `json:"filters,omitempty" bson:"filters,omitempty"`
+	Fuzziness int                    `json:"fuzziness,omitempty" bson:"fuzziness,omitempty"`
+	Prefix    bool                   `json:"prefix,omitempty" bson:"prefix,omitempty"`
+}
+
+type queryResponse struct {
+	Documents []documentPayload `json:"documents" bson:"documents"`
+}
+
+func pathParam(ctx *fasthttp.RequestCtx, name string) string {
+	value, _ := ctx.UserValue(name).(string)
+	return value
+}
+
+func (a *apiServer) db(ctx *fasthttp.RequestCtx) dbservice.DBService {
+	return dbservice.DatabaseService(dbservice.DbParams{
+		Name:      pathParam(ctx, "db"),
+		KvService: a.kv,
+		Logger:    slog.Default(),
+		Indexes:   a.indexes,
+	})
+}
+
+// withDatabase rejects requests for databases that were never created, so
+// every handler below /databases/{db} can assume the database exists.
+func (a *apiServer) withDatabase(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		name := pathParam(ctx, "db")
+		_, found, err := dbservice.GetDatabase(a.kv, name)
+		if err != nil {
+			writeServiceError(ctx, err)
+			return
+		}
+		if !found {
+			writeError(ctx, fasthttp.StatusNotFound, CodeDatabaseNotFound, fmt.Sprintf("database %s does not exist", name))
+			return
+		}
+		next(ctx)
+	}
+}
+
+func (a *apiServer) listDatabases(ctx *fasthttp.RequestCtx) {
+	dbs, err := dbservice.ListDatabases(a.kv)
+	if err != nil {
+		writeServiceError(ctx, err)
+		return
+	}
+
+	resp := listDatabasesResponse{Databases: []databaseResponse{}}
+	for _, db := range dbs {
+		resp.Databases = append(resp.Databases, databaseResponse{Name: db.Name, UUID: db.UUID})
+	}
+	writeResponse(ctx, fasthttp.StatusOK, resp)
+}
+
+func (a *apiServer) createDatabase(ctx *fasthttp.RequestCtx) {
+	var req createDatabaseRequest
+	if !readBody(ctx, &req) {
+		return
+	}
+	if req.Name == "" {
+		writeError(ctx, fasthttp.StatusBadRequest, CodeInvalidRequest, "name is required")
+		return
+	}
+
+	svc := dbservice.DatabaseService(dbservice.DbParams{Name: req.Name, KvService: a.kv})
+	if err := svc.CreateDB(); err != nil {
+		writeServiceError(ctx, err)
+		return
+	}
+
+	db, _, err := dbservice.GetDatabase(a.kv, req.Name)
+	if err != nil {
+		writeServiceError(ctx, err)
+		return
+	}
+	writeResponse(ctx, fasthttp.StatusCreated, databaseResponse{Name: db.Name, UUID: db.UUID})
+}
+
+func (a *apiServer) dropDatabase(ctx *fasthttp.RequestCtx) {
+	if err := a.db(ctx).DeleteDB(); err != nil {
+		writeServiceError(ctx, err)
+		return
+	}
+	writeResponse(ctx, fasthttp.StatusOK, dropResponse{Dropped: pathParam(ctx, "db")})
+}
+
+func (a *apiServer) listCollections(ctx *fasthttp.RequestCtx) {
+	collections, err := a.db(ctx).ListCollections()
+	if err != nil {
+		writeServiceError(ctx, err)
+		return
+	}
+
+	prefix := pathParam(ctx, "db") + "."
+	resp := listCollectionsResponse{Collections: []collectionResponse{}}
+	for _, c := range collections {
+		var indexes []string
+		for _, index := range c.Indexes {
+			indexes = append(indexes, index.Name)
+		}
+		var provider string
+		if c.Embedder != nil {
+			provider = c.Embedder.Provider
+		}
+		createdAt := c.CreatedAt.Time().UTC()
+		resp.Collections = append(resp.Collections, collectionResponse{
+			Name:      strings.TrimPrefix(c.Ns, prefix),
+			ID:        c.Id.Hex(),
+			Indexes:   indexes,
+			Dimension: c.VectorIndex.Dimension,
+			Metric:    c.VectorIndex.Metric,
+			IndexType: c.VectorIndex.Factory,
+			Embedder:  provider,
+			CreatedAt: &createdAt,
+		})
+	}
+	writeResponse(ctx, fasthttp.StatusOK, resp)
+}
+
+func (a *apiServer) createCollection(ctx *fasthttp.RequestCtx) {
+	var req createCollectionRequest
+	if !readBody(ctx, &req) {
+		return
+	}
+	if req.Name == "" {
+		writeError(ctx, fasthttp.StatusBadRequest, CodeInvalidRequest, "name is required")
+		return
+	}
+
+	opts := dbservice.CollectionOptions{
+		Dimension:    req.Dimension,
+		Metric:       req.Metric,
+		IndexType:    req.IndexType,
+		TrainSize:    req.TrainSize,
+		TextAnalyzer: req.TextAnalyzer,
+		Schema:       req.Schema,
+	}
+	if req.Embedder != nil {
+		opts.Embedder = &embedder.Config{
+			Provider:  req.Embedder.Provider,
+			Model:     req.Embedder.Model,
+			BaseURL:   req.Embedder.BaseURL,
+			APIKeyEnv: req.Embedder.APIKeyEnv,
+			Dimension: req.Embedder.Dimension,
+		}
+	}
+
+	err := a.db(ctx).CreateCollection(req.Name, opts)
+	if err != nil {
+		writeServiceError(ctx, err)
+		return
+	}
+	writeResponse(ctx, fasthttp.StatusCreated, collectionResponse{Name: req.Name})
+}
+
+func (a *apiServer) dropCollection(ctx *fasthttp.RequestCtx) {
+	name := pathParam(ctx, "collection")
+	if err := a.db(ctx).DropCollection(name); err != nil {
+		writeServiceError(ctx, err)
+		return
+	}
+	writeResponse(ctx, fasthttp.StatusOK, dropResponse{Dropped: name})
+}
+
+func (a *apiServer) collectionStats(ctx *fasthttp.RequestCtx) {
+	stats, err := a.db(ctx).GetCollectionStats(pathParam(ctx, "collection"))
+	if err != nil {
+		writeServiceError(ctx, err)
+		return
+	}
+	writeResponse(ctx, fasthttp.StatusOK, statsResponse{
+		DocCount:        stats.Doc_Count,
+		VectorIndexSize: stats.Vector_Index_Size,
+		TombstoneCount:  stats.Tombstone_Count,
+	})
+}
+
+func (a *apiServer) insertDocuments(ctx *fasthttp.RequestCtx) {
+	var req insertDocumentsRequest
+	if !readBody(ctx, &req) {
+		return
+	}
+	if len(req.Documents) == 0 {
+		writeError(ctx, fasthttp.StatusBadRequest, CodeInvalidRequest, "documents must not be empty")
+		return
+	}
+
+	docs := make([]dbservice.GlowstickDocument, len(req.Documents))
+	for i, d := range req.Documents {
+		if len(d.Embedding) == 0 && d.Content == "" {
+			writeError(ctx, fasthttp.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("documents[%d] needs an embedding or content to embed", i))
+			return
+		}
+		docs[i] = dbservice.GlowstickDocument{
+			Content:   d.Content,
+			Embedding: d.Embedding,
+			Metadata:  d.Metadata,
+		}
+		// An _id that is not an ObjectID hex string is the caller's own key.
+		if id, err := primitive.ObjectIDFromHex(d.ID); err == nil {
+			docs[i].ID = id
+		} else {
+			docs[i].Key = d.ID
+		}
+	}
+
+	if err := a.db(ctx).InsertDocumentsIntoCollection(pathParam(ctx, "collection"), docs); err != nil {
+		writeServiceError(ctx, err)
+		return
+	}
+
+	resp := insertDocumentsResponse{Inserted: len(docs), IDs: make([]string, len(docs))}
+	for i, doc := range docs {
+		resp.IDs[i] = payloadID(doc)
+	}
+	writeResponse(ctx, fasthttp.StatusCreated, resp)
+}
+
+// documentID resolves the {id} path parameter, either an ObjectID hex string
+// or a key supplied at insert. It writes an error and returns false if the id
+// is malformed or, for a key, no document holds it.
+func (a *apiServer) documentID(ctx *fasthttp.RequestCtx) (primitive.ObjectID, bool) {
+	raw := pathParam(ctx, "id")
+	if id, err := primitive.ObjectIDFromHex(raw); err == nil {
+		return id, true
+	}
+	if raw == "" || len(raw) > dbservice.MaxDocumentKeyLength {
+		writeError(ctx, fasthttp.StatusBadRequest, CodeInvalidID, fmt.Sprintf("invalid document id %q", raw))
+		return primitive.NilObjectID, false
+	}
+
+	id, found, err := a.db(ctx).ResolveKey(pathParam(ctx, "collection"), raw)
+	if err != nil {
+		writeServiceError(ctx, err)
+		return id, false
+	}
+	if !found {
+		writeError(ctx, fasthttp.StatusNotFound, CodeDocumentNotFound, fmt.Sprintf("document %s does not exist", raw))
+		return id, false
+	}
+	return id, true
+}
+
+func (a *apiServer) getDocument(ctx *fasthttp.RequestCtx) {
+	id, ok := a.documentID(ctx)
+	if !ok {
+		return
+	}
+
+	doc, found, err := a.db(ctx).GetDocument(pathParam(ctx, "collection"), id)
+	if err != nil {
+		writeServiceError(ctx, err)
+		return
+	}
+	if !found {
+		writeError(ctx, fasthttp.StatusNotFound, CodeDocumentNotFound, fmt.Sprintf("document %s does not exist", id.Hex()))
+		return
+	}
+
+	writeResponse(ctx, fasthttp.StatusOK, toDocumentPayload(doc))
+}
+
+func (a *apiServer) deleteDocument(ctx *fasthttp.RequestCtx) {
+	id, ok := a.documentID(ctx)
+	if !ok {
+		return
+	}
+
+	deleted, err := a.db(ctx).DeleteDocuments(pathParam(ctx, "collection"), []primitive.ObjectID{id})
+	if err != nil {
+		writeServiceError(ctx, err)
+		return
+	}
+	if deleted == 0 {
+		writeError(ctx, fasthttp.StatusNotFound, CodeDocumentNotFound, fmt.Sprintf("document %s does not exist", id.Hex()))
+		return
+	}
+	writeResponse(ctx, fasthttp.StatusOK, deleteDocumentsResponse{Deleted: deleted})
+}
+
+func (a *apiServer) queryCollection(ctx *fasthttp.RequestCtx) {
+	var req queryRequest
+	if !readBody(ctx, &req) {
+		return
+	}
+	if req.TopK <= 0 {
+		writeError(ctx, fasthttp.StatusBadRequest, CodeInvalidRequest, "top_k must be positive")
+		return
+	}
+	if len(req.QueryEmbedding) == 0 && req.QueryContent == "" && req.QueryText == "" {
+		writeError(ctx, fasthttp.StatusBadRequest, CodeInvalidRequest, "one of query_embedding, query_content or query_text is required")
+		return
+	}
+
+	results, err := a.db(ctx).QueryCollection(pathParam(ctx, "collection"), dbservice.QueryStruct{
+		TopK:           req.TopK,
+		MaxDistance:    req.MaxDistance,
+		QueryEmbedding: req.QueryEmbedding,
+		Filters:        req.Filters,
+		QueryContent:   req.QueryContent,
+		QueryText:      req.QueryText,
+		Fusion:         req.Fusion,
+		VectorWeight:   req.VectorWeight,
+		RRFConstant:    req.RRFConstant,
+	})
+	if err != nil {
+		writeServiceError(ctx, err)
+		return
+	}
+
+	resp := queryResponse{Documents: make([]documentPayload, len(results))}
+	for i, result := range results {
+		payload := toDocumentPayload(result.Document)
+		payload.Distance = &result.Distance
+		payload.Score = &result.Score
+		payload.Label = &result.Label
+		if req.QueryText != "" {
+			payload.VectorScore = &result.VectorScore
+			payload.TextScore = &result.TextScore
+		}
+		resp.Documents[i] = payload
+	}
+	writeResponse(ctx, fasthttp.StatusOK, resp)
+}
+
+func (a *apiServer) searchCollection(ctx *fasthttp.RequestCtx) {
+	var req searchRequest
+	if !readBody(ctx, &req) {
+		return
+	}
+	if req.TopK <= 0 {
+		writeError(ctx, fasthttp.StatusBadRequest, CodeInvalidRequest, "top_k must be positive")
+		return
+	}
+	if strings.TrimSpace(req.Text) == "" {
+		writeError(ctx, fasthttp.StatusBadRequest, CodeInvalidRequest, "text must not be empty")
+		return
+	}
+
+	results, err := a.db(ctx).SearchText(pathParam(ctx, "collection"), dbservice.TextQueryStruct{
+		Text:      req.Text,
+		TopK:      req.TopK,
+		Filters:   req.Filters,
+		Fuzziness: req.Fuzziness,
+		Prefix:    req.Prefix,
+	})
+	if err != nil {
+		writeServiceError(ctx, err)
+		return
+	}
+
+	resp := queryResponse{Documents: make([]documentPayload, len(results))}
+	for i, result := range results {
+		payload := toDocumentPayload(result.Document)
+		payload.Score = &result.Score
+		resp.Documents[i] = payload
+	}
+	writeResponse(ctx, fasthttp.StatusOK, resp)
+}
+
+func toDocumentPayload(doc dbservice.GlowstickDocument) documentPayload {
+	return documentPayload{
+		ID:        payloadID(doc),
+		Content:   doc.Content,
+		Embedding: doc.Embedding,
+		Metadata:  doc.Metadata,
+	}
+}
+
+// payloadID is the _id a document is known by on the wire: its key if it was
+// given one, its ObjectID otherwise.
+func payloadID(doc dbservice.GlowstickDocument) string {
+	if doc.Key != "" {
+		return doc.Key
+	}
+	return doc.ID.Hex()
+}
+
+// MarshalJSON renders metadata decoded from BSON in its natural JSON form.
+func (d documentPayload) MarshalJSON() ([]byte, error) {
+	type plain documentPayload
+	p := plain(d)
+	p.Metadata = jsonValue(d.Metadata)
+	return json.Marshal(p)
+}
+
+// jsonValue converts the values bson.Unmarshal produces for interface{} fields
+// into their natural JSON form: documents become objects rather than key/value
+// arrays, ObjectIDs hex strings and datetimes RFC 3339 timestamps.
+func jsonValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case primitive.D:
+		out := make(map[string]interface{}, len(t))
+		for _, e := range t {
+			out[e.Key] = jsonValue(e.Value)
+		}
+		return out
+	case primitive.M:
+		out := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			out[k] = jsonValue(val)
+		}
+		return out
+	case primitive.A:
+		out := make([]interface{}, len(t))
+		for i, val := range t {
+			out[i] = jsonValue(val)
+		}
+		return out
+	case primitive.ObjectID:
+		return t.Hex()
+	case primitive.DateTime:
+		return t.Time().UTC().Format(time.RFC3339Nano)
+	}
+	return v
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+
+	dbservice "glowstickdb/pkgs/db_service"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Error codes returned in the "code" field of every API error response.
+const (
+	CodeInvalidRequest     = "INVALID_REQUEST"
+	CodeInvalidID          = "INVALID_ID"
+	CodeInvalidFilter      = "INVALID_FILTER"
+	CodeInvalidOptions     = "INVALID_OPTIONS"
+	CodeInvalidDocument    = "INVALID_DOCUMENT"
+	CodeDatabaseNotFound   = "DATABASE_NOT_FOUND"
+	CodeDatabaseExists     = "DATABASE_EXISTS"
+	CodeCollectionNotFound = "COLLECTION_NOT_FOUND"
+	CodeCollectionExists   = "COLLECTION_EXISTS"
+	CodeDocumentNotFound   = "DOCUMENT_NOT_FOUND"
+	CodeDuplicateKey       = "DUPLICATE_KEY"
+	CodeEmbeddingFailed    = "EMBEDDING_FAILED"
+	CodeInternal           = "INTERNAL"
+)
+
+type apiError struct {
+	Code    string `json:"code" bson:"code"`
+	Message string `json:"message" bson:"message"`
+
+	// Set for INVALID_DOCUMENT and DUPLICATE_KEY: one entry per rejected document.
+	Documents []documentErrorPayload `json:"documents,omitempty" bson:"documents,omitempty"`
+}
+
+type documentErrorPayload struct {
+	Index   int    `json:"index" bson:"index"`
+	ID      string `json:"_id,omitempty" bson:"_id,omitempty"`
+	Message string `json:"message" bson:"message"`
+}
+
+type errorResponse struct {
+	Error apiError `json:"error" bson:"error"`
+}
+
+// serviceErrors maps dbservice sentinel errors to an HTTP status and API code.
+// Errors that match none of them are reported as internal errors.
+var serviceErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{dbservice.ErrDatabaseNotFound, fasthttp.StatusNotFound, CodeDatabaseNotFound},
+	{dbservice.ErrDatabaseExists, fasthttp.StatusConflict, CodeDatabaseExists},
+	{dbservice.ErrCollectionNotFound, fasthttp.StatusNotFound, CodeCollectionNotFound},
+	{dbservice.ErrCollectionExists, fasthttp.StatusConflict, CodeCollectionExists},
+	{dbservice.ErrDocumentNotFound, fasthttp.StatusNotFound, CodeDocumentNotFound},
+	{dbservice.ErrInvalidFilter, fasthttp.StatusBadRequest, CodeInvalidFilter},
+	{dbservice.ErrInvalidName, fasthttp.StatusBadRequest, CodeInvalidRequest},
+	{dbservice.ErrInvalidOptions, fasthttp.StatusBadRequest, CodeInvalidOptions},
+	{dbservice.ErrInvalidQuery, fasthttp.StatusBadRequest, CodeInvalidRequest},
+	{dbservice.ErrInvalidDocument, fasthttp.StatusBadRequest, CodeInvalidDocument},
+	{dbservice.ErrDuplicateKey, fasthttp.StatusConflict, CodeDuplicateKey},
+	{dbservice.ErrNoEmbedder, fasthttp.StatusBadRequest, CodeInvalidRequest},
+	{dbservice.ErrEmbeddingFailed, fasthttp.StatusBadGateway, CodeEmbeddingFailed},
+}
+
+func writeError(ctx *fasthttp.RequestCtx, status int, code string, message string) {
+	writeResponse(ctx, status, errorResponse{Error: apiError{Code: code, Message: message}})
+}
+
+func writeServiceError(ctx *fasthttp.RequestCtx, err error) {
+	var batchErr *dbservice.BatchError
+	if errors.As(err, &batchErr) {
+		details := make([]documentErrorPayload, len(batchErr.Documents))
+		for i, doc := range batchErr.Documents {
+			details[i] = documentErrorPayload{Index: doc.Index, Message: doc.Err.Error()}
+			if !doc.Id.IsZero() {
+				details[i].ID = doc.Id.Hex()
+			}
+		}
+		status, code := fasthttp.StatusBadRequest, CodeInvalidDocument
+		if errors.Is(err, dbservice.ErrDuplicateKey) {
+			status, code = fasthttp.StatusConflict, CodeDuplicateKey
+		}
+		writeResponse(ctx, status, errorResponse{Error: apiError{Code: code, Message: err.Error(), Documents: details}})
+		return
+	}
+
+	for _, e := range serviceErrors {
+		if errors.Is(err, e.err) {
+			writeError(ctx, e.status, e.code, err.Error())
+			return
+		}
+	}
+	writeError(ctx, fasthttp.StatusInternalServerError, CodeInternal, err.Error())
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	dbservice "glowstickdb/pkgs/db_service"
+	"glowstickdb/pkgs/wiredtiger"
+
+	"github.com/fasthttp/router"
+	"github.com/valyala/fasthttp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const WIREDTIGER_TEST_DIR = "volumes/WT_HOME_TEST"
+
+func newTestRouter(t *testing.T) *router.Router {
+	wtService := wiredtiger.WiredTiger()
+
+	if mkErr := os.MkdirAll(WIREDTIGER_TEST_DIR, 0755); mkErr != nil {
+		t.Fatalf("failed to create WT_HOME_TEST dir: %v", mkErr)
+	}
+
+	if err := wtService.Open(WIREDTIGER_TEST_DIR, "create"); err != nil {
+		t.Fatalf("failed to open WiredTiger: %v", err)
+	}
+
+	if err := dbservice.InitTablesHelper(wtService); err != nil {
+		t.Fatalf("failed to init tables: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := wtService.Close(); err != nil {
+			fmt.Printf("Warning: failed to close connection: %v\n", err)
+		}
+		os.RemoveAll(WIREDTIGER_TEST_DIR)
+	})
+
+	// Registered last so it runs first: indexes are flushed before WiredTiger closes.
+	indexes := dbservice.NewIndexManager(dbservice.IndexManagerOptions{FlushPolicy: dbservice.FlushOnEvict})
+	t.Cleanup(func() {
+		if err := indexes.Close(); err != nil {
+			t.Errorf("failed to close index manager: %v", err)
+		}
+	})
+
+	return NewRouter(wtService, indexes)
+}
+
+func do(r *router.Router, method string, uri string, body interface{}) (int, map[string]interface{}) {
+	var ctx fasthttp.RequestCtx
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	if body != nil {
+		payload, _ := json.Marshal(body)
+		ctx.Request.SetBody(payload)
+	}
+
+	r.Handler(&ctx)
+
+	var resp map[string]interface{}
+	json.Unmarshal(ctx.Response.Body(), &resp)
+	return ctx.Response.StatusCode(), resp
+}
+
+// doBSON sends body as BSON and asks for a BSON response.
+func doBSON(r *router.Router, method string, uri string, body interface{}) (int, bson.Raw) {
+	var ctx fasthttp.RequestCtx
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	ctx.Request.Header.Set("Accept", contentTypeBSON)
+	if body != nil {
+		payload, _ := bson.Marshal(body)
+		ctx.Request.Header.SetContentType(contentTypeBSON)
+		ctx.Request.SetBody(payload)
+	}
+
+	r.Handler(&ctx)
+
+	return ctx.Response.StatusCode(), bson.Raw(append([]byte{}, ctx.Response.Body()...))
+}
+
+func errorCode(resp map[string]interface{}) string {
+	e, _ := resp["error"].(map[string]interface{})
+	code, _ := e["code"].(string)
+	return code
+}
+
+func TestAPIErrorCodes(t *testing.T) {
+	r := newTestRouter(t)
+
+	if status, resp := do(r, "POST", "/v1/databases", map[string]string{"name": "default"}); status != fasthttp.StatusCreated {
+		t.Fatalf("create database returned %d: %v", status, resp)
+	}
+
+	if status, resp := do(r, "POST", "/v1/databases", map[string]string{"name": "default"}); status != fasthttp.StatusConflict || errorCode(resp) != CodeDatabaseExists {
+		t.Errorf("duplicate database returned %d %s, want 409 %s", status, errorCode(resp), CodeDatabaseExists)
+	}
+
+	if status, resp := do(r, "POST", "/v1/databases/missing/collections", map[string]string{"name": "c"}); status != fasthttp.StatusNotFound || errorCode(resp) != CodeDatabaseNotFound {
+		t.Errorf("collection in missing database returned %d %s, want 404 %s", status, errorCode(resp), CodeDatabaseNotFound)
+	}
+
+	if status, resp := do(r, "GET", "/v1/databases/default/collections/missing/stats", nil); status != fasthttp.StatusNotFound || errorCode(resp) != CodeCollectionNotFound {
+		t.Errorf("stats of missing collection returned %d %s, want 404 %s", status, errorCode(resp), CodeCollectionNotFound)
+	}
+
+	if status, resp := do(r, "POST", "/v1/databases/default/collections", map[string]string{"name": "tenant_id_1"}); status != fasthttp.StatusCreated {
+		t.Fatalf("create collection returned %d: %v", status, resp)
+	}
+	t.Cleanup(func() { os.Remove("default.tenant_id_1.index") })
+
+	badSchema := map[string]interface{}{"name": "strict", "schema": map[string]interface{}{"type": "object", "properties": map[string]interface{}{"content": map[string]interface{}{"type": "text"}}}}
+	if status, resp := do(r, "POST", "/v1/databases/default/collections", badSchema); status != fasthttp.StatusBadRequest || errorCode(resp) != CodeInvalidOptions {
+		t.Errorf("collection with an invalid schema returned %d %s, want 400 %s", status, errorCode(resp), CodeInvalidOptions)
+	}
+
+	if status, resp := do(r, "POST", "/v1/databases/default/collections", map[string]string{"name": "tenant_id_1"}); status != fasthttp.StatusConflict || errorCode(resp) != CodeCollectionExists {
+		t.Errorf("duplicate collection returned %d %s, want 409 %s", status, errorCode(resp), CodeCollectionExists)
+	}
+
+	// Ids that are not ObjectIDs are looked up as keys.
+	if status, resp := do(r, "GET", "/v1/databases/default/collections/tenant_id_1/documents/not-an-id", nil); status != fasthttp.StatusNotFound || errorCode(resp) != CodeDocumentNotFound {
+		t.Errorf("unknown key returned %d %s, want 404 %s", status, errorCode(resp), CodeDocumentNotFound)
+	}
+	if status, resp := do(r, "GET", "/v1/databases/default/collections/tenant_id_1/documents/"+strings.Repeat("k", dbservice.MaxDocumentKeyLength+1), nil); status != fasthttp.StatusBadRequest || errorCode(resp) != CodeInvalidID {
+		t.Errorf("overlong id returned %d %s, want 400 %s", status, errorCode(resp), CodeInvalidID)
+	}
+
+	missing := "/v1/databases/default/collections/tenant_id_1/documents/" + primitive.NewObjectID().Hex()
+	if status, resp := do(r, "GET", missing, nil); status != fasthttp.StatusNotFound || errorCode(resp) != CodeDocumentNotFound {
+		t.Errorf("missing document returned %d %s, want 404 %s", status, errorCode(resp), CodeDocumentNotFound)
+	}
+
+	if status, resp := do(r, "POST", "/v1/databases/default/collections/tenant_id_1/documents", map[string]interface{}{}); status != fasthttp.StatusBadRequest || errorCode(resp) != CodeInvalidRequest {
+		t.Errorf("empty insert returned %d %s, want 400 %s", status, errorCode(resp), CodeInvalidRequest)
+	}
+
+	mixed := map[string]interface{}{"documents": []map[string]interface{}{
+		{"content": "a", "embedding": []float32{0.1, 0.2, 0.3}},
+		{"content": "b", "embedding": []float32{0.1, 0.2}},
+	}}
+	status, resp := do(r, "POST", "/v1/databases/default/collections/tenant_id_1/documents", mixed)
+	if status != fasthttp.StatusBadRequest || errorCode(resp) != CodeInvalidDocument {
+		t.Errorf("insert with mixed dimensions returned %d %s, want 400 %s", status, errorCode(resp), CodeInvalidDocument)
+	}
+	e, _ := resp["error"].(map[string]interface{})
+	if docs, _ := e["documents"].([]interface{}); len(docs) != 1 || docs[0].(map[string]interface{})["index"] != float64(1) {
+		t.Errorf("insert with mixed dimensions reported %v", e["documents"])
+	}
+
+	query := map[string]interface{}{
+		"top_k":           1,
+		"query_embedding": []float32{0.1, 0.2, 0.3},
+		"filters":         map[string]interface{}{"index": map[string]interface{}{"$regex": "x"}},
+	}
+	if status, resp := do(r, "POST", "/v1/databases/default/collections/tenant_id_1/query", query); status != fasthttp.StatusBadRequest || errorCode(resp) != CodeInvalidFilter {
+		t.Errorf("invalid filter returned %d %s, want 400 %s", status, errorCode(resp), CodeInvalidFilter)
+	}
+}
+
+func TestAPIInsertAndQuery(t *testing.T) {
+	r := newTestRouter(t)
+
+	do(r, "POST", "/v1/databases", map[string]string{"name": "default"})
+	do(r, "POST", "/v1/databases/default/collections", map[string]string{"name": "tenant_id_1"})
+	t.Cleanup(func() { os.Remove("default.tenant_id_1.index") })
+
+	insert := map[string]interface{}{
+		"documents": []map[string]interface{}{
+			{
+				"content":   "Example document",
+				"embedding": []float32{0.1, 0.2, 0.3},
+				"metadata":  map[string]interface{}{"type": "example", "tags": []string{"a", "b"}},
+			},
+		},
+	}
+	if status, resp := do(r, "POST", "/v1/databases/default/collections/tenant_id_1/documents", insert); status != fasthttp.StatusCreated || resp["inserted"] != float64(1) {
+		t.Fatalf("insert returned %d: %v", status, resp)
+	}
+
+	query := map[string]interface{}{
+		"top_k":           1,
+		"query_embedding": []float32{0.1, 0.2, 0.3},
+		"filters":         map[string]interface{}{"type": "example"},
+	}
+	status, resp := do(r, "POST", "/v1/databases/default/collections/tenant_id_1/query", query)
+	if status != fasthttp.StatusOK {
+		t.Fatalf("query returned %d: %v", status, resp)
+	}
+
+	docs, _ := resp["documents"].([]interface{})
+	if len(docs) != 1 {
+		t.Fatalf("query returned %d documents, want 1", len(docs))
+	}
+	doc := docs[0].(map[string]interface{})
+	if doc["content"] != "Example document" {
+		t.Errorf("query returned content %v", doc["content"])
+	}
+	if metadata, ok := doc["metadata"].(map[string]interface{}); !ok || metadata["type"] != "example" {
+		t.Errorf("metadata was not returned as a JSON object: %v", doc["metadata"])
+	}
+	distance, _ := doc["distance"].(float64)
+	score, _ := doc["score"].(float64)
+	if distance > 1e-5 || score < 0.999 || doc["label"] != float64(0) {
+		t.Errorf("exact match returned distance %v, score %v, label %v", doc["distance"], doc["score"], doc["label"])
+	}
+
+	hybrid := map[string]interface{}{"top_k": 1, "query_embedding": []float32{0.1, 0.2, 0.3}, "query_text": "example"}
+	status, resp = do(r, "POST", "/v1/databases/default/collections/tenant_id_1/query", hybrid)
+	if status != fasthttp.StatusOK {
+		t.Fatalf("hybrid query returned %d: %v", status, resp)
+	}
+	if docs, _ := resp["documents"].([]interface{}); len(docs) != 1 {
+		t.Errorf("hybrid query returned %v", resp["documents"])
+	} else if doc := docs[0].(map[string]interface{}); doc["vector_score"] == nil || doc["text_score"] == nil {
+		t.Errorf("hybrid query result lacks component scores: %v", doc)
+	}
+	hybrid["fusion"] = "borda"
+	if status, resp := do(r, "POST", "/v1/databases/default/collections/tenant_id_1/query", hybrid); status != fasthttp.StatusBadRequest || errorCode(resp) != CodeInvalidRequest {
+		t.Errorf("hybrid query with an unknown fusion returned %d: %v", status, resp)
+	}
+
+	search := map[string]interface{}{"text": "example", "top_k": 5}
+	status, resp = do(r, "POST", "/v1/databases/default/collections/tenant_id_1/search", search)
+	if status != fasthttp.StatusOK {
+		t.Fatalf("search returned %d: %v", status, resp)
+	}
+	if docs, _ := resp["documents"].([]interface{}); len(docs) != 1 || docs[0].(map[string]interface{})["content"] != "Example document" {
+		t.Errorf("search returned %v", resp["documents"])
+	}
+	if status, resp := do(r, "POST", "/v1/databases/default/collections/tenant_id_1/search", map[string]interface{}{"text": " ", "top_k": 5}); status != fasthttp.StatusBadRequest || errorCode(resp) != CodeInvalidRequest {
+		t.Errorf("empty search returned %d: %v", status, resp)
+	}
+	status, resp = do(r, "POST", "/v1/databases/default/collections/tenant_id_1/search", map[string]interface{}{"text": "exampel", "top_k": 5, "fuzziness": 2})
+	if docs, _ := resp["documents"].([]interface{}); status != fasthttp.StatusOK || len(docs) != 1 {
+		t.Errorf("fuzzy search returned %d: %v", status, resp)
+	}
+	if status, resp := do(r, "POST", "/v1/databases/default/collections/tenant_id_1/search", map[string]interface{}{"text": "example", "top_k": 5, "fuzziness": 3}); status != fasthttp.StatusBadRequest || errorCode(resp) != CodeInvalidRequest {
+		t.Errorf("search with fuzziness 3 returned %d: %v", status, resp)
+	}
+
+	status, resp = do(r, "GET", "/v1/databases/default/collections/tenant_id_1/stats", nil)
+	if status != fasthttp.StatusOK || resp["doc_count"] != float64(1) {
+		t.Errorf("stats returned %d: %v", status, resp)
+	}
+
+	status, resp = do(r, "GET", "/v1/databases", nil)
+	if dbs, _ := resp["databases"].([]interface{}); status != fasthttp.StatusOK || len(dbs) != 1 {
+		t.Errorf("list databases returned %d: %v", status, resp)
+	}
+
+	status, resp = do(r, "GET", "/v1/databases/default/collections", nil)
+	if colls, _ := resp["collections"].([]interface{}); status != fasthttp.StatusOK || len(colls) != 1 || colls[0].(map[string]interface{})["name"] != "tenant_id_1" {
+		t.Errorf("list collections returned %d: %v", status, resp)
+	}
+
+	if status, resp := do(r, "DELETE", "/v1/databases/default/collections/tenant_id_1", nil); status != fasthttp.StatusOK {
+		t.Errorf("drop collection returned %d: %v", status, resp)
+	}
+	if status, resp := do(r, "DELETE", "/v1/databases/default/collections/tenant_id_1", nil); status != fasthttp.StatusNotFound || errorCode(resp) != CodeCollectionNotFound {
+		t.Errorf("second drop collection returned %d %s, want 404 %s", status, errorCode(resp), CodeCollectionNotFound)
+	}
+
+	if status, resp := do(r, "DELETE", "/v1/databases/default", nil); status != fasthttp.StatusOK {
+		t.Errorf("drop database returned %d: %v", status, resp)
+	}
+	status, resp = do(r, "GET", "/v1/databases", nil)
+	if dbs, _ := resp["databases"].([]interface{}); status != fasthttp.StatusOK || len(dbs) != 0 {
+		t.Errorf("list databases after drop returned %d: %v", status, resp)
+	}
+}
+
+func TestAPIDocumentIDs(t *testing.T) {
+	r := newTestRouter(t)
+
+	do(r, "POST", "/v1/databases", map[string]string{"name": "default"})
+	do(r, "POST", "/v1/databases/default/collections", map[string]string{"name": "tenant_id_1"})
+	t.Cleanup(func() { os.Remove("default.tenant_id_1.index") })
+
+	objectID := primitive.NewObjectID().Hex()
+	insert := map[string]interface{}{"documents": []map[string]interface{}{
+		{"content": "generated", "embedding": []float32{0.1, 0.2, 0.3}},
+		{"_id": objectID, "content": "object id", "embedding": []float32{0.3, 0.2, 0.1}},
+		{"_id": "readme.md", "content": "keyed", "embedding": []float32{0.2, 0.2, 0.2}},
+	}}
+	status, resp := do(r, "POST", "/v1/databases/default/collections/tenant_id_1/documents", insert)
+	ids, _ := resp["ids"].([]interface{})
+	if status != fasthttp.StatusCreated || len(ids) != 3 {
+		t.Fatalf("insert returned %d: %v", status, resp)
+	}
+	if generated, _ := ids[0].(string); !primitive.IsValidObjectID(generated) || ids[1] != objectID || ids[2] != "readme.md" {
+		t.Errorf("insert returned ids %v", ids)
+	}
+
+	for i, content := range []string{"generated", "object id", "keyed"} {
+		status, resp := do(r, "GET", fmt.Sprintf("/v1/databases/default/collections/tenant_id_1/documents/%s", ids[i]), nil)
+		if status != fasthttp.StatusOK || resp["_id"] != ids[i] || resp["content"] != content {
+			t.Errorf("get document %v returned %d: %v", ids[i], status, resp)
+		}
+	}
+
+	again := map[string]interface{}{"documents": []map[string]interface{}{
+		{"_id": "readme.md", "content": "keyed again", "embedding": []float32{0.2, 0.2, 0.2}},
+	}}
+	status, resp = do(r, "POST", "/v1/databases/default/collections/tenant_id_1/documents", again)
+	if status != fasthttp.StatusConflict || errorCode(resp) != CodeDuplicateKey {
+		t.Errorf("insert of a taken key returned %d %s, want 409 %s", status, errorCode(resp), CodeDuplicateKey)
+	}
+	e, _ := resp["error"].(map[string]interface{})
+	if docs, _ := e["documents"].([]interface{}); len(docs) != 1 || docs[0].(map[string]interface{})["index"] != float64(0) {
+		t.Errorf("insert of a taken key reported %v", e["documents"])
+	}
+
+	if status, resp := do(r, "DELETE", "/v1/databases/default/collections/tenant_id_1/documents/readme.md", nil); status != fasthttp.StatusOK || resp["deleted"] != float64(1) {
+		t.Errorf("delete by key returned %d: %v", status, resp)
+	}
+	if status, resp := do(r, "GET", "/v1/databases/default/collections/tenant_id_1/documents/readme.md", nil); status != fasthttp.StatusNotFound || errorCode(resp) != CodeDocumentNotFound {
+		t.Errorf("get of a deleted key returned %d %s, want 404 %s", status, errorCode(resp), CodeDocumentNotFound)
+	}
+}
+
+func TestAPIEmbedder(t *testing.T) {
+	r := newTestRouter(t)
+
+	if status, resp := do(r, "POST", "/v1/databases", map[string]string{"name": "default"}); status != fasthttp.StatusCreated {
+		t.Fatalf("create database returned %d: %v", status, resp)
+	}
+
+	create := map[string]interface{}{
+		"name":     "notes",
+		"metric":   "cosine",
+		"embedder": map[string]interface{}{"provider": "hash", "dimension": 32},
+	}
+	if status, resp := do(r, "POST", "/v1/databases/default/collections", create); status != fasthttp.StatusCreated {
+		t.Fatalf("create collection returned %d: %v", status, resp)
+	}
+	t.Cleanup(func() { os.Remove("default.notes.index") })
+
+	bad := map[string]interface{}{"name": "bad", "embedder": map[string]interface{}{"provider": "nope"}}
+	if status, resp := do(r, "POST", "/v1/databases/default/collections", bad); status != fasthttp.StatusBadRequest || errorCode(resp) != CodeInvalidOptions {
+		t.Errorf("unknown embedding provider returned %d: %v", status, resp)
+	}
+
+	status, resp := do(r, "GET", "/v1/databases/default/collections", nil)
+	if colls, _ := resp["collections"].([]interface{}); status != fasthttp.StatusOK || len(colls) != 1 || colls[0].(map[string]interface{})["embedder"] != "hash" || colls[0].(map[string]interface{})["dimension"] != float64(32) {
+		t.Errorf("list collections returned %d: %v", status, resp)
+	}
+
+	insert := map[string]interface{}{"documents": []map[string]interface{}{
+		{"content": "red apples and green pears"},
+		{"content": "fast cars on open roads"},
+	}}
+	if status, resp := do(r, "POST", "/v1/databases/default/collections/notes/documents", insert); status != fasthttp.StatusCreated {
+		t.Fatalf("insert returned %d: %v", status, resp)
+	}
+	empty := map[string]interface{}{"documents": []map[string]interface{}{{"metadata": map[string]interface{}{"a": 1}}}}
+	if status, resp := do(r, "POST", "/v1/databases/default/collections/notes/documents", empty); status != fasthttp.StatusBadRequest || errorCode(resp) != CodeInvalidRequest {
+		t.Errorf("insert without content or embedding returned %d: %v", status, resp)
+	}
+
+	status, resp = do(r, "POST", "/v1/databases/default/collections/notes/query", map[string]interface{}{"top_k": 1, "query_content": "open roads"})
+	if docs, _ := resp["documents"].([]interface{}); status != fasthttp.StatusOK || len(docs) != 1 || docs[0].(map[string]interface{})["content"] != "fast cars on open roads" {
+		t.Errorf("query by content returned %d: %v", status, resp)
+	}
+}
+
+func TestVectorBSONForms(t *testing.T) {
+	want := vector{0.5, -1.25, 3}
+
+	encoded, err := bson.Marshal(bson.M{"v": want})
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	if typ := bson.Raw(encoded).Lookup("v").Type; typ != bson.TypeBinary {
+		t.Errorf("vector encoded as BSON %s, want binary", typ)
+	}
+
+	forms := map[string][]byte{"binary": encoded}
+	forms["array"], _ = bson.Marshal(bson.M{"v": bson.A{0.5, -1.25, int32(3)}})
+
+	for name, doc := range forms {
+		var got struct {
+			V vector `bson:"v"`
+		}
+		if err := bson.Unmarshal(doc, &got); err != nil {
+			t.Fatalf("%s: unmarshal failed: %v", name, err)
+		}
+		if fmt.Sprint(got.V) != fmt.Sprint(want) {
+			t.Errorf("%s: got %v, want %v", name, got.V, want)
+		}
+	}
+
+	bad, _ := bson.Marshal(bson.M{"v": primitive.Binary{Data: []byte{1, 2, 3}}})
+	var got struct {
+		V vector `bson:"v"`
+	}
+	if err := bson.Unmarshal(bad, &got); err == nil {
+		t.Errorf("expected an error for a binary vector that is not a multiple of 4 bytes")
+	}
+}
+
+func TestWantsBSON(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"":                                   false,
+		"*/*":                                false,
+		"application/json":                   false,
+		"application/bson":                   true,
+		"Application/BSON":                   true,
+		"application/bson; charset=utf-8":    true,
+		"application/bson;q=0":               false,
+		"application/bson;q=0, */*":          false,
+		"application/json, application/bson": false,
+		"application/json;q=0.5, application/bson":    true,
+		"application/bson;q=0.5, application/json":    false,
+		"application/bson, */*;q=0.1":                 true,
+		"application/*;q=0.2, application/bson;q=0.8": true,
+		"application/bson;q=abc":                      false,
+		"text/html, application/bsonx":                false,
+	} {
+		var ctx fasthttp.RequestCtx
+		if accept != "" {
+			ctx.Request.Header.Set("Accept", accept)
+		}
+		if got := wantsBSON(&ctx); got != want {
+			t.Errorf("wantsBSON with Accept %q = %v, want %v", accept, got, want)
+		}
+	}
+}
+
+func TestAPIBSONInsertAndQuery(t *testing.T) {
+	r := newTestRouter(t)
+
+	do(r, "POST", "/v1/databases", map[string]string{"name": "default"})
+	do(r, "POST", "/v1/databases/default/collections", map[string]string{"name": "tenant_id_1"})
+	t.Cleanup(func() { os.Remove("default.tenant_id_1.index") })
+
+	embedding := vector{0.1, 0.2, 0.3}
+	insert := bson.M{
+		"documents": bson.A{
+			bson.M{"content": "Example document", "embedding": embedding, "metadata": bson.M{"type": "example"}},
+		},
+	}
+	status, resp := doBSON(r, "POST", "/v1/databases/default/collections/tenant_id_1/documents", insert)
+	if status != fasthttp.StatusCreated {
+		t.Fatalf("BSON insert returned %d: %v", status, resp)
+	}
+	if inserted, _ := resp.Lookup("inserted").AsInt64OK(); inserted != 1 {
+		t.Errorf("BSON insert reported %v inserted documents", resp.Lookup("inserted"))
+	}
+
+	query := bson.M{"top_k": 1, "query_embedding": embedding, "filters": bson.M{"type": "example"}}
+	status, resp = doBSON(r, "POST", "/v1/databases/default/collections/tenant_id_1/query", query)
+	if status != fasthttp.StatusOK {
+		t.Fatalf("BSON query returned %d: %v", status, resp)
+	}
+
+	var result struct {
+		Documents []documentPayload `bson:"documents"`
+	}
+	if err := bson.Unmarshal(resp, &result); err != nil {
+		t.Fatalf("failed to decode BSON query response: %v", err)
+	}
+	if len(result.Documents) != 1 {
+		t.Fatalf("BSON query returned %d documents, want 1", len(result.Documents))
+	}
+	if doc := result.Documents[0]; doc.Content != "Example document" || fmt.Sprint(doc.Embedding) != fmt.Sprint(embedding) {
+		t.Errorf("BSON query returned %+v", doc)
+	}
+
+	status, resp = doBSON(r, "GET", "/v1/databases/default/collections/missing/stats", nil)
+	if code, _ := resp.Lookup("error", "code").StringValueOK(); status != fasthttp.StatusNotFound || code != CodeCollectionNotFound {
+		t.Errorf("BSON error response was %d %v", status, resp)
+	}
+}
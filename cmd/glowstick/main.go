@@ -0,0 +1,178 @@
+// Command glowstick dumps collections to, and restores them from, JSONL or
+// BSON files:
+//
+//	glowstick dump -db shop -collection products -out products.jsonl
+//	glowstick restore -db shop -in products.jsonl [-collection products_copy]
+//
+// It opens the WiredTiger home itself, so the server must not be running on it.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	dbservice "glowstickdb/pkgs/db_service"
+	"glowstickdb/pkgs/wiredtiger"
+)
+
+// defaultHome is the server's WiredTiger home, relative to the repository root.
+const defaultHome = "volumes/WT_HOME"
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage:
+  glowstick dump    -db NAME -collection NAME [-out FILE] [-format jsonl|bson] [-home DIR]
+  glowstick restore -db NAME [-collection NAME] [-in FILE] [-format jsonl|bson] [-batch N] [-home DIR]
+
+A dump is the collection's settings followed by its documents, embeddings
+included. -format defaults to bson for .bson files and to jsonl otherwise;
+FILE defaults to stdout or stdin. Stop the server before running either.
+`)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "dump":
+		err = dump(os.Args[2:])
+	case "restore":
+		err = restore(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		usage()
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "glowstick %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func dump(args []string) error {
+	flags := flag.NewFlagSet("dump", flag.ExitOnError)
+	home := flags.String("home", defaultHome, "WiredTiger home directory")
+	db := flags.String("db", "", "database name")
+	collection := flags.String("collection", "", "collection to dump")
+	outFile := flags.String("out", "", "output file (default stdout)")
+	format := flags.String("format", "", "dump format, jsonl or bson (default from the file name)")
+	flags.Parse(args)
+
+	if *db == "" || *collection == "" {
+		return errors.New("-db and -collection are required")
+	}
+
+	kv, err := openHome(*home)
+	if err != nil {
+		return err
+	}
+	defer kv.Close()
+
+	out := os.Stdout
+	if *outFile != "" {
+		if out, err = os.Create(*outFile); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+
+	svc := dbservice.DatabaseService(dbservice.DbParams{Name: *db, KvService: kv})
+	n, err := svc.DumpCollection(*collection, out, dumpFormat(*format, *outFile))
+	if err != nil {
+		return err
+	}
+	if out != os.Stdout {
+		if err := out.Close(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "dumped %d documents from %s.%s\n", n, *db, *collection)
+	return nil
+}
+
+func restore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	home := flags.String("home", defaultHome, "WiredTiger home directory")
+	db := flags.String("db", "", "database name, created if missing")
+	collection := flags.String("collection", "", "collection to create (default the dumped collection's name)")
+	inFile := flags.String("in", "", "input file (default stdin)")
+	format := flags.String("format", "", "dump format, jsonl or bson (default from the file name)")
+	batch := flags.Int("batch", dbservice.DefaultBulkBatchSize, "documents loaded per batch")
+	flags.Parse(args)
+
+	if *db == "" {
+		return errors.New("-db is required")
+	}
+
+	kv, err := openHome(*home)
+	if err != nil {
+		return err
+	}
+	defer kv.Close()
+
+	in := io.Reader(os.Stdin)
+	if *inFile != "" {
+		file, err := os.Open(*inFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	svc := dbservice.DatabaseService(dbservice.DbParams{Name: *db, KvService: kv})
+	if err := svc.CreateDB(); err != nil && !errors.Is(err, dbservice.ErrDatabaseExists) {
+		return err
+	}
+
+	n, err := svc.RestoreCollection(*collection, in, dumpFormat(*format, *inFile), dbservice.BulkInsertOptions{
+		BatchSize: *batch,
+		Progress: func(p dbservice.BulkProgress) {
+			fmt.Fprintf(os.Stderr, "restored %d documents (%d indexed) in %s\n", p.Inserted, p.Indexed, p.Elapsed.Round(time.Millisecond))
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "restored %d documents into database %s\n", n, *db)
+	return nil
+}
+
+// openHome opens the WiredTiger home and makes sure its system tables exist.
+func openHome(home string) (wiredtiger.WTService, error) {
+	kv := wiredtiger.WiredTiger()
+	if err := kv.Open(home, "create"); err != nil {
+		return nil, fmt.Errorf("failed to open WiredTiger at %s: %v", home, err)
+	}
+	if err := dbservice.InitTablesHelper(kv); err != nil {
+		kv.Close()
+		return nil, fmt.Errorf("failed to initialise system tables: %v", err)
+	}
+	return kv, nil
+}
+
+// dumpFormat is the format given with -format or, failing that, the one the
+// file name suggests.
+func dumpFormat(format, file string) string {
+	if format != "" {
+		return format
+	}
+	if strings.EqualFold(filepath.Ext(file), ".bson") {
+		return dbservice.DumpFormatBSON
+	}
+	return dbservice.DumpFormatJSONL
+}
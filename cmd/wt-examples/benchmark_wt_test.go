@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/binary"
+	"glowstickdb/pkgs/wiredtiger"
+	"math/rand"
+	"testing"
+)
+
+const (
+	benchTable   = "table:bench"
+	benchRecords = 10000
+)
+
+// Session pool sizes compared by the benchmarks. perCallSessions disables
+// the pool, so that every call opens and closes a session and cursor of its
+// own, as the wrapper did before sessions were pooled.
+const (
+	pooledSessions  = wiredtiger.DefaultSessionPoolSize
+	perCallSessions = -1
+)
+
+func benchKey(i int) []byte {
+	key := make([]byte, 12)
+	binary.BigEndian.PutUint64(key[4:], uint64(i))
+	return key
+}
+
+// openBenchService opens a WiredTiger home in a temporary directory holding
+// benchRecords rows of 256 bytes.
+func openBenchService(b *testing.B, poolSize int) wiredtiger.WTService {
+	service := wiredtiger.WiredTigerWithOptions(wiredtiger.ServiceOptions{SessionPoolSize: poolSize})
+	if err := service.Open(b.TempDir(), "create,cache_size=256M"); err != nil {
+		b.Fatalf("Open failed: %v", err)
+	}
+	b.Cleanup(func() { service.Close() })
+	if err := service.CreateTable(benchTable, "key_format=u,value_format=u"); err != nil {
+		b.Fatalf("CreateTable failed: %v", err)
+	}
+	value := make([]byte, 256)
+	for i := 0; i < benchRecords; i++ {
+		if err := service.PutBinary(benchTable, benchKey(i), value); err != nil {
+			b.Fatalf("PutBinary failed: %v", err)
+		}
+	}
+	return service
+}
+
+func benchGetBinary(b *testing.B, poolSize int) {
+	service := openBenchService(b, poolSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, found, err := service.GetBinary(benchTable, benchKey(rand.Intn(benchRecords))); err != nil || !found {
+			b.Fatalf("GetBinary returned found=%v, err=%v", found, err)
+		}
+	}
+}
+
+func benchGetBinaryParallel(b *testing.B, poolSize int) {
+	service := openBenchService(b, poolSize)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, found, err := service.GetBinary(benchTable, benchKey(rand.Intn(benchRecords))); err != nil || !found {
+				b.Errorf("GetBinary returned found=%v, err=%v", found, err)
+				return
+			}
+		}
+	})
+}
+
+func benchExistsBinary(b *testing.B, poolSize int) {
+	service := openBenchService(b, poolSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Half of the probes miss.
+		if _, err := service.ExistsBinary(benchTable, benchKey(rand.Intn(2*benchRecords))); err != nil {
+			b.Fatalf("ExistsBinary failed: %v", err)
+		}
+	}
+}
+
+func benchPutBinary(b *testing.B, poolSize int) {
+	service := openBenchService(b, poolSize)
+	value := make([]byte, 256)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := service.PutBinary(benchTable, benchKey(rand.Intn(benchRecords)), value); err != nil {
+			b.Fatalf("PutBinary failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkGetBinaryPooled(b *testing.B)          { benchGetBinary(b, pooledSessions) }
+func BenchmarkGetBinaryPerCall(b *testing.B)         { benchGetBinary(b, perCallSessions) }
+func BenchmarkGetBinaryParallelPooled(b *testing.B)  { benchGetBinaryParallel(b, pooledSessions) }
+func BenchmarkGetBinaryParallelPerCall(b *testing.B) { benchGetBinaryParallel(b, perCallSessions) }
+func BenchmarkExistsBinaryPooled(b *testing.B)       { benchExistsBinary(b, pooledSessions) }
+func BenchmarkExistsBinaryPerCall(b *testing.B)      { benchExistsBinary(b, perCallSessions) }
+func BenchmarkPutBinaryPooled(b *testing.B)          { benchPutBinary(b, pooledSessions) }
+func BenchmarkPutBinaryPerCall(b *testing.B)         { benchPutBinary(b, perCallSessions) }
@@ -2,19 +2,77 @@ package main
 
 import (
 	"fmt"
+	"log"
+	"log/slog"
+
+	dbservice "glowstickdb/pkgs/db_service"
+	"glowstickdb/pkgs/wiredtiger"
 
 	"github.com/fasthttp/router"
 	"github.com/valyala/fasthttp"
 )
 
+// WIREDTIGER_HOME is where the server keeps its WiredTiger files.
+const WIREDTIGER_HOME = "volumes/WT_HOME"
+
+// INDEX_MEMORY_BUDGET is how many bytes of vector indexes the server keeps loaded.
+const INDEX_MEMORY_BUDGET = 1 << 30
+
 func main() {
 	StartServer()
 }
 
 func StartServer() {
+	kv := wiredtiger.WiredTiger()
+
+	if err := kv.Open(WIREDTIGER_HOME, "create"); err != nil {
+		log.Fatalf("failed to open WiredTiger at %s: %v", WIREDTIGER_HOME, err)
+	}
+	defer kv.Close()
+
+	if err := dbservice.InitTablesHelper(kv); err != nil {
+		log.Fatalf("failed to initialise system tables: %v", err)
+	}
+
+	// Indexes are written out periodically rather than after every insert;
+	// the vector WAL holds their vectors until then.
+	indexes := dbservice.NewIndexManager(dbservice.IndexManagerOptions{
+		MemoryBudget: INDEX_MEMORY_BUDGET,
+		FlushPolicy:  dbservice.FlushPeriodically,
+		Logger:       slog.Default(),
+	})
+	defer indexes.Close()
+
+	r := NewRouter(kv, indexes)
+	fmt.Println("Server running on http://localhost:8080")
+	if err := fasthttp.ListenAndServe(":8080", r.Handler); err != nil {
+		log.Fatalf("server stopped: %v", err)
+	}
+}
+
+// NewRouter registers the REST API on top of an open WiredTiger connection and
+// the index manager shared by its requests.
+func NewRouter(kv wiredtiger.WTService, indexes *dbservice.IndexManager) *router.Router {
+	api := &apiServer{kv: kv, indexes: indexes}
+
 	r := router.New()
 	r.GET("/", helloHandler)
-	r.POST("/bson", bsonHandler)
-	fmt.Println("Server running on http://localhost:8080")
-	fasthttp.ListenAndServe(":8080", r.Handler)
+
+	v1 := r.Group("/v1")
+	v1.GET("/databases", api.listDatabases)
+	v1.POST("/databases", api.createDatabase)
+	v1.DELETE("/databases/{db}", api.withDatabase(api.dropDatabase))
+
+	v1.GET("/databases/{db}/collections", api.withDatabase(api.listCollections))
+	v1.POST("/databases/{db}/collections", api.withDatabase(api.createCollection))
+	v1.DELETE("/databases/{db}/collections/{collection}", api.withDatabase(api.dropCollection))
+	v1.GET("/databases/{db}/collections/{collection}/stats", api.withDatabase(api.collectionStats))
+
+	v1.POST("/databases/{db}/collections/{collection}/documents", api.withDatabase(api.insertDocuments))
+	v1.GET("/databases/{db}/collections/{collection}/documents/{id}", api.withDatabase(api.getDocument))
+	v1.DELETE("/databases/{db}/collections/{collection}/documents/{id}", api.withDatabase(api.deleteDocument))
+	v1.POST("/databases/{db}/collections/{collection}/query", api.withDatabase(api.queryCollection))
+	v1.POST("/databases/{db}/collections/{collection}/search", api.withDatabase(api.searchCollection))
+
+	return r
 }
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	contentTypeJSON = "application/json"
+	contentTypeBSON = "application/bson"
+)
+
+// isBSONRequest reports whether the request body is BSON, per its Content-Type.
+func isBSONRequest(ctx *fasthttp.RequestCtx) bool {
+	return bytes.HasPrefix(ctx.Request.Header.ContentType(), []byte(contentTypeBSON))
+}
+
+// wantsBSON reports whether the client prefers a BSON response, i.e. whether
+// its Accept header gives BSON a higher q-value than JSON. JSON stays the
+// default for clients that send no Accept header, accept anything, or rank
+// both equally.
+func wantsBSON(ctx *fasthttp.RequestCtx) bool {
+	accept := string(ctx.Request.Header.Peek("Accept"))
+	return acceptQuality(accept, contentTypeBSON) > acceptQuality(accept, contentTypeJSON)
+}
+
+// acceptQuality returns the q-value accept gives mediaType: that of the most
+// specific media range matching it, or 0 if none does. Ranges with a
+// malformed q-value are ignored.
+func acceptQuality(accept string, mediaType string) float64 {
+	typ, _, _ := strings.Cut(mediaType, "/")
+
+	quality, specificity := 0.0, -1
+ranges:
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+
+		var s int
+		switch strings.ToLower(strings.TrimSpace(params[0])) {
+		case mediaType:
+			s = 2
+		case typ + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		default:
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(param, "=")
+			if !strings.EqualFold(strings.TrimSpace(name), "q") {
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || v < 0 || v > 1 {
+				continue ranges
+			}
+			q = v
+		}
+
+		if s > specificity || (s == specificity && q > quality) {
+			quality, specificity = q, s
+		}
+	}
+	return quality
+}
+
+// readBody decodes the request body into v as BSON or JSON depending on its
+// Content-Type, writing an INVALID_REQUEST error and returning false if it
+// cannot be decoded.
+func readBody(ctx *fasthttp.RequestCtx, v interface{}) bool {
+	body := ctx.PostBody()
+
+	if isBSONRequest(ctx) {
+		if err := bson.Raw(body).Validate(); err != nil {
+			writeError(ctx, fasthttp.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid BSON body: %v", err))
+			return false
+		}
+		if err := bson.Unmarshal(body, v); err != nil {
+			writeError(ctx, fasthttp.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("failed to decode BSON body: %v", err))
+			return false
+		}
+		return true
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid JSON body: %v", err))
+		return false
+	}
+	return true
+}
+
+// writeResponse encodes v as BSON or JSON depending on the Accept header.
+func writeResponse(ctx *fasthttp.RequestCtx, status int, v interface{}) {
+	contentType := contentTypeJSON
+	marshal := json.Marshal
+	if wantsBSON(ctx) {
+		contentType = contentTypeBSON
+		marshal = bson.Marshal
+	}
+
+	body, err := marshal(v)
+	if err != nil {
+		ctx.Error("Failed to encode response", fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType(contentType)
+	ctx.SetStatusCode(status)
+	ctx.SetBody(body)
+}
+
+// vector is an embedding in a request or response body. In JSON it is an
+// array of numbers. In BSON it is written as generic binary holding
+// little-endian float32s, and read from either that form or an array of numbers.
+type vector []float32
+
+func (v vector) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	data := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(f))
+	}
+	return bson.MarshalValue(primitive.Binary{Subtype: bson.TypeBinaryGeneric, Data: data})
+}
+
+func (v *vector) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	raw := bson.RawValue{Type: t, Value: data}
+
+	switch t {
+	case bson.TypeNull:
+		*v = nil
+		return nil
+
+	case bson.TypeBinary:
+		_, b := raw.Binary()
+		if len(b)%4 != 0 {
+			return fmt.Errorf("binary vector length %d is not a multiple of 4", len(b))
+		}
+		out := make(vector, len(b)/4)
+		for i := range out {
+			out[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+		}
+		*v = out
+		return nil
+
+	case bson.TypeArray:
+		values, err := raw.Array().Values()
+		if err != nil {
+			return err
+		}
+		out := make(vector, len(values))
+		for i, value := range values {
+			if f, ok := value.DoubleOK(); ok {
+				out[i] = float32(f)
+			} else if n, ok := value.AsInt64OK(); ok {
+				out[i] = float32(n)
+			} else {
+				return fmt.Errorf("vector element %d is a %s, not a number", i, value.Type)
+			}
+		}
+		*v = out
+		return nil
+	}
+
+	return fmt.Errorf("cannot decode a vector from BSON %s", t)
+}
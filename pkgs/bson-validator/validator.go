@@ -1,45 +1,338 @@
 package bsonvalidator
 
 import (
-	"errors"
-	"reflect"
+	"fmt"
+	"math"
 	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 )
 
-type GlowStickCollection struct {
-	Name [32]byte `bson:"name" required:"true maxlen:32 type:string"`
-	Mode [7]byte  `bson:"mode" required:"false maxlen:7 type:string"`
-}
-
-// ValidateBson validates a map[string]interface{} (i.e. BSON doc) against the UserSchema.
-func ValidateBson(doc map[string]interface{}) error {
-	schema := reflect.TypeOf(GlowStickCollection{})
-	for i := 0; i < schema.NumField(); i++ {
-		field := schema.Field(i)
-		key := field.Tag.Get("bson")
-		required := field.Tag.Get("required") == "true"
-		maxlenTag := field.Tag.Get("maxlen")
-		var maxlen int
-		if maxlenTag != "" {
-			var err error
-			maxlen, err = strconv.Atoi(maxlenTag)
-			if err != nil {
-				return errors.New("invalid maxlen tag value for field: " + key)
-			}
+// Types a Schema can require. They follow JSON Schema, plus the BSON-only
+// objectId and date.
+const (
+	TypeObject   = "object"
+	TypeArray    = "array"
+	TypeString   = "string"
+	TypeNumber   = "number"  // any double, int32, int64 or decimal128
+	TypeInteger  = "integer" // int32, int64, or a double without a fraction
+	TypeBoolean  = "boolean"
+	TypeNull     = "null"
+	TypeObjectID = "objectId"
+	TypeDate     = "date"
+)
+
+// Schema is the subset of JSON Schema documents are validated against. Every
+// keyword is optional; an empty Schema accepts any value.
+type Schema struct {
+	Type string `json:"type,omitempty" bson:"type,omitempty"`
+
+	// Objects.
+	Required             []string           `json:"required,omitempty" bson:"required,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty" bson:"properties,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty" bson:"additionalProperties,omitempty"` // false rejects fields not in Properties
+
+	// Arrays.
+	Items    *Schema `json:"items,omitempty" bson:"items,omitempty"`
+	MinItems *int    `json:"minItems,omitempty" bson:"minItems,omitempty"`
+	MaxItems *int    `json:"maxItems,omitempty" bson:"maxItems,omitempty"`
+
+	// Strings, measured in characters.
+	MinLength *int `json:"minLength,omitempty" bson:"minLength,omitempty"`
+	MaxLength *int `json:"maxLength,omitempty" bson:"maxLength,omitempty"`
+
+	// Numbers.
+	Minimum          *float64 `json:"minimum,omitempty" bson:"minimum,omitempty"`
+	Maximum          *float64 `json:"maximum,omitempty" bson:"maximum,omitempty"`
+	ExclusiveMinimum *float64 `json:"exclusiveMinimum,omitempty" bson:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum *float64 `json:"exclusiveMaximum,omitempty" bson:"exclusiveMaximum,omitempty"`
+
+	Enum []interface{} `json:"enum,omitempty" bson:"enum,omitempty"`
+}
+
+// FieldError is one way a value failed its schema. Path locates the value in
+// the document, e.g. "metadata.tags[2]"; it is empty for the document itself.
+type FieldError struct {
+	Path    string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	if e.Path == "" {
+		return "document: " + e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// ValidationError lists every way a document failed its schema.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Check reports whether the schema itself is well formed: known types,
+// non-negative lengths and bounds that leave room for some value.
+func (s *Schema) Check() error {
+	return s.check("")
+}
+
+func (s *Schema) check(path string) error {
+	if s == nil {
+		return nil
+	}
+	at := func(format string, args ...interface{}) error {
+		if path == "" {
+			return fmt.Errorf("schema: "+format, args...)
 		}
+		return fmt.Errorf("schema of "+path+": "+format, args...)
+	}
 
-		val, exists := doc[key]
-		if required && !exists {
-			return errors.New("missing required field: " + key)
+	switch s.Type {
+	case "", TypeObject, TypeArray, TypeString, TypeNumber, TypeInteger, TypeBoolean, TypeNull, TypeObjectID, TypeDate:
+	default:
+		return at("unknown type %q", s.Type)
+	}
+
+	for _, bound := range []struct {
+		name  string
+		value *int
+	}{{"minItems", s.MinItems}, {"maxItems", s.MaxItems}, {"minLength", s.MinLength}, {"maxLength", s.MaxLength}} {
+		if bound.value != nil && *bound.value < 0 {
+			return at("%s must not be negative, got %d", bound.name, *bound.value)
+		}
+	}
+	if s.MinItems != nil && s.MaxItems != nil && *s.MinItems > *s.MaxItems {
+		return at("minItems %d exceeds maxItems %d", *s.MinItems, *s.MaxItems)
+	}
+	if s.MinLength != nil && s.MaxLength != nil && *s.MinLength > *s.MaxLength {
+		return at("minLength %d exceeds maxLength %d", *s.MinLength, *s.MaxLength)
+	}
+	if s.Minimum != nil && s.Maximum != nil && *s.Minimum > *s.Maximum {
+		return at("minimum %g exceeds maximum %g", *s.Minimum, *s.Maximum)
+	}
+
+	for name, property := range s.Properties {
+		if err := property.check(joinPath(path, name)); err != nil {
+			return err
 		}
-		if exists {
-			if reflect.TypeOf(val).Kind() != reflect.String {
-				return errors.New("field " + key + " must be a string")
+	}
+	return s.Items.check(path + "[]")
+}
+
+// Validate checks a BSON document against the schema and returns a
+// *ValidationError listing every violation, or nil.
+func Validate(schema *Schema, doc bson.Raw) error {
+	if schema == nil {
+		return nil
+	}
+	if err := doc.Validate(); err != nil {
+		return fmt.Errorf("malformed BSON document: %w", err)
+	}
+
+	v := &validator{}
+	v.value(schema, "", bson.RawValue{Type: bsontype.EmbeddedDocument, Value: doc})
+	if len(v.errs) > 0 {
+		return &ValidationError{Fields: v.errs}
+	}
+	return nil
+}
+
+// ValidateDocument marshals doc to BSON, as it would be stored, and validates it.
+func ValidateDocument(schema *Schema, doc interface{}) error {
+	if schema == nil {
+		return nil
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to marshal document to BSON: %w", err)
+	}
+	return Validate(schema, raw)
+}
+
+type validator struct {
+	errs []FieldError
+}
+
+func (v *validator) fail(path string, format string, args ...interface{}) {
+	v.errs = append(v.errs, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) value(s *Schema, path string, val bson.RawValue) {
+	if s.Type != "" && !hasType(val, s.Type) {
+		v.fail(path, "must be of type %s, got %s", s.Type, typeName(val))
+		return
+	}
+
+	if len(s.Enum) > 0 && !inEnum(val, s.Enum) {
+		v.fail(path, "must be one of %v", s.Enum)
+	}
+
+	switch val.Type {
+	case bsontype.EmbeddedDocument:
+		v.object(s, path, val.Document())
+	case bsontype.Array:
+		v.array(s, path, val.Array())
+	case bsontype.String:
+		v.string(s, path, val.StringValue())
+	case bsontype.Double, bsontype.Int32, bsontype.Int64, bsontype.Decimal128:
+		if n, ok := numberValue(val); ok {
+			v.number(s, path, n)
+		}
+	}
+}
+
+func (v *validator) object(s *Schema, path string, doc bson.Raw) {
+	for _, name := range s.Required {
+		if _, err := doc.LookupErr(name); err != nil {
+			v.fail(joinPath(path, name), "is required")
+		}
+	}
+
+	elements, _ := doc.Elements()
+	for _, element := range elements {
+		name := element.Key()
+		property, ok := s.Properties[name]
+		if !ok {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				v.fail(joinPath(path, name), "is not allowed")
 			}
-			if len(val.(string)) > maxlen {
+			continue
+		}
+		if property != nil {
+			v.value(property, joinPath(path, name), element.Value())
+		}
+	}
+}
+
+func (v *validator) array(s *Schema, path string, arr bson.Raw) {
+	values, _ := arr.Values()
+	if s.MinItems != nil && len(values) < *s.MinItems {
+		v.fail(path, "must have at least %d items, got %d", *s.MinItems, len(values))
+	}
+	if s.MaxItems != nil && len(values) > *s.MaxItems {
+		v.fail(path, "must have at most %d items, got %d", *s.MaxItems, len(values))
+	}
+	if s.Items == nil {
+		return
+	}
+	for i, item := range values {
+		v.value(s.Items, path+"["+strconv.Itoa(i)+"]", item)
+	}
+}
+
+func (v *validator) string(s *Schema, path string, str string) {
+	length := utf8.RuneCountInString(str)
+	if s.MinLength != nil && length < *s.MinLength {
+		v.fail(path, "must be at least %d characters long, got %d", *s.MinLength, length)
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		v.fail(path, "must be at most %d characters long, got %d", *s.MaxLength, length)
+	}
+}
+
+func (v *validator) number(s *Schema, path string, n float64) {
+	if s.Minimum != nil && n < *s.Minimum {
+		v.fail(path, "must be at least %g, got %g", *s.Minimum, n)
+	}
+	if s.Maximum != nil && n > *s.Maximum {
+		v.fail(path, "must be at most %g, got %g", *s.Maximum, n)
+	}
+	if s.ExclusiveMinimum != nil && n <= *s.ExclusiveMinimum {
+		v.fail(path, "must be greater than %g, got %g", *s.ExclusiveMinimum, n)
+	}
+	if s.ExclusiveMaximum != nil && n >= *s.ExclusiveMaximum {
+		v.fail(path, "must be less than %g, got %g", *s.ExclusiveMaximum, n)
+	}
+}
+
+func joinPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
 
+func hasType(val bson.RawValue, want string) bool {
+	switch want {
+	case TypeNumber:
+		_, ok := numberValue(val)
+		return ok
+	case TypeInteger:
+		n, ok := numberValue(val)
+		return ok && n == math.Trunc(n) && !math.IsInf(n, 0)
+	}
+	return typeName(val) == want
+}
+
+// typeName names a BSON value's type in Schema terms.
+func typeName(val bson.RawValue) string {
+	switch val.Type {
+	case bsontype.EmbeddedDocument:
+		return TypeObject
+	case bsontype.Array:
+		return TypeArray
+	case bsontype.String:
+		return TypeString
+	case bsontype.Int32, bsontype.Int64:
+		return TypeInteger
+	case bsontype.Double, bsontype.Decimal128:
+		return TypeNumber
+	case bsontype.Boolean:
+		return TypeBoolean
+	case bsontype.Null, bsontype.Undefined:
+		return TypeNull
+	case bsontype.ObjectID:
+		return TypeObjectID
+	case bsontype.DateTime:
+		return TypeDate
+	}
+	return val.Type.String()
+}
+
+func numberValue(val bson.RawValue) (float64, bool) {
+	switch val.Type {
+	case bsontype.Double:
+		return val.Double(), true
+	case bsontype.Int32:
+		return float64(val.Int32()), true
+	case bsontype.Int64:
+		return float64(val.Int64()), true
+	case bsontype.Decimal128:
+		n, err := strconv.ParseFloat(val.Decimal128().String(), 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// inEnum compares val against enum values as they would be stored, so that
+// 3 matches 3.0 and strings match exactly.
+func inEnum(val bson.RawValue, enum []interface{}) bool {
+	n, isNumber := numberValue(val)
+	for _, option := range enum {
+		optionType, raw, err := bson.MarshalValue(option)
+		if err != nil {
+			continue
+		}
+		candidate := bson.RawValue{Type: optionType, Value: raw}
+		if isNumber {
+			if m, ok := numberValue(candidate); ok && m == n {
+				return true
 			}
+			continue
+		}
+		if candidate.Equal(val) {
+			return true
 		}
 	}
-	return nil
+	return false
 }
@@ -0,0 +1,101 @@
+package dbservice
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Analyzers a collection's text index can be built with.
+const (
+	AnalyzerStandard = "standard" // words, lowercased, English stopwords removed
+	AnalyzerSimple   = "simple"   // words, lowercased
+)
+
+// maxTokenLength bounds the terms written to the text index. WiredTiger keys are
+// limited in size and longer "words" are almost always encoded data.
+const maxTokenLength = 128
+
+// Analyzer turns text into the terms stored in, and looked up from, a
+// collection's text index. The tokenizer splits the text and each filter then
+// rewrites the token stream in order.
+type Analyzer struct {
+	Tokenizer func(text string) []string
+	Filters   []TokenFilter
+}
+
+// TokenFilter transforms a token stream, e.g. by normalizing or dropping tokens.
+type TokenFilter func(tokens []string) []string
+
+// Analyze runs text through the tokenizer and every filter.
+func (a Analyzer) Analyze(text string) []string {
+	tokens := a.Tokenizer(text)
+	for _, filter := range a.Filters {
+		tokens = filter(tokens)
+	}
+	return tokens
+}
+
+var analyzers = map[string]Analyzer{
+	AnalyzerStandard: {Tokenizer: WordTokenizer, Filters: []TokenFilter{LowercaseFilter, StopwordFilter(englishStopwords)}},
+	AnalyzerSimple:   {Tokenizer: WordTokenizer, Filters: []TokenFilter{LowercaseFilter}},
+}
+
+// lookupAnalyzer returns the analyzer registered under name, defaulting to
+// AnalyzerStandard.
+func lookupAnalyzer(name string) (Analyzer, error) {
+	if name == "" {
+		name = AnalyzerStandard
+	}
+	analyzer, ok := analyzers[name]
+	if !ok {
+		return Analyzer{}, fmt.Errorf("unsupported text analyzer %q", name)
+	}
+	return analyzer, nil
+}
+
+// WordTokenizer splits text into runs of letters and digits, dropping runs
+// longer than maxTokenLength bytes.
+func WordTokenizer(text string) []string {
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	tokens := fields[:0]
+	for _, field := range fields {
+		if len(field) <= maxTokenLength {
+			tokens = append(tokens, field)
+		}
+	}
+	return tokens
+}
+
+// LowercaseFilter lowercases every token.
+func LowercaseFilter(tokens []string) []string {
+	for i, token := range tokens {
+		tokens[i] = strings.ToLower(token)
+	}
+	return tokens
+}
+
+// StopwordFilter returns a filter dropping the given (lowercase) words.
+func StopwordFilter(stopwords []string) TokenFilter {
+	set := make(map[string]struct{}, len(stopwords))
+	for _, word := range stopwords {
+		set[word] = struct{}{}
+	}
+	return func(tokens []string) []string {
+		kept := tokens[:0]
+		for _, token := range tokens {
+			if _, stop := set[token]; !stop {
+				kept = append(kept, token)
+			}
+		}
+		return kept
+	}
+}
+
+var englishStopwords = []string{
+	"a", "an", "and", "are", "as", "at", "be", "but", "by", "for", "if", "in",
+	"into", "is", "it", "no", "not", "of", "on", "or", "such", "that", "the",
+	"their", "then", "there", "these", "they", "this", "to", "was", "will", "with",
+}
@@ -0,0 +1,715 @@
+package dbservice
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	wt "glowstickdb/pkgs/wiredtiger"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// DefaultBulkBatchSize is how many documents BulkInsert commits, and adds to
+// the vector index, at a time unless BulkInsertOptions says otherwise.
+const DefaultBulkBatchSize = 10000
+
+// DocumentSource yields the documents of a bulk load one at a time, like a
+// range cursor: Next advances to the next document, returning false at the
+// end or on an error, which Err then reports.
+type DocumentSource interface {
+	Next() bool
+	Current() GlowstickDocument
+	Err() error
+}
+
+type sliceSource struct {
+	documents []GlowstickDocument
+	pos       int
+}
+
+// SliceSource returns a DocumentSource over documents.
+func SliceSource(documents []GlowstickDocument) DocumentSource {
+	return &sliceSource{documents: documents}
+}
+
+func (s *sliceSource) Next() bool {
+	if s.pos >= len(s.documents) {
+		return false
+	}
+	s.pos++
+	return true
+}
+
+func (s *sliceSource) Current() GlowstickDocument { return s.documents[s.pos-1] }
+func (s *sliceSource) Err() error                 { return nil }
+
+// BulkInsertOptions tunes BulkInsert. The zero value uses the defaults.
+type BulkInsertOptions struct {
+	BatchSize int // documents per transaction and per FAISS add; 0 means DefaultBulkBatchSize
+
+	// Progress, if set, is called after every batch is committed.
+	Progress func(BulkProgress)
+}
+
+// BulkProgress reports how far a BulkInsert has got.
+type BulkProgress struct {
+	Inserted int // documents committed so far
+	// Indexed is how many of their vectors are in the vector index. It lags
+	// Inserted while an IVF or PQ index waits for its training sample.
+	Indexed int
+	Elapsed time.Duration
+}
+
+// bulkLoad is the state of one BulkInsert call.
+type bulkLoad struct {
+	s                *GDBService
+	collection       CollectionCatalogEntry
+	collectionDefKey string
+	opts             BulkInsertOptions
+	start            time.Time
+	recordDimension  bool // the dimension is inferred and not in the catalog yet
+
+	lock   *collectionLock // its write mutex is held for the whole load
+	locked bool            // whether the lock itself is held
+
+	// handle is the vector index while the load keeps it between batches,
+	// which it only does for an index loaded outside any manager.
+	handle     *vectorIndexHandle
+	opened     bool // whether the labels were read from the index
+	trained    bool
+	metric     string
+	trainSize  int
+	sample     []float32 // vectors buffered until there are trainSize to train on
+	firstLabel int64
+	nextLabel  int64
+
+	// While documents come with their embeddings and their IDs ascend, only
+	// their rows are written, through a bulk cursor. The rest of them is
+	// committed from the rows once the cursor is closed and they are durable;
+	// see endBulk.
+	bulk     wt.BulkCursor  // nil once documents are written through transactions
+	bulkKeys map[string]int // Keys of the rows written so far, by position in the load
+	lastKey  []byte
+
+	inserted int
+	indexed  int
+}
+
+// BulkInsert loads documents into an empty collection, for large initial
+// imports. Documents are committed in batches of opts.BatchSize, each batch in
+// one transaction as InsertDocumentsIntoCollection would, except that:
+//
+//   - for as long as documents come with their embeddings and their IDs
+//     ascend, as IDs assigned by BulkInsert do, the document rows are first
+//     written through a WiredTiger bulk cursor, and the rest of the documents
+//     is committed batch by batch once those rows are durable; the first
+//     batch to embed or with an ID out of order switches the rest of the load
+//     to transactions;
+//   - vectors are added to the FAISS index a batch at a time, and an index
+//     that needs training is trained on the first TrainSize vectors loaded;
+//   - the index file is written at the end rather than after every batch.
+//
+// Other writers to the collection wait for the load to end. Readers wait for
+// the batch being written, and for as long as the bulk cursor is open, since
+// WiredTiger keeps them off the document table until it is closed.
+//
+// It returns the number of documents committed. A load that fails keeps the
+// documents committed before the failure; a rejected batch is reported as a
+// *BatchError whose indexes count from the start of documents, and whose
+// Offset and Total give the batch's place in them. Rows a load left behind
+// without committing their documents are removed by the next BulkInsert into
+// the collection.
+func (s *GDBService) BulkInsert(collection_name string, documents DocumentSource, opts BulkInsertOptions) (int, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBulkBatchSize
+	}
+
+	lock := s.collectionLock(s.Name + "." + collection_name)
+	lock.write.Lock()
+	defer lock.write.Unlock()
+
+	load := &bulkLoad{s: s, lock: lock, opts: opts, start: time.Now()}
+	defer load.close()
+	load.lockWrites()
+
+	collection, collectionDefKey, err := s.getCollection(collection_name)
+	if err != nil {
+		return 0, err
+	}
+
+	stats, err := s.GetCollectionStats(collection_name)
+	if err != nil {
+		return 0, err
+	}
+	if stats.Doc_Count > 0 || stats.Tombstone_Count > 0 {
+		return 0, fmt.Errorf("[DB_SERVICE:BulkInsert] %w: %s holds %d documents", ErrCollectionNotEmpty, collectionDefKey, stats.Doc_Count)
+	}
+	if err := s.clearRows(collection, collectionDefKey); err != nil {
+		return 0, err
+	}
+
+	load.collection, load.collectionDefKey = collection, collectionDefKey
+	load.recordDimension = collection.VectorIndex.Dimension == 0
+
+	err = load.run(documents)
+	load.lockWrites()
+	if finishErr := load.finish(); err == nil {
+		err = finishErr
+	} else if finishErr != nil {
+		s.logger().Warn("bulk load failed to finish", "collection", collectionDefKey, "error", finishErr)
+	}
+	return load.inserted, err
+}
+
+// run writes the documents a batch at a time.
+func (l *bulkLoad) run(documents DocumentSource) error {
+	batch := make([]GlowstickDocument, 0, l.opts.BatchSize)
+	offset := 0
+	for more := true; more; {
+		if more = documents.Next(); more {
+			batch = append(batch, documents.Current())
+		}
+		if len(batch) == l.opts.BatchSize || (!more && len(batch) > 0) {
+			if err := l.writeBatch(batch, offset); err != nil {
+				return err
+			}
+			offset += len(batch)
+			batch = batch[:0]
+		}
+	}
+	if err := documents.Err(); err != nil {
+		return fmt.Errorf("[DB_SERVICE:BulkInsert] failed to read documents: %w", err)
+	}
+	return nil
+}
+
+// writeBatch validates one batch, starting offset documents into the load,
+// and writes it: its rows only while the bulk cursor is open, the whole of it
+// otherwise.
+func (l *bulkLoad) writeBatch(documents []GlowstickDocument, offset int) error {
+	s := l.s
+
+	// The load holds the lock for as long as the bulk cursor is open. A batch
+	// to embed ends it: the load then waits on the embedder rather than on
+	// WiredTiger, and does so without holding the lock.
+	embed := needsEmbedding(documents)
+	if l.bulk != nil && embed {
+		s.logger().Info("bulk load continues through transactions: documents need embedding", "collection", l.collectionDefKey, "inserted", offset)
+		if err := l.endBulk(); err != nil {
+			return err
+		}
+	}
+	if l.bulk == nil {
+		l.unlockWrites()
+	}
+
+	newIDs(documents, writeInsert)
+	documents, err := embedDocuments(l.collection, documents)
+	if err != nil {
+		return err
+	}
+
+	l.lockWrites()
+	defer func() {
+		if l.bulk == nil {
+			l.unlockWrites()
+		}
+	}()
+
+	dimension, err := validateDocuments(l.collection, documents)
+	if err != nil {
+		return offsetBatchError(err, offset)
+	}
+	l.collection.VectorIndex.Dimension = dimension
+
+	if !l.opened {
+		if err := l.open(!embed); err != nil {
+			return err
+		}
+	}
+
+	// A bulk cursor only takes ascending keys and cannot be reopened once the
+	// table holds rows, so a batch out of order ends it for the rest of the load.
+	if l.bulk != nil && !ascending(l.lastKey, documents) {
+		s.logger().Info("bulk load continues through transactions: document IDs are not in ascending order", "collection", l.collectionDefKey, "inserted", offset)
+		if err := l.endBulk(); err != nil {
+			return err
+		}
+	}
+
+	if l.bulk != nil {
+		return l.bulkWrite(documents, offset)
+	}
+	return l.commit(documents, offset, true)
+}
+
+// open reads where the load's labels start from the collection's vector
+// index, once the first batch has fixed the collection's dimension, and opens
+// the bulk cursor on its document table if bulk is set.
+func (l *bulkLoad) open(bulk bool) error {
+	handle, err := l.acquireIndex()
+	if err != nil {
+		return err
+	}
+	defer l.releaseIndex(handle)
+
+	if l.trained, err = handle.idx.IsTrained(); err != nil {
+		return fmt.Errorf("failed to read vector index training state: %v", err)
+	}
+	nTotal, err := handle.idx.NTotal()
+	if err != nil {
+		return fmt.Errorf("failed to read vector index size: %v", err)
+	}
+	config := l.collection.VectorIndex.withDefaults()
+	l.metric, l.trainSize = config.Metric, config.TrainSize
+	l.firstLabel = nTotal + handle.pending
+	l.nextLabel = l.firstLabel
+	l.opened = true
+
+	if !bulk {
+		return nil
+	}
+	// Without a bulk cursor, e.g. because a reader has the table open, the
+	// load goes through transactions from the start.
+	if l.bulk, err = l.s.KvService.OpenBulkCursor(l.collection.TableUri); err != nil {
+		l.s.logger().Info("bulk load continues through transactions: no bulk cursor", "collection", l.collectionDefKey, "error", err)
+		l.bulk = nil
+		return nil
+	}
+	l.bulkKeys = make(map[string]int)
+	return nil
+}
+
+// acquireIndex returns the collection's vector index for one step of the load.
+// An index the manager caches is shared with readers, which take it between
+// batches, so it is acquired for every step; one loaded outside any manager is
+// the load's own, and kept until the load ends.
+func (l *bulkLoad) acquireIndex() (*vectorIndexHandle, error) {
+	if l.handle != nil {
+		return l.handle, nil
+	}
+	handle, err := l.s.acquireVectorIndex(l.collection, l.collectionDefKey, true)
+	if err != nil {
+		return nil, err
+	}
+	if handle.e == nil {
+		l.handle = handle
+	}
+	return handle, nil
+}
+
+// releaseIndex gives back an index acquired for one step of the load.
+func (l *bulkLoad) releaseIndex(handle *vectorIndexHandle) {
+	if handle != l.handle {
+		handle.release()
+	}
+}
+
+func (l *bulkLoad) lockWrites() {
+	if !l.locked {
+		l.lock.Lock()
+		l.locked = true
+	}
+}
+
+func (l *bulkLoad) unlockWrites() {
+	if l.locked {
+		l.lock.Unlock()
+		l.locked = false
+	}
+}
+
+// bulkWrite writes the rows of a batch through the bulk cursor, once its Keys
+// are known not to repeat one loaded before. The table was empty and the IDs
+// ascend, so they cannot collide. Should the cursor fail, the rows it took are
+// committed and the rest of the batch goes through a transaction.
+func (l *bulkLoad) bulkWrite(documents []GlowstickDocument, offset int) error {
+	var duplicates []DocumentError
+	keys := make(map[string]int)
+	for i, doc := range documents {
+		if doc.Key == "" {
+			continue
+		}
+		first, seen := l.bulkKeys[doc.Key]
+		if !seen {
+			first, seen = keys[doc.Key]
+		}
+		if seen {
+			duplicates = append(duplicates, DocumentError{Index: offset + i, Id: doc.ID, Err: fmt.Errorf("%w: key %q is also used by documents[%d]", ErrDuplicateKey, doc.Key, first)})
+			continue
+		}
+		keys[doc.Key] = offset + i
+	}
+	if len(duplicates) > 0 {
+		return &BatchError{Documents: duplicates, Total: len(documents), Offset: offset}
+	}
+
+	for i, doc := range documents {
+		doc_bytes, err := bson.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("failed to marshal document to BSON: %v", err)
+		}
+		if err := l.bulk.Insert(doc.ID[:], doc_bytes); err != nil {
+			l.s.logger().Warn("bulk load continues through transactions: bulk insert failed", "collection", l.collectionDefKey, "_id", doc.ID.Hex(), "error", err)
+			if err := l.endBulk(); err != nil {
+				return err
+			}
+			return l.commit(documents[i:], offset+i, true)
+		}
+		l.lastKey = append(l.lastKey[:0], doc.ID[:]...)
+	}
+	for key, position := range keys {
+		l.bulkKeys[key] = position
+	}
+	return nil
+}
+
+// endBulk closes the bulk cursor and checkpoints, which makes its rows visible
+// and durable, and only then commits the rest of their documents: Keys, index
+// entries and labels never refer to a row that is not there, even after a
+// crash. The rows are committed as the table holds them, so a cursor that
+// failed part way loses no more than the rows it did not write.
+func (l *bulkLoad) endBulk() error {
+	if l.bulk == nil {
+		return nil
+	}
+	closeErr := l.closeBulk()
+	l.bulkKeys = nil
+	if err := l.s.KvService.Checkpoint(); err != nil {
+		return fmt.Errorf("[DB_SERVICE:BulkInsert] failed to checkpoint bulk-loaded rows: %v", err)
+	}
+	if err := l.commitRows(); err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// commitRows commits, a batch at a time, the documents of the rows written
+// through the bulk cursor: the only rows of the table, in the order they were
+// loaded.
+func (l *bulkLoad) commitRows() error {
+	cursor, err := l.s.KvService.ScanRangeBinary(l.collection.TableUri, nil, nil)
+	if err != nil {
+		return fmt.Errorf("[DB_SERVICE:BulkInsert] failed to scan bulk-loaded rows: %w", err)
+	}
+	defer cursor.Close()
+
+	batch := make([]GlowstickDocument, 0, l.opts.BatchSize)
+	for more := true; more; {
+		if more = cursor.Next(); more {
+			key, docBin, err := cursor.Current()
+			if err != nil {
+				return err
+			}
+			var doc GlowstickDocument
+			if err := bson.Unmarshal(docBin, &doc); err != nil {
+				return fmt.Errorf("failed to unmarshal BSON for docID %x: %v", key, err)
+			}
+			batch = append(batch, doc)
+		}
+		if len(batch) == l.opts.BatchSize || (!more && len(batch) > 0) {
+			if err := l.commit(batch, l.inserted, false); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("[DB_SERVICE:BulkInsert] bulk-loaded row scan failed: %w", err)
+	}
+	return nil
+}
+
+// commit writes a batch, starting offset documents into the load, in one
+// transaction: the rows too unless the bulk cursor wrote them, which also
+// spares checking their IDs and Keys again. Its vectors are then added to the
+// index.
+func (l *bulkLoad) commit(documents []GlowstickDocument, offset int, writeRows bool) error {
+	s, collection := l.s, l.collection
+	labels := l.nextLabel
+	vectors := make([]float32, 0, len(documents)*collection.VectorIndex.Dimension)
+
+	// Vectors the crash of an earlier load left out of the index file are
+	// re-added before these are.
+	handle, err := l.acquireIndex()
+	if err != nil {
+		return err
+	}
+	defer l.releaseIndex(handle)
+
+	err = s.withTransaction(func(sess wt.Session) error {
+		if writeRows {
+			if err := checkDuplicateKeys(sess, collection, documents, writeInsert); err != nil {
+				return offsetBatchError(err, offset)
+			}
+		}
+
+		hot_stats, _, err := sess.GetBinary(STATS, []byte(l.collectionDefKey))
+		if err != nil {
+			return fmt.Errorf("failed to fetch hot stats:%s", err)
+		}
+		var hot_stats_doc CollectionStats
+		if err := bson.Unmarshal(hot_stats, &hot_stats_doc); err != nil {
+			return fmt.Errorf("failed to unmarshal hot stats bson into struct:%s", err)
+		}
+
+		for i, doc := range documents {
+			docIDHex := doc.ID.Hex()
+			label := labels + int64(i)
+
+			if writeRows {
+				doc_bytes, err := bson.Marshal(doc)
+				if err != nil {
+					return fmt.Errorf("failed to marshal document to BSON: %v", err)
+				}
+				if err := sess.PutBinary(collection.TableUri, doc.ID[:], doc_bytes); err != nil {
+					return fmt.Errorf("failed to insert document with _id %s: %v", docIDHex, err)
+				}
+			}
+			if err := indexDocument(sess, collection, doc); err != nil {
+				return err
+			}
+
+			if err := sess.PutString(collection.LabelToDocUri, fmt.Sprintf("%d", label), docIDHex); err != nil {
+				return fmt.Errorf("failed to write label->docID mapping to table: %v", err)
+			}
+			if err := sess.PutString(collection.DocToLabelUri, docIDHex, fmt.Sprintf("%d", label)); err != nil {
+				return fmt.Errorf("failed to write docID->label mapping to table: %v", err)
+			}
+
+			vector := indexVector(l.metric, doc.Embedding)
+			if err := logVectorAdd(sess, l.collectionDefKey, label, doc.ID, vector); err != nil {
+				return err
+			}
+			vectors = append(vectors, vector...)
+		}
+
+		hot_stats_doc.Doc_Count += len(documents)
+		stats_bytes, err := bson.Marshal(hot_stats_doc)
+		if err != nil {
+			return fmt.Errorf("failed to marshal hot stats during write")
+		}
+		if err := sess.PutBinary(STATS, []byte(l.collectionDefKey), stats_bytes); err != nil {
+			return fmt.Errorf("failed to write hot stats: %s", err)
+		}
+
+		if l.recordDimension {
+			entry, err := bson.Marshal(collection)
+			if err != nil {
+				return fmt.Errorf("failed to encode catalog entry: %v", err)
+			}
+			if err := sess.PutBinaryWithStringKey(CATALOG, l.collectionDefKey, entry); err != nil {
+				return fmt.Errorf("failed to record collection dimension: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	l.recordDimension = false
+	l.nextLabel += int64(len(documents))
+	l.inserted += len(documents)
+
+	indexed := l.indexed
+	if err := l.addVectors(handle, vectors, len(documents)); err != nil {
+		// The vectors are in the WAL; the next load of the index replays them.
+		handle.discard()
+		return err
+	}
+	// Should the manager evict the index before the load writes it out, it
+	// writes the vectors that reached it first.
+	if l.indexed > indexed {
+		reached := make([]int64, 0, l.indexed-indexed)
+		for label := l.firstLabel + int64(indexed); label < l.firstLabel+int64(l.indexed); label++ {
+			reached = append(reached, label)
+		}
+		handle.added(s, reached, int64(len(reached)*collection.VectorIndex.Dimension*4), false)
+	}
+
+	if l.opts.Progress != nil {
+		l.opts.Progress(BulkProgress{Inserted: l.inserted, Indexed: l.indexed, Elapsed: time.Since(l.start)})
+	}
+	return nil
+}
+
+// addVectors adds a committed batch of n vectors to the index. An index that
+// needs training buffers them until it has trainSize to train on.
+func (l *bulkLoad) addVectors(handle *vectorIndexHandle, vectors []float32, n int) error {
+	idx := handle.idx
+	if !l.trained {
+		l.sample = append(l.sample, vectors...)
+		count := len(l.sample) / l.collection.VectorIndex.Dimension
+		if count < l.trainSize {
+			handle.setPending(int64(count))
+			return nil
+		}
+		if err := idx.Train(l.sample, count); err != nil {
+			return fmt.Errorf("failed to train vector index on %d vectors: %v", count, err)
+		}
+		l.trained = true
+		vectors, n = l.sample, count
+		l.sample = nil
+		handle.setPending(0)
+	}
+
+	if err := idx.Add(vectors, n); err != nil {
+		return fmt.Errorf("failed to add %d embeddings to index: %v", n, err)
+	}
+	l.indexed += n
+	return nil
+}
+
+// finish commits the rows still in the bulk cursor and writes out the vectors
+// of the load: the index file, then clearing their WAL entries. Vectors still
+// waiting for training stay in the WAL, as they would after
+// InsertDocumentsIntoCollection. It also runs after a failed load, for the
+// documents committed before the failure.
+func (l *bulkLoad) finish() error {
+	if err := l.endBulk(); err != nil {
+		return err
+	}
+	if l.indexed == 0 {
+		return nil
+	}
+	s := l.s
+
+	handle, err := l.acquireIndex()
+	if err != nil {
+		return err
+	}
+	defer l.releaseIndex(handle)
+	if err := handle.idx.WriteToFile(handle.filePath); err != nil {
+		handle.discard()
+		return fmt.Errorf("[DB_SERVICE:BulkInsert] writeToFile failed: %v", err)
+	}
+
+	// Cleared a batch at a time, to keep each transaction small.
+	for first := l.firstLabel; first < l.nextLabel; first += int64(l.opts.BatchSize) {
+		labels := make([]int64, 0, l.opts.BatchSize)
+		for label := first; label < l.nextLabel && len(labels) < l.opts.BatchSize; label++ {
+			labels = append(labels, label)
+		}
+		if err := s.checkpointVectorWal(l.collectionDefKey, labels); err != nil {
+			s.logger().Warn("failed to checkpoint vector WAL", "collection", l.collectionDefKey, "error", err)
+			break
+		}
+	}
+
+	info, err := os.Stat(handle.filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read file info from vector index file")
+	}
+	return s.withTransaction(func(sess wt.Session) error {
+		hot_stats, _, err := sess.GetBinary(STATS, []byte(l.collectionDefKey))
+		if err != nil {
+			return fmt.Errorf("failed to fetch hot stats:%s", err)
+		}
+		var hot_stats_doc CollectionStats
+		if err := bson.Unmarshal(hot_stats, &hot_stats_doc); err != nil {
+			return fmt.Errorf("failed to unmarshal hot stats bson into struct:%s", err)
+		}
+		hot_stats_doc.Vector_Index_Size += float64(info.Size())
+		stats_bytes, err := bson.Marshal(hot_stats_doc)
+		if err != nil {
+			return fmt.Errorf("failed to marshal hot stats during write")
+		}
+		return sess.PutBinary(STATS, []byte(l.collectionDefKey), stats_bytes)
+	})
+}
+
+func (l *bulkLoad) closeBulk() error {
+	if l.bulk == nil {
+		return nil
+	}
+	err := l.bulk.Close()
+	l.bulk = nil
+	if err != nil {
+		return fmt.Errorf("[DB_SERVICE:BulkInsert] failed to close bulk cursor: %v", err)
+	}
+	return nil
+}
+
+// close releases what a failed load still holds.
+func (l *bulkLoad) close() {
+	if err := l.closeBulk(); err != nil {
+		l.s.logger().Warn("bulk load failed to close its bulk cursor", "collection", l.collectionDefKey, "error", err)
+	}
+	if l.handle != nil {
+		l.handle.release()
+	}
+	l.unlockWrites()
+}
+
+// clearRows removes what the document table of a collection without documents
+// still holds: rows a bulk load wrote, then failed before committing.
+func (s *GDBService) clearRows(collection CollectionCatalogEntry, collectionDefKey string) error {
+	cursor, err := s.KvService.ScanRangeBinary(collection.TableUri, nil, nil)
+	if err != nil {
+		return fmt.Errorf("[DB_SERVICE:BulkInsert] failed to scan collection %s: %w", collectionDefKey, err)
+	}
+	var ids [][]byte
+	for cursor.Next() {
+		key, _, err := cursor.Current()
+		if err != nil {
+			cursor.Close()
+			return err
+		}
+		ids = append(ids, bytes.Clone(key))
+	}
+	err = cursor.Err()
+	cursor.Close()
+	if err != nil {
+		return fmt.Errorf("[DB_SERVICE:BulkInsert] collection scan failed: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	s.logger().Warn("removing rows left by a failed bulk load", "collection", collectionDefKey, "rows", len(ids))
+	for len(ids) > 0 {
+		n := min(len(ids), DefaultBulkBatchSize)
+		err := s.withTransaction(func(sess wt.Session) error {
+			for _, id := range ids[:n] {
+				if err := sess.DeleteBinary(collection.TableUri, id); err != nil {
+					return fmt.Errorf("failed to delete row %x: %v", id, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
+
+// ascending reports whether the IDs of documents increase strictly, starting
+// after last.
+func ascending(last []byte, documents []GlowstickDocument) bool {
+	for i := range documents {
+		if last != nil && bytes.Compare(documents[i].ID[:], last) <= 0 {
+			return false
+		}
+		last = documents[i].ID[:]
+	}
+	return true
+}
+
+// offsetBatchError renumbers the documents of a *BatchError from a batch that
+// starts offset documents into a load.
+func offsetBatchError(err error, offset int) error {
+	var batchErr *BatchError
+	if offset == 0 || !errors.As(err, &batchErr) {
+		return err
+	}
+	for i := range batchErr.Documents {
+		batchErr.Documents[i].Index += offset
+	}
+	batchErr.Offset = offset
+	return err
+}
@@ -0,0 +1,234 @@
+package dbservice
+
+import (
+	"fmt"
+	wt "glowstickdb/pkgs/wiredtiger"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Databases are catalogued under "db:<name>" and collections under
+// "<db>.<collection>", so both can be range-scanned by prefix.
+const dbCatalogPrefix = "db:"
+
+// GetDatabase reads a database's catalog entry. found is false if no database
+// of that name was created.
+func GetDatabase(kv wt.WTService, name string) (DbCatalogEntry, bool, error) {
+	var entry DbCatalogEntry
+
+	val, found, err := kv.GetBinaryWithStringKey(CATALOG, dbCatalogPrefix+name)
+	if err != nil || !found {
+		return entry, false, err
+	}
+
+	if err := bson.Unmarshal(val, &entry); err != nil {
+		return entry, false, fmt.Errorf("failed to decode catalog entry for db %s: %v", name, err)
+	}
+	return entry, true, nil
+}
+
+// ListDatabases returns the catalog entry of every database, ordered by name.
+func ListDatabases(kv wt.WTService) ([]DbCatalogEntry, error) {
+	start := []byte(dbCatalogPrefix)
+	end := prefixEnd(start)
+
+	cursor, err := kv.ScanRangeBinary(CATALOG, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan catalog: %w", err)
+	}
+	defer cursor.Close()
+
+	dbs := []DbCatalogEntry{}
+	for cursor.Next() {
+		_, val, err := cursor.Current()
+		if err != nil {
+			return nil, err
+		}
+
+		var entry DbCatalogEntry
+		if err := bson.Unmarshal(val, &entry); err != nil {
+			return nil, fmt.Errorf("failed to decode db catalog entry: %v", err)
+		}
+		dbs = append(dbs, entry)
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("catalog scan failed: %w", err)
+	}
+
+	return dbs, nil
+}
+
+// GetCollectionStats returns the hot stats row of a collection.
+func (s *GDBService) GetCollectionStats(collection_name string) (CollectionStats, error) {
+	var stats CollectionStats
+
+	collectionDefKey := fmt.Sprintf("%s.%s", s.Name, collection_name)
+	val, found, err := s.KvService.GetBinary(STATS, []byte(collectionDefKey))
+	if err != nil {
+		return stats, fmt.Errorf("failed to fetch hot stats:%s", err)
+	}
+	if !found {
+		return stats, fmt.Errorf("%w: %s", ErrCollectionNotFound, collectionDefKey)
+	}
+
+	if err := bson.Unmarshal(val, &stats); err != nil {
+		return stats, fmt.Errorf("failed to unmarshal hot stats bson into struct:%s", err)
+	}
+	return stats, nil
+}
+
+// ListCollections returns the catalog entries of every collection in the
+// database, ordered by name.
+func (s *GDBService) ListCollections() ([]CollectionCatalogEntry, error) {
+	start := []byte(s.Name + ".")
+	end := prefixEnd(start)
+
+	cursor, err := s.KvService.ScanRangeBinary(CATALOG, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("[GDBSERVICE:ListCollections] failed to scan catalog: %w", err)
+	}
+	defer cursor.Close()
+
+	collections := []CollectionCatalogEntry{}
+	for cursor.Next() {
+		key, val, err := cursor.Current()
+		if err != nil {
+			return nil, err
+		}
+
+		var entry CollectionCatalogEntry
+		if err := bson.Unmarshal(val, &entry); err != nil {
+			return nil, fmt.Errorf("[GDBSERVICE:ListCollections] failed to decode catalog entry %s: %v", key, err)
+		}
+		collections = append(collections, entry)
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("[GDBSERVICE:ListCollections] catalog scan failed: %w", err)
+	}
+
+	return collections, nil
+}
+
+// DropCollection deletes a collection: its catalog and stats rows, pending
+// vector WAL entries, document, label mapping and secondary index tables, and
+// its vector index file.
+//
+// The catalog rows go first, in one transaction, so the collection disappears
+// atomically. Tables and the index file are removed afterwards; if that fails
+// they are left orphaned but unreachable, and the error is returned.
+func (s *GDBService) DropCollection(collection_name string) error {
+	kv := s.KvService
+
+	defer s.lockCollection(collection_name)()
+
+	collectionDefKey := fmt.Sprintf("%s.%s", s.Name, collection_name)
+	val, exists, err := kv.GetBinary(CATALOG, []byte(collectionDefKey))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("[GDBSERVICE:DropCollection] %w: %s", ErrCollectionNotFound, collectionDefKey)
+	}
+
+	var collection CollectionCatalogEntry
+	if err := bson.Unmarshal(val, &collection); err != nil {
+		return fmt.Errorf("[GDBSERVICE:DropCollection] failed to decode catalog entry: %v", err)
+	}
+
+	walStart, walEnd := walRange(collectionDefKey)
+	cursor, err := kv.ScanRangeBinary(VECTOR_WAL, walStart, walEnd)
+	if err != nil {
+		return fmt.Errorf("[GDBSERVICE:DropCollection] failed to scan vector WAL: %w", err)
+	}
+	var walKeys [][]byte
+	for cursor.Next() {
+		key, _, err := cursor.Current()
+		if err != nil {
+			cursor.Close()
+			return err
+		}
+		walKeys = append(walKeys, append([]byte{}, key...))
+	}
+	scanErr := cursor.Err()
+	cursor.Close()
+	if scanErr != nil {
+		return fmt.Errorf("[GDBSERVICE:DropCollection] vector WAL scan failed: %w", scanErr)
+	}
+
+	err = s.withTransaction(func(sess wt.Session) error {
+		if err := sess.DeleteBinary(CATALOG, []byte(collectionDefKey)); err != nil {
+			return fmt.Errorf("failed to delete catalog entry: %w", err)
+		}
+		if err := sess.DeleteBinary(STATS, []byte(collectionDefKey)); err != nil {
+			return fmt.Errorf("failed to delete stats entry: %w", err)
+		}
+		for _, key := range walKeys {
+			if err := sess.DeleteBinary(VECTOR_WAL, key); err != nil {
+				return fmt.Errorf("failed to delete vector WAL entry: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("[GDBSERVICE:DropCollection] %w", err)
+	}
+
+	s.dropVectorIndex(collection, collectionDefKey)
+
+	tables := []string{collection.TableUri, collection.LabelToDocUri, collection.DocToLabelUri, collection.TextIndexUri, collection.KeyIndexUri}
+	for _, tableUri := range collection.IndexTableUriMap {
+		tables = append(tables, tableUri)
+	}
+
+	for _, tableUri := range tables {
+		if tableUri == "" {
+			continue
+		}
+		if err := kv.DropTable(tableUri, "force=true"); err != nil {
+			return fmt.Errorf("[GDBSERVICE:DropCollection] failed to drop table %s: %v", tableUri, err)
+		}
+	}
+
+	u, err := url.Parse(collection.VectorIndexUri)
+	if err != nil {
+		return fmt.Errorf("[GDBSERVICE:DropCollection] failed to parse vector index URI: %v", err)
+	}
+	if err := os.Remove(u.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("[GDBSERVICE:DropCollection] failed to remove vector index file: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteDB drops every collection in the database and then its catalog entry.
+func (s *GDBService) DeleteDB() error {
+	exists, err := s.KvService.ExistsBinary(CATALOG, []byte(dbCatalogPrefix+s.Name))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("[GDBSERVICE:DeleteDB] %w: %s", ErrDatabaseNotFound, s.Name)
+	}
+
+	collections, err := s.ListCollections()
+	if err != nil {
+		return err
+	}
+
+	for _, collection := range collections {
+		if err := s.DropCollection(strings.TrimPrefix(collection.Ns, s.Name+".")); err != nil {
+			return err
+		}
+	}
+
+	if err := s.KvService.DeleteBinaryWithStringKey(CATALOG, dbCatalogPrefix+s.Name); err != nil {
+		return fmt.Errorf("[GDBSERVICE:DeleteDB] failed to delete db catalog entry: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,285 @@
+package dbservice
+
+import (
+	"fmt"
+	bsonvalidator "glowstickdb/pkgs/bson-validator"
+	wt "glowstickdb/pkgs/wiredtiger"
+	"math"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// writeMode selects how writeDocuments treats documents that already exist.
+type writeMode int
+
+const (
+	writeInsert writeMode = iota // the document must not exist yet
+	writeUpdate                  // the document must already exist
+	writeUpsert                  // update if present, insert otherwise
+)
+
+// getCollection loads a collection's catalog entry, migrating it to
+// per-collection label tables if needed.
+func (s *GDBService) getCollection(collection_name string) (CollectionCatalogEntry, string, error) {
+	collectionDefKey := fmt.Sprintf("%s.%s", s.Name, collection_name)
+	collection, err := s.readCollection(collectionDefKey)
+	if err != nil {
+		return collection, collectionDefKey, err
+	}
+	if collection.LabelToDocUri != "" && collection.DocToLabelUri != "" && collection.TextIndexUri != "" && collection.KeyIndexUri != "" {
+		return collection, collectionDefKey, nil
+	}
+
+	// Queries may get here holding only the shared lock, so migrations take
+	// turns and re-read the entry in case an earlier one already upgraded it.
+	lock := s.collectionLock(collectionDefKey)
+	lock.migrate.Lock()
+	defer lock.migrate.Unlock()
+
+	if collection, err = s.readCollection(collectionDefKey); err != nil {
+		return collection, collectionDefKey, err
+	}
+
+	if err := s.migrateLabelMappings(&collection, collectionDefKey); err != nil {
+		return collection, collectionDefKey, err
+	}
+
+	if err := s.migrateTextIndex(&collection, collectionDefKey); err != nil {
+		return collection, collectionDefKey, err
+	}
+
+	if err := s.migrateKeyIndex(&collection, collectionDefKey); err != nil {
+		return collection, collectionDefKey, err
+	}
+
+	return collection, collectionDefKey, nil
+}
+
+// readCollection reads and decodes a collection's catalog entry.
+func (s *GDBService) readCollection(collectionDefKey string) (CollectionCatalogEntry, error) {
+	var collection CollectionCatalogEntry
+
+	val, exists, err := s.KvService.GetBinary(CATALOG, []byte(collectionDefKey))
+	if err != nil {
+		return collection, err
+	}
+	if !exists {
+		return collection, fmt.Errorf("%w: %s", ErrCollectionNotFound, collectionDefKey)
+	}
+
+	if err := bson.Unmarshal(val, &collection); err != nil {
+		return collection, fmt.Errorf("failed to decode catalog entry for %s: %v", collectionDefKey, err)
+	}
+	return collection, nil
+}
+
+// readDocument fetches a document inside a transaction. It returns nil when the
+// document does not exist.
+func readDocument(sess wt.Session, collection CollectionCatalogEntry, id primitive.ObjectID) (*GlowstickDocument, error) {
+	docBin, found, err := sess.GetBinary(collection.TableUri, id[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read document with _id %s: %w", id.Hex(), err)
+	}
+	if !found {
+		return nil, nil
+	}
+
+	var doc GlowstickDocument
+	if err := bson.Unmarshal(docBin, &doc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal BSON for docID %s: %w", id.Hex(), err)
+	}
+	doc.ID = id
+	return &doc, nil
+}
+
+// tombstoneDocument detaches a document from its FAISS label by removing both
+// mapping rows. The vector stays in the index but QueryCollection skips labels
+// without a mapping. It reports whether the document had a label.
+func tombstoneDocument(sess wt.Session, collection CollectionCatalogEntry, docIDHex string) (bool, error) {
+	label, found, err := sess.GetString(collection.DocToLabelUri, docIDHex)
+	if err != nil {
+		return false, fmt.Errorf("failed to read docID->label mapping: %w", err)
+	}
+	if !found {
+		return false, nil
+	}
+
+	if err := sess.DeleteString(collection.LabelToDocUri, label); err != nil {
+		return false, fmt.Errorf("failed to delete label->docID mapping: %w", err)
+	}
+	if err := sess.DeleteString(collection.DocToLabelUri, docIDHex); err != nil {
+		return false, fmt.Errorf("failed to delete docID->label mapping: %w", err)
+	}
+	return true, nil
+}
+
+// validateDocuments checks every document of a batch against the collection's
+// dimension and schema before any of it is written, and returns the
+// collection's dimension, which a collection created without one takes from
+// the batch's first document. All problems are reported together in a
+// *BatchError.
+func validateDocuments(collection CollectionCatalogEntry, documents []GlowstickDocument) (int, error) {
+	dimension := collection.VectorIndex.Dimension
+	if dimension == 0 && len(documents) > 0 {
+		dimension = len(documents[0].Embedding)
+	}
+
+	var invalid []DocumentError
+	for i, doc := range documents {
+		var err error
+		switch {
+		case len(doc.Key) > MaxDocumentKeyLength:
+			err = fmt.Errorf("%w: key is %d bytes long, at most %d are allowed", ErrInvalidDocument, len(doc.Key), MaxDocumentKeyLength)
+		case len(doc.Embedding) == 0:
+			err = fmt.Errorf("%w: embedding must not be empty", ErrInvalidDocument)
+		case len(doc.Embedding) != dimension:
+			err = fmt.Errorf("%w: embedding has %d dimensions, collection has %d", ErrInvalidDocument, len(doc.Embedding), dimension)
+		default:
+			for j, v := range doc.Embedding {
+				if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+					err = fmt.Errorf("%w: embedding[%d] is %v", ErrInvalidDocument, j, v)
+					break
+				}
+			}
+		}
+		if err == nil {
+			if schemaErr := bsonvalidator.ValidateDocument(collection.Schema, doc); schemaErr != nil {
+				err = fmt.Errorf("%w: %w", ErrInvalidDocument, schemaErr)
+			}
+		}
+		if err != nil {
+			invalid = append(invalid, DocumentError{Index: i, Id: doc.ID, Err: err})
+		}
+	}
+
+	if len(invalid) > 0 {
+		return 0, &BatchError{Documents: invalid, Total: len(documents)}
+	}
+	return dimension, nil
+}
+
+func embeddingsEqual(a, b []float32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// GetDocument fetches a single document by _id.
+func (s *GDBService) GetDocument(collection_name string, id primitive.ObjectID) (GlowstickDocument, bool, error) {
+	var doc GlowstickDocument
+
+	defer s.rlockCollection(collection_name)()
+
+	collection, _, err := s.getCollection(collection_name)
+	if err != nil {
+		return doc, false, err
+	}
+
+	docBin, found, err := s.KvService.GetBinary(collection.TableUri, id[:])
+	if err != nil {
+		return doc, false, fmt.Errorf("failed to read document with _id %s: %w", id.Hex(), err)
+	}
+	if !found {
+		return doc, false, nil
+	}
+
+	if err := bson.Unmarshal(docBin, &doc); err != nil {
+		return doc, false, fmt.Errorf("failed to unmarshal BSON for docID %s: %w", id.Hex(), err)
+	}
+	doc.ID = id
+
+	return doc, true, nil
+}
+
+// UpdateDocument replaces the document stored under id. It returns
+// ErrDocumentNotFound if there is no such document.
+func (s *GDBService) UpdateDocument(collection_name string, id primitive.ObjectID, document GlowstickDocument) error {
+	document.ID = id
+	return s.writeDocuments(collection_name, []GlowstickDocument{document}, writeUpdate)
+}
+
+// UpsertDocuments replaces the documents that already exist and inserts the
+// rest. A document without an ID replaces the one holding its Key, if any, and
+// is otherwise assigned a new ID in place.
+func (s *GDBService) UpsertDocuments(collection_name string, documents []GlowstickDocument) error {
+	return s.writeDocuments(collection_name, documents, writeUpsert)
+}
+
+// DeleteDocuments removes documents by _id and returns how many existed. Their
+// vectors are tombstoned rather than removed from the FAISS index, since
+// removing ids from a flat index renumbers every later label.
+func (s *GDBService) DeleteDocuments(collection_name string, ids []primitive.ObjectID) (int, error) {
+	defer s.lockCollection(collection_name)()
+
+	collection, collectionDefKey, err := s.getCollection(collection_name)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	err = s.withTransaction(func(sess wt.Session) error {
+		deleted = 0
+
+		hot_stats, _, err := sess.GetBinary(STATS, []byte(collectionDefKey))
+		if err != nil {
+			return fmt.Errorf("failed to fetch hot stats:%s", err)
+		}
+
+		var hot_stats_doc CollectionStats
+		if err := bson.Unmarshal(hot_stats, &hot_stats_doc); err != nil {
+			return fmt.Errorf("failed to unmarshal hot stats bson into struct:%s", err)
+		}
+
+		for _, id := range ids {
+			doc, err := readDocument(sess, collection, id)
+			if err != nil {
+				return err
+			}
+			if doc == nil {
+				continue
+			}
+
+			if err := unindexDocument(sess, collection, *doc); err != nil {
+				return err
+			}
+
+			hadLabel, err := tombstoneDocument(sess, collection, id.Hex())
+			if err != nil {
+				return err
+			}
+			if hadLabel {
+				hot_stats_doc.Tombstone_Count += 1
+			}
+
+			if err := sess.DeleteBinary(collection.TableUri, id[:]); err != nil {
+				return fmt.Errorf("failed to delete document with _id %s: %w", id.Hex(), err)
+			}
+
+			hot_stats_doc.Doc_Count -= 1
+			deleted++
+		}
+
+		bytes, err := bson.Marshal(hot_stats_doc)
+		if err != nil {
+			return fmt.Errorf("failed to marshal hot stats during write")
+		}
+
+		if err := sess.PutBinary(STATS, []byte(collectionDefKey), bytes); err != nil {
+			return fmt.Errorf("failed to write hot stats: %s", err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
@@ -0,0 +1,281 @@
+package dbservice
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	bsonvalidator "glowstickdb/pkgs/bson-validator"
+	"glowstickdb/pkgs/embedder"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Formats DumpCollection writes and RestoreCollection reads. Either way a dump
+// is a DumpHeader, the collection's documents as stored, in _id order,
+// embeddings included, and a DumpTrailer.
+const (
+	DumpFormatJSONL = "jsonl" // one document per line, in canonical Extended JSON
+	DumpFormatBSON  = "bson"  // concatenated BSON documents
+)
+
+// DumpVersion is the version of the dump layout written by DumpCollection.
+const DumpVersion = 1
+
+// maxDumpLineSize bounds one line, i.e. one document, of a JSONL dump.
+const maxDumpLineSize = 64 << 20
+
+// DumpHeader is the first record of a dump: what RestoreCollection needs to
+// recreate the collection before loading its documents.
+type DumpHeader struct {
+	Version      int                   `bson:"glowstick_dump"`
+	Collection   string                `bson:"collection"`
+	VectorIndex  VectorIndexConfig     `bson:"vector_index"`
+	TextAnalyzer string                `bson:"text_analyzer,omitempty"`
+	Embedder     *embedder.Config      `bson:"embedder,omitempty"`
+	Schema       *bsonvalidator.Schema `bson:"schema,omitempty"`
+	Indexes      []CollectionIndex     `bson:"indexes,omitempty"`
+}
+
+// DumpTrailer is the last record of a dump: the number of documents written
+// before it, which tells a complete dump from one cut short.
+type DumpTrailer struct {
+	Count int `bson:"glowstick_dump_count"`
+}
+
+// dumpTrailerKey is the field telling a DumpTrailer from a document.
+const dumpTrailerKey = "glowstick_dump_count"
+
+// DumpCollection writes a collection to w in format and returns the number of
+// documents written. Writers to the collection wait until it is done, so the
+// dump is a consistent copy.
+func (s *GDBService) DumpCollection(collection_name string, w io.Writer, format string) (int, error) {
+	out := bufio.NewWriter(w)
+	writeRecord, err := newDumpWriter(out, format)
+	if err != nil {
+		return 0, fmt.Errorf("[DB_SERVICE:DumpCollection] %w", err)
+	}
+
+	defer s.rlockCollection(collection_name)()
+
+	collection, _, err := s.getCollection(collection_name)
+	if err != nil {
+		return 0, err
+	}
+	header, err := bson.Marshal(DumpHeader{
+		Version:      DumpVersion,
+		Collection:   collection_name,
+		VectorIndex:  collection.VectorIndex,
+		TextAnalyzer: collection.TextAnalyzer,
+		Embedder:     collection.Embedder,
+		Schema:       collection.Schema,
+		Indexes:      collection.Indexes,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("[DB_SERVICE:DumpCollection] failed to encode dump header: %v", err)
+	}
+	if err := writeRecord(header); err != nil {
+		return 0, fmt.Errorf("[DB_SERVICE:DumpCollection] failed to write dump header: %w", err)
+	}
+
+	cursor, err := s.KvService.ScanRangeBinary(collection.TableUri, nil, nil)
+	if err != nil {
+		return 0, fmt.Errorf("[DB_SERVICE:DumpCollection] failed to scan collection %s: %w", collection.Ns, err)
+	}
+	defer cursor.Close()
+
+	n := 0
+	for cursor.Next() {
+		_, docBin, err := cursor.Current()
+		if err != nil {
+			return n, err
+		}
+		if err := writeRecord(docBin); err != nil {
+			return n, fmt.Errorf("[DB_SERVICE:DumpCollection] failed to write document: %w", err)
+		}
+		n++
+	}
+	if err := cursor.Err(); err != nil {
+		return n, fmt.Errorf("[DB_SERVICE:DumpCollection] collection scan failed: %w", err)
+	}
+
+	trailer, err := bson.Marshal(DumpTrailer{Count: n})
+	if err != nil {
+		return n, fmt.Errorf("[DB_SERVICE:DumpCollection] failed to encode dump trailer: %v", err)
+	}
+	if err := writeRecord(trailer); err != nil {
+		return n, fmt.Errorf("[DB_SERVICE:DumpCollection] failed to write dump trailer: %w", err)
+	}
+
+	if err := out.Flush(); err != nil {
+		return n, fmt.Errorf("[DB_SERVICE:DumpCollection] failed to write dump: %w", err)
+	}
+	return n, nil
+}
+
+// RestoreCollection recreates a dumped collection, with its vector index,
+// text analyzer, embedder, schema and secondary indexes, under collection_name
+// (its dumped name if empty), and bulk loads its documents with opts. The
+// collection must not exist. It returns the number of documents restored; a
+// restore that fails leaves what it loaded, to be dropped before trying again.
+func (s *GDBService) RestoreCollection(collection_name string, r io.Reader, format string, opts BulkInsertOptions) (int, error) {
+	readRecord, err := newDumpReader(r, format)
+	if err != nil {
+		return 0, fmt.Errorf("[DB_SERVICE:RestoreCollection] %w", err)
+	}
+
+	var header DumpHeader
+	if err := readRecord(&header); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = errors.New("dump is empty")
+		}
+		return 0, fmt.Errorf("[DB_SERVICE:RestoreCollection] %w: failed to read dump header: %v", ErrInvalidDump, err)
+	}
+	if header.Version != DumpVersion {
+		return 0, fmt.Errorf("[DB_SERVICE:RestoreCollection] %w: unsupported dump version %d", ErrInvalidDump, header.Version)
+	}
+	if collection_name == "" {
+		collection_name = header.Collection
+	}
+
+	err = s.CreateCollection(collection_name, CollectionOptions{
+		Dimension:    header.VectorIndex.Dimension,
+		Metric:       header.VectorIndex.Metric,
+		IndexType:    header.VectorIndex.Factory,
+		TrainSize:    header.VectorIndex.TrainSize,
+		TextAnalyzer: header.TextAnalyzer,
+		Embedder:     header.Embedder,
+		Schema:       header.Schema,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Indexes are created empty, and filled as the documents are loaded.
+	for _, index := range header.Indexes {
+		if err := s.CreateIndex(collection_name, CollectionIndex{Key: index.Key, Name: index.Name, Type: index.Type, Opts: index.Opts}); err != nil {
+			return 0, err
+		}
+	}
+
+	documents := &dumpSource{readRecord: readRecord}
+	n, err := s.BulkInsert(collection_name, documents, opts)
+	if err != nil {
+		return n, err
+	}
+	if documents.trailer == nil {
+		return n, fmt.Errorf("[DB_SERVICE:RestoreCollection] %w: dump ends after %d documents without its trailer", ErrInvalidDump, n)
+	}
+	if n != documents.trailer.Count {
+		return n, fmt.Errorf("[DB_SERVICE:RestoreCollection] %w: dump holds %d documents, its trailer %d", ErrInvalidDump, n, documents.trailer.Count)
+	}
+	return n, nil
+}
+
+// newDumpWriter returns the function writing one record of a dump in format.
+func newDumpWriter(w *bufio.Writer, format string) (func(record bson.Raw) error, error) {
+	switch format {
+	case DumpFormatBSON:
+		return func(record bson.Raw) error {
+			_, err := w.Write(record)
+			return err
+		}, nil
+	case DumpFormatJSONL:
+		return func(record bson.Raw) error {
+			line, err := bson.MarshalExtJSON(record, true, false)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(line); err != nil {
+				return err
+			}
+			return w.WriteByte('\n')
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: unknown dump format %q", ErrInvalidDump, format)
+}
+
+// newDumpReader returns the function decoding the next record of a dump in
+// format into val. It returns io.EOF once every record has been read.
+func newDumpReader(r io.Reader, format string) (func(val interface{}) error, error) {
+	in := bufio.NewReader(r)
+	switch format {
+	case DumpFormatBSON:
+		return func(val interface{}) error {
+			record, err := bson.NewFromIOReader(in)
+			if err != nil {
+				return err
+			}
+			return bson.Unmarshal(record, val)
+		}, nil
+	case DumpFormatJSONL:
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 0, 64<<10), maxDumpLineSize)
+		return func(val interface{}) error {
+			for scanner.Scan() {
+				line := bytes.TrimSpace(scanner.Bytes())
+				if len(line) == 0 {
+					continue
+				}
+				// Relaxed parsing reads canonical records as well.
+				return bson.UnmarshalExtJSON(line, false, val)
+			}
+			if err := scanner.Err(); err != nil {
+				return err
+			}
+			return io.EOF
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: unknown dump format %q", ErrInvalidDump, format)
+}
+
+// dumpSource is the DocumentSource over the documents of a dump. It stops at
+// the dump's trailer, which must be its last record.
+type dumpSource struct {
+	readRecord func(val interface{}) error
+	doc        GlowstickDocument
+	read       int
+	trailer    *DumpTrailer // nil until read
+	err        error
+}
+
+func (d *dumpSource) Next() bool {
+	if d.err != nil || d.trailer != nil {
+		return false
+	}
+	var record bson.Raw
+	if err := d.readRecord(&record); err != nil {
+		if !errors.Is(err, io.EOF) {
+			d.err = fmt.Errorf("%w: document %d: %v", ErrInvalidDump, d.read, err)
+		}
+		return false
+	}
+
+	if _, err := record.LookupErr(dumpTrailerKey); err == nil {
+		var trailer DumpTrailer
+		if err := bson.Unmarshal(record, &trailer); err != nil {
+			d.err = fmt.Errorf("%w: trailer: %v", ErrInvalidDump, err)
+			return false
+		}
+		var extra bson.Raw
+		if err := d.readRecord(&extra); !errors.Is(err, io.EOF) {
+			d.err = fmt.Errorf("%w: records follow the dump trailer", ErrInvalidDump)
+			return false
+		}
+		d.trailer = &trailer
+		return false
+	}
+
+	var doc GlowstickDocument
+	if err := bson.Unmarshal(record, &doc); err != nil {
+		d.err = fmt.Errorf("%w: document %d: %v", ErrInvalidDump, d.read, err)
+		return false
+	}
+	d.doc = doc
+	d.read++
+	return true
+}
+
+func (d *dumpSource) Current() GlowstickDocument { return d.doc }
+func (d *dumpSource) Err() error                 { return d.err }
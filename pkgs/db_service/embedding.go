@@ -0,0 +1,83 @@
+package dbservice
+
+import (
+	"fmt"
+	"glowstickdb/pkgs/embedder"
+	"slices"
+)
+
+// newCollectionEmbedder checks an embedder configuration and reconciles its
+// dimension with the collection's, returning the dimension the collection
+// should be created with.
+func newCollectionEmbedder(config embedder.Config, dimension int) (int, error) {
+	e, err := embedder.New(config)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case e.Dimension() == 0:
+		return dimension, nil
+	case dimension == 0:
+		return e.Dimension(), nil
+	case dimension != e.Dimension():
+		return 0, fmt.Errorf("embedder produces %d dimensions but the collection has %d", e.Dimension(), dimension)
+	}
+	return dimension, nil
+}
+
+// embedTexts embeds texts with the collection's embedder.
+func embedTexts(collection CollectionCatalogEntry, texts []string) ([][]float32, error) {
+	if collection.Embedder == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoEmbedder, collection.Ns)
+	}
+	e, err := embedder.New(*collection.Embedder)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrEmbeddingFailed, err)
+	}
+	vectors, err := e.Embed(texts)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrEmbeddingFailed, err)
+	}
+	if len(vectors) != len(texts) {
+		return nil, fmt.Errorf("%w: got %d embeddings for %d texts", ErrEmbeddingFailed, len(vectors), len(texts))
+	}
+	return vectors, nil
+}
+
+// needsEmbedding reports whether embedDocuments has any of documents to embed.
+func needsEmbedding(documents []GlowstickDocument) bool {
+	for _, doc := range documents {
+		if len(doc.Embedding) == 0 && doc.Content != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// embedDocuments returns documents with the Embedding of every document that
+// has Content but no Embedding filled in by the collection's embedder. The
+// caller's slice is left untouched.
+func embedDocuments(collection CollectionCatalogEntry, documents []GlowstickDocument) ([]GlowstickDocument, error) {
+	var texts []string
+	var positions []int
+	for i, doc := range documents {
+		if len(doc.Embedding) == 0 && doc.Content != "" {
+			texts = append(texts, doc.Content)
+			positions = append(positions, i)
+		}
+	}
+	if len(texts) == 0 {
+		return documents, nil
+	}
+
+	vectors, err := embedTexts(collection, texts)
+	if err != nil {
+		return nil, err
+	}
+
+	documents = slices.Clone(documents)
+	for i, position := range positions {
+		documents[position].Embedding = vectors[i]
+	}
+	return documents, nil
+}
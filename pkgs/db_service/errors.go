@@ -0,0 +1,112 @@
+package dbservice
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrDocumentNotFound is returned when an operation targets a document _id that
+// does not exist in the collection.
+var ErrDocumentNotFound = errors.New("document not found")
+
+// ErrDatabaseNotFound is returned when the service's database has no catalog entry.
+var ErrDatabaseNotFound = errors.New("database not found")
+
+// ErrDatabaseExists is returned by CreateDB when the database is already in the catalog.
+var ErrDatabaseExists = errors.New("database already exists")
+
+// ErrCollectionNotFound is returned when a collection has no catalog entry.
+var ErrCollectionNotFound = errors.New("collection not found")
+
+// ErrCollectionExists is returned by CreateCollection when the name is taken.
+var ErrCollectionExists = errors.New("collection already exists")
+
+// ErrInvalidName is returned by CreateDB and CreateCollection for a name that
+// is empty or contains '.' or '/'.
+var ErrInvalidName = errors.New("invalid name")
+
+// ErrCollectionNotEmpty is returned by BulkInsert for a collection that has
+// already been written to.
+var ErrCollectionNotEmpty = errors.New("collection is not empty")
+
+// ErrInvalidDump is returned by RestoreCollection for input that is not a
+// dump, or a dump that is truncated or of an unknown version or format.
+var ErrInvalidDump = errors.New("invalid dump")
+
+// ErrInvalidFilter wraps the reason a QueryStruct.Filters expression failed to compile.
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// ErrInvalidQuery is returned for query settings that are out of range or
+// unknown, such as an unsupported fusion method.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// ErrInvalidOptions is returned by CreateCollection for a vector index
+// configuration that is malformed or that FAISS rejects.
+var ErrInvalidOptions = errors.New("invalid collection options")
+
+// ErrNoEmbedder is returned when a document or query has only text to embed
+// but its collection was created without an embedder.
+var ErrNoEmbedder = errors.New("collection has no embedder")
+
+// ErrEmbeddingFailed wraps the reason a collection's embedding provider could
+// not embed a document or query.
+var ErrEmbeddingFailed = errors.New("embedding failed")
+
+// ErrDuplicateKey is returned, inside a *BatchError, for documents whose ID or
+// Key is already taken, by another document in the collection or in the batch.
+var ErrDuplicateKey = errors.New("duplicate key")
+
+// ErrInvalidDocument is wrapped by the DocumentErrors of documents that failed
+// validation, so errors.Is reports it for a BatchError holding any of them.
+// Duplicate keys wrap ErrDuplicateKey instead.
+var ErrInvalidDocument = errors.New("invalid document")
+
+// DocumentError is why one document of a write was rejected.
+type DocumentError struct {
+	Index int                // position of the document in the batch
+	Id    primitive.ObjectID // the document's _id, zero if it had none
+	Err   error
+}
+
+func (e DocumentError) Error() string {
+	return fmt.Sprintf("documents[%d] (_id %s): %v", e.Index, e.Id.Hex(), e.Err)
+}
+
+func (e DocumentError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError is returned by writes whose documents failed validation or have
+// duplicate keys. It lists every rejected document; none of the batch was
+// written.
+type BatchError struct {
+	Documents []DocumentError
+	Total     int // size of the batch
+
+	// Offset is where the batch starts in a bulk load, whose Documents are
+	// indexed from the start of the load: the batch is documents
+	// [Offset, Offset+Total). It is 0 for other writes.
+	Offset int
+}
+
+func (e *BatchError) Error() string {
+	reasons := make([]string, len(e.Documents))
+	for i, doc := range e.Documents {
+		reasons[i] = doc.Error()
+	}
+	if e.Offset > 0 {
+		return fmt.Sprintf("%d of %d documents in documents[%d:%d] are invalid: %s", len(e.Documents), e.Total, e.Offset, e.Offset+e.Total, strings.Join(reasons, "; "))
+	}
+	return fmt.Sprintf("%d of %d documents are invalid: %s", len(e.Documents), e.Total, strings.Join(reasons, "; "))
+}
+
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, len(e.Documents))
+	for i, doc := range e.Documents {
+		errs[i] = doc
+	}
+	return errs
+}
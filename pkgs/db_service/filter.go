@@ -0,0 +1,367 @@
+package dbservice
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Filter is a compiled QueryStruct.Filters expression evaluated against
+// GlowstickDocument.Metadata.
+//
+// Filters use a MongoDB-style syntax. Field names are dot-separated paths into
+// the metadata document; a bare value is shorthand for $eq:
+//
+//	{"type": "example"}
+//	{"index": {"$gt": 1, "$lt": 10}}
+//	{"$or": [{"lang": {"$in": ["en", "fr"]}}, {"draft": {"$exists": false}}]}
+//
+// Supported operators are $eq, $ne, $gt, $gte, $lt, $lte, $in, $exists, $and and
+// $or. Sibling keys are ANDed. When the field holds an array, comparison
+// operators match if any element matches, as in MongoDB.
+type Filter interface {
+	Match(metadata interface{}) bool
+}
+
+type andFilter []Filter
+
+func (f andFilter) Match(metadata interface{}) bool {
+	for _, sub := range f {
+		if !sub.Match(metadata) {
+			return false
+		}
+	}
+	return true
+}
+
+type orFilter []Filter
+
+func (f orFilter) Match(metadata interface{}) bool {
+	for _, sub := range f {
+		if sub.Match(metadata) {
+			return true
+		}
+	}
+	return false
+}
+
+type fieldFilter struct {
+	path    []string
+	op      string
+	operand interface{}
+}
+
+func (f fieldFilter) Match(metadata interface{}) bool {
+	value, found := lookupPath(metadata, f.path)
+
+	switch f.op {
+	case "$exists":
+		return found == f.operand.(bool)
+	case "$eq":
+		return found && matchesAny(value, f.operand, valuesEqual) || !found && f.operand == nil
+	case "$ne":
+		return !(found && matchesAny(value, f.operand, valuesEqual) || !found && f.operand == nil)
+	case "$in":
+		for _, candidate := range f.operand.([]interface{}) {
+			if found && matchesAny(value, candidate, valuesEqual) || !found && candidate == nil {
+				return true
+			}
+		}
+		return false
+	case "$gt", "$gte", "$lt", "$lte":
+		if !found {
+			return false
+		}
+		return matchesAny(value, f.operand, func(a, b interface{}) bool {
+			cmp, ok := compareValues(a, b)
+			if !ok {
+				return false
+			}
+			switch f.op {
+			case "$gt":
+				return cmp > 0
+			case "$gte":
+				return cmp >= 0
+			case "$lt":
+				return cmp < 0
+			default:
+				return cmp <= 0
+			}
+		})
+	}
+	return false
+}
+
+// CompileFilter parses a QueryStruct.Filters map. It returns a nil Filter when
+// filters is empty, meaning every document matches.
+func CompileFilter(filters map[string]interface{}) (Filter, error) {
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	return compileFilterDoc(filters)
+}
+
+func compileFilterDoc(doc map[string]interface{}) (Filter, error) {
+	var clauses andFilter
+
+	for key, value := range doc {
+		switch {
+		case key == "$and" || key == "$or":
+			items, ok := asArray(value)
+			if !ok || len(items) == 0 {
+				return nil, fmt.Errorf("%s expects a non-empty array of filter documents", key)
+			}
+			var subs []Filter
+			for i, item := range items {
+				subDoc, ok := asMap(item)
+				if !ok {
+					return nil, fmt.Errorf("%s[%d] is not a filter document", key, i)
+				}
+				sub, err := compileFilterDoc(subDoc)
+				if err != nil {
+					return nil, err
+				}
+				subs = append(subs, sub)
+			}
+			if key == "$and" {
+				clauses = append(clauses, andFilter(subs))
+			} else {
+				clauses = append(clauses, orFilter(subs))
+			}
+
+		case strings.HasPrefix(key, "$"):
+			return nil, fmt.Errorf("unknown top-level operator %s", key)
+
+		default:
+			fieldClauses, err := compileFieldFilter(key, value)
+			if err != nil {
+				return nil, err
+			}
+			clauses = append(clauses, fieldClauses...)
+		}
+	}
+
+	if len(clauses) == 1 {
+		return clauses[0], nil
+	}
+	return clauses, nil
+}
+
+func compileFieldFilter(field string, value interface{}) ([]Filter, error) {
+	path := strings.Split(field, ".")
+
+	ops, isMap := asMap(value)
+	if !isMap || !isOperatorDoc(ops) {
+		return []Filter{fieldFilter{path: path, op: "$eq", operand: value}}, nil
+	}
+
+	var clauses []Filter
+	for op, operand := range ops {
+		switch op {
+		case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte":
+		case "$in":
+			items, ok := asArray(operand)
+			if !ok {
+				return nil, fmt.Errorf("%s: $in expects an array", field)
+			}
+			operand = items
+		case "$exists":
+			b, ok := operand.(bool)
+			if !ok {
+				return nil, fmt.Errorf("%s: $exists expects a boolean", field)
+			}
+			operand = b
+		default:
+			return nil, fmt.Errorf("%s: unknown operator %s", field, op)
+		}
+		clauses = append(clauses, fieldFilter{path: path, op: op, operand: operand})
+	}
+	return clauses, nil
+}
+
+func isOperatorDoc(doc map[string]interface{}) bool {
+	if len(doc) == 0 {
+		return false
+	}
+	for key := range doc {
+		if !strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupPath walks a dot-separated path through nested metadata documents.
+// Numeric segments index into arrays.
+func lookupPath(value interface{}, path []string) (interface{}, bool) {
+	current := value
+	for _, segment := range path {
+		if doc, ok := asMap(current); ok {
+			next, exists := doc[segment]
+			if !exists {
+				return nil, false
+			}
+			current = next
+			continue
+		}
+		if items, ok := asArray(current); ok {
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(items) {
+				return nil, false
+			}
+			current = items[i]
+			continue
+		}
+		return nil, false
+	}
+	return current, true
+}
+
+// matchesAny applies pred to value, or to each element when value is an array.
+func matchesAny(value interface{}, operand interface{}, pred func(a, b interface{}) bool) bool {
+	if pred(value, operand) {
+		return true
+	}
+	if items, ok := asArray(value); ok {
+		for _, item := range items {
+			if pred(item, operand) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func valuesEqual(a, b interface{}) bool {
+	if cmp, ok := compareValues(a, b); ok {
+		return cmp == 0
+	}
+	if ab, ok := a.(bool); ok {
+		bb, ok := b.(bool)
+		return ok && ab == bb
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compareValues orders two scalars of the same kind: numbers (of any Go
+// numeric type), strings, or timestamps. ok is false when they are not comparable.
+func compareValues(a, b interface{}) (cmp int, ok bool) {
+	if af, aok := toFloat64(a); aok {
+		bf, bok := toFloat64(b)
+		if !bok {
+			return 0, false
+		}
+		switch {
+		case af < bf:
+			return -1, true
+		case af > bf:
+			return 1, true
+		}
+		return 0, true
+	}
+	if as, aok := a.(string); aok {
+		bs, bok := b.(string)
+		if !bok {
+			return 0, false
+		}
+		return strings.Compare(as, bs), true
+	}
+	if at, aok := toMillis(a); aok {
+		bt, bok := toMillis(b)
+		if !bok {
+			return 0, false
+		}
+		switch {
+		case at < bt:
+			return -1, true
+		case at > bt:
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func toMillis(v interface{}) (int64, bool) {
+	switch t := v.(type) {
+	case primitive.DateTime:
+		return int64(t), true
+	case time.Time:
+		return t.UnixMilli(), true
+	}
+	return 0, false
+}
+
+// asMap normalizes the document types produced by JSON and BSON decoding.
+func asMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case primitive.M:
+		return m, true
+	case primitive.D:
+		out := make(map[string]interface{}, len(m))
+		for _, e := range m {
+			out[e.Key] = e.Value
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// asArray normalizes the array types produced by JSON and BSON decoding.
+func asArray(v interface{}) ([]interface{}, bool) {
+	switch a := v.(type) {
+	case []interface{}:
+		return a, true
+	case primitive.A:
+		return a, true
+	case []map[string]interface{}:
+		items := make([]interface{}, len(a))
+		for i, item := range a {
+			items[i] = item
+		}
+		return items, true
+	case []string:
+		items := make([]interface{}, len(a))
+		for i, item := range a {
+			items[i] = item
+		}
+		return items, true
+	}
+	return nil, false
+}
@@ -0,0 +1,177 @@
+package dbservice
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"sort"
+	"unicode/utf8"
+)
+
+// maxFuzzyEdits is the largest Levenshtein distance a fuzzy term may have.
+// Beyond two edits nearly every short term matches everything.
+const maxFuzzyEdits = 2
+
+// maxFuzzyExpansions caps how many dictionary terms one query term expands to;
+// the closest and most frequent are kept.
+const maxFuzzyExpansions = 50
+
+// fuzzyMatch is a dictionary term within reach of a query term.
+type fuzzyMatch struct {
+	term  string
+	edits int
+	df    uint64
+}
+
+// fuzzyWeight scales the BM25 contribution of a term that is edits away from
+// the query term, so exact matches always outrank misspellings.
+func fuzzyWeight(edits int) float64 {
+	return 1 / float64(1+edits)
+}
+
+// levenshteinRow advances a Levenshtein DP row for query by one rune of a
+// candidate term. row[i] is the distance between query[:i] and the candidate
+// prefix consumed so far.
+func levenshteinRow(query []rune, row []int, r rune) []int {
+	next := make([]int, len(row))
+	next[0] = row[0] + 1
+	for i := 1; i < len(row); i++ {
+		cost := 1
+		if query[i-1] == r {
+			cost = 0
+		}
+		next[i] = min(row[i]+1, next[i-1]+1, row[i-1]+cost)
+	}
+	return next
+}
+
+// matchTerm compares a dictionary term against query. It returns the edit
+// distance (for prefix matching, the smallest distance between query and any
+// prefix of term) and whether it is within maxEdits. dead is the length in
+// bytes of the shortest prefix of term that no term starting with it can
+// match, or -1 if there is none; the dictionary walk skips past it.
+func matchTerm(query []rune, term string, maxEdits int, prefix bool) (edits int, ok bool, dead int) {
+	row := make([]int, len(query)+1)
+	for i := range row {
+		row[i] = i
+	}
+
+	best := row[len(query)]
+	for offset, r := range term {
+		row = levenshteinRow(query, row, r)
+		best = min(best, row[len(query)])
+
+		// The smallest cell of a row never decreases as runes are appended, so
+		// once it exceeds maxEdits no term with this prefix can match, other than
+		// by a prefix already seen.
+		if slices.Min(row) > maxEdits {
+			if prefix && best <= maxEdits {
+				return best, true, -1
+			}
+			return 0, false, offset + utf8.RuneLen(r)
+		}
+	}
+
+	edits = row[len(query)]
+	if prefix {
+		edits = best
+	}
+	return edits, edits <= maxEdits, -1
+}
+
+// expandTerm finds the dictionary terms a query term matches: those within
+// maxEdits edits of it and, with prefix, those starting with a term within
+// maxEdits edits of it. The dictionary is walked in key order, seeking past
+// every prefix that can no longer match, so only a small part of it is read.
+func (s *GDBService) expandTerm(collection CollectionCatalogEntry, term string, maxEdits int, prefix bool) (termGroup, error) {
+	query := []rune(term)
+
+	// A term dictionary is never empty once the stats row exists; without it
+	// the search below would have nothing to land on.
+	if found, err := s.KvService.ExistsBinary(collection.TextIndexUri, []byte{textTagStats}); err != nil || !found {
+		return termGroup{}, err
+	}
+
+	var matches []fuzzyMatch
+	probe := []byte{textTagDictionary}
+	end := []byte{textTagDictionary + 1}
+
+	for {
+		key, val, ok, err := s.seekText(collection, probe, end)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+
+		candidate := string(key[1:])
+		edits, matched, dead := matchTerm(query, candidate, maxEdits, prefix)
+		if matched {
+			df, _ := binary.Uvarint(val)
+			matches = append(matches, fuzzyMatch{candidate, edits, df})
+		}
+
+		if dead >= 0 {
+			probe = prefixEnd(textDictionaryKey(candidate[:dead]))
+		} else {
+			probe = append(bytes.Clone(key), 0x00)
+		}
+		if probe == nil {
+			break
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].edits != matches[j].edits {
+			return matches[i].edits < matches[j].edits
+		}
+		if matches[i].df != matches[j].df {
+			return matches[i].df > matches[j].df
+		}
+		return matches[i].term < matches[j].term
+	})
+	if len(matches) > maxFuzzyExpansions {
+		matches = matches[:maxFuzzyExpansions]
+	}
+
+	group := make(termGroup, len(matches))
+	for _, m := range matches {
+		group[m.term] = fuzzyWeight(m.edits)
+	}
+	return group, nil
+}
+
+// seekText returns the first text index row with a key in [probe, end). A
+// SearchNear lands next to probe; only when it lands before it is a range scan
+// needed to step to the following key.
+func (s *GDBService) seekText(collection CollectionCatalogEntry, probe []byte, end []byte) ([]byte, []byte, bool, error) {
+	kv := s.KvService
+
+	key, val, exact, found, err := kv.SearchNearBinary(collection.TextIndexUri, probe)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("failed to search text dictionary: %w", err)
+	}
+	if found && exact >= 0 {
+		return key, val, bytes.Compare(key, end) < 0, nil
+	}
+
+	cursor, err := kv.ScanRangeBinary(collection.TextIndexUri, probe, end)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("failed to scan text dictionary: %w", err)
+	}
+	defer cursor.Close()
+
+	if !cursor.Next() {
+		if err := cursor.Err(); err != nil {
+			return nil, nil, false, fmt.Errorf("text dictionary scan failed: %w", err)
+		}
+		return nil, nil, false, nil
+	}
+	key, val, err = cursor.Current()
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return key, val, true, nil
+}
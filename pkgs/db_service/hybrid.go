@@ -0,0 +1,161 @@
+package dbservice
+
+import (
+	"fmt"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Ways a hybrid query fuses its vector and keyword rankings.
+const (
+	FusionRRF      = "rrf"      // reciprocal rank fusion: sum of 1/(k + rank) over both rankings
+	FusionWeighted = "weighted" // VectorWeight * vector score + (1 - VectorWeight) * normalized BM25
+)
+
+const (
+	defaultRRFConstant  = 60
+	defaultVectorWeight = 0.5
+)
+
+// hybridCandidateFactor is how many candidates per requested result each side
+// of a hybrid query contributes before fusion.
+const hybridCandidateFactor = 4
+
+// hybridQuery answers a QueryStruct with QueryText set: the vector search and
+// BM25 over Content each rank up to hybridCandidateFactor*TopK documents, the
+// union is fused into one ranking, and every result carries both component
+// scores. MaxDistance only limits what the vector search contributes.
+func (s *GDBService) hybridQuery(collection_name string, query QueryStruct) ([]QueryResult, error) {
+	if query.TopK <= 0 {
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - %w: TopK must be positive, got %d", ErrInvalidQuery, query.TopK)
+	}
+	fusion := query.Fusion
+	if fusion == "" {
+		fusion = FusionRRF
+	}
+	if fusion != FusionRRF && fusion != FusionWeighted {
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - %w: unsupported fusion %q", ErrInvalidQuery, fusion)
+	}
+	weight := float64(query.VectorWeight)
+	if weight == 0 {
+		weight = defaultVectorWeight
+	}
+	if weight < 0 || weight > 1 {
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - %w: vector weight must be in (0, 1], got %g", ErrInvalidQuery, weight)
+	}
+	rrfConstant := query.RRFConstant
+	if rrfConstant == 0 {
+		rrfConstant = defaultRRFConstant
+	}
+	if rrfConstant < 0 {
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - %w: RRF constant must not be negative, got %d", ErrInvalidQuery, rrfConstant)
+	}
+
+	filter, err := CompileFilter(query.Filters)
+	if err != nil {
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - %w: %v", ErrInvalidFilter, err)
+	}
+
+	collection, _, err := s.getCollection(collection_name)
+	if err != nil {
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - %w", err)
+	}
+
+	candidates := int(query.TopK) * hybridCandidateFactor
+
+	var vectorHits []QueryResult
+	if len(query.QueryEmbedding) > 0 {
+		vectorQuery := query
+		vectorQuery.QueryText = ""
+		vectorQuery.TopK = int32(candidates)
+		if vectorHits, err = s.queryCollection(collection_name, vectorQuery); err != nil {
+			return nil, err
+		}
+	}
+
+	textScores, err := s.textScores(collection, query.QueryText, 0, false)
+	if err != nil {
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - %w", err)
+	}
+	textHits, err := s.rankTextResults(collection, textScores, filter, candidates)
+	if err != nil {
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - %w", err)
+	}
+
+	type fused struct {
+		result     QueryResult
+		vectorRank int // 1-based; 0 if the vector search did not return it
+		textRank   int
+	}
+	byID := map[primitive.ObjectID]*fused{}
+	var order []*fused
+
+	for i, hit := range vectorHits {
+		hit.TextScore = float32(textScores[hit.Document.ID])
+		f := &fused{result: hit, vectorRank: i + 1}
+		byID[hit.Document.ID] = f
+		order = append(order, f)
+	}
+
+	metric := collection.VectorIndex.withDefaults().Metric
+	for i, hit := range textHits {
+		if f, ok := byID[hit.Document.ID]; ok {
+			f.textRank = i + 1
+			continue
+		}
+
+		// Found by keywords only: score its embedding directly so the result
+		// still carries a vector component.
+		result := QueryResult{Document: hit.Document, TextScore: hit.Score, Label: -1}
+		if len(query.QueryEmbedding) > 0 && len(hit.Document.Embedding) == len(query.QueryEmbedding) {
+			result.Distance = vectorDistance(metric, hit.Document.Embedding, query.QueryEmbedding)
+			result.VectorScore = distanceScore(metric, result.Distance)
+		}
+		if result.Label, err = s.documentLabel(collection, hit.Document.ID); err != nil {
+			return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - %w", err)
+		}
+		f := &fused{result: result, textRank: i + 1}
+		byID[hit.Document.ID] = f
+		order = append(order, f)
+	}
+
+	// BM25 is unbounded, so weighted fusion scales it by the best text score.
+	var maxTextScore float32
+	if len(textHits) > 0 {
+		maxTextScore = textHits[0].Score
+	}
+
+	for _, f := range order {
+		switch fusion {
+		case FusionRRF:
+			var score float64
+			if f.vectorRank > 0 {
+				score += 1 / float64(rrfConstant+f.vectorRank)
+			}
+			if f.textRank > 0 {
+				score += 1 / float64(rrfConstant+f.textRank)
+			}
+			f.result.Score = float32(score)
+		case FusionWeighted:
+			var text float64
+			if maxTextScore > 0 {
+				text = min(float64(f.result.TextScore/maxTextScore), 1)
+			}
+			f.result.Score = float32(weight*float64(f.result.VectorScore) + (1-weight)*text)
+		}
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return order[i].result.Score > order[j].result.Score
+	})
+
+	if len(order) > int(query.TopK) {
+		order = order[:query.TopK]
+	}
+	results := make([]QueryResult, len(order))
+	for i, f := range order {
+		results[i] = f.result
+	}
+	return results, nil
+}
@@ -1,12 +1,14 @@
 package dbservice
 
 import (
+	"errors"
 	"fmt"
-	"glowstickdb/pkgs/faiss"
+	bsonvalidator "glowstickdb/pkgs/bson-validator"
+	"glowstickdb/pkgs/embedder"
 	wt "glowstickdb/pkgs/wiredtiger"
-	"net/url"
+	"log/slog"
 	"os"
-	"sort"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -30,24 +32,61 @@ type CollectionIndex struct {
 }
 
 type CollectionCatalogEntry struct {
-	Id               primitive.ObjectID `bson:"_id"`
-	Ns               string             `bson:"ns"`
-	TableUri         string             `bson:"table_uri"`
-	VectorIndexUri   string             `bson:"vector_index_uri"`
-	IndexTableUriMap map[string]string  `bson:"index_table_uri_map,omitempty"`
-	Indexes          []CollectionIndex  `bson:"indexes,omitempty"`
-	CreatedAt        primitive.DateTime `bson:"createdAt"`
-	UpdatedAt        primitive.DateTime `bson:"updatedAt"`
+	Id               primitive.ObjectID    `bson:"_id"`
+	Ns               string                `bson:"ns"`
+	TableUri         string                `bson:"table_uri"`
+	VectorIndexUri   string                `bson:"vector_index_uri"`
+	VectorIndex      VectorIndexConfig     `bson:"vector_index"`
+	LabelToDocUri    string                `bson:"label_to_doc_uri,omitempty"` // FAISS label -> document _id (hex)
+	DocToLabelUri    string                `bson:"doc_to_label_uri,omitempty"` // document _id (hex) -> FAISS label
+	IndexTableUriMap map[string]string     `bson:"index_table_uri_map,omitempty"`
+	TextIndexUri     string                `bson:"text_index_uri,omitempty"` // inverted index over Content
+	KeyIndexUri      string                `bson:"key_index_uri,omitempty"`  // document Key -> _id
+	TextAnalyzer     string                `bson:"text_analyzer,omitempty"`
+	Embedder         *embedder.Config      `bson:"embedder,omitempty"` // embeds Content and query text when no embedding is given
+	Schema           *bsonvalidator.Schema `bson:"schema,omitempty"`   // enforced on every write
+	Indexes          []CollectionIndex     `bson:"indexes,omitempty"`
+	CreatedAt        primitive.DateTime    `bson:"createdAt"`
+	UpdatedAt        primitive.DateTime    `bson:"updatedAt"`
 }
 
 type CollectionStats struct {
 	Doc_Count         int
 	Vector_Index_Size float64
+	Tombstone_Count   int // vectors still in the FAISS index whose document was updated or deleted
 }
 
+// filterOverFetchFactor is how many candidates per requested result a filtered
+// query fetches from the vector index on its first pass.
+const filterOverFetchFactor = 4
+
 type GDBService struct {
 	Name      string
 	KvService wt.WTService
+	Logger    *slog.Logger
+	Indexes   *IndexManager
+}
+
+// logger returns the service's logger, discarding output if none was configured.
+func (s *GDBService) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return s.Logger
+}
+
+// checkName rejects database and collection names that would be ambiguous in
+// the keys and file names built from them: collections are catalogued as
+// "<db>.<collection>", their vector WAL entries are keyed
+// "<db>.<collection>/<label>" and their index files are named after both.
+func checkName(kind, name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: %s name cannot be empty", ErrInvalidName, kind)
+	}
+	if strings.ContainsAny(name, "./") {
+		return fmt.Errorf("%w: %s name %q cannot contain '.' or '/'", ErrInvalidName, kind, name)
+	}
+	return nil
 }
 
 func (s *GDBService) CreateDB() error {
@@ -58,8 +97,16 @@ func (s *GDBService) CreateDB() error {
 		return err
 	}
 
-	if s.Name == "" {
-		return fmt.Errorf("database name cannot be empty")
+	if err := checkName("database", s.Name); err != nil {
+		return err
+	}
+
+	exists, err := s.KvService.ExistsBinary(CATALOG, []byte(fmt.Sprintf("db:%s", s.Name)))
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("%w: %s", ErrDatabaseExists, s.Name)
 	}
 
 	catalogEntry := DbCatalogEntry{
@@ -83,25 +130,73 @@ func (s *GDBService) CreateDB() error {
 	return nil
 }
 
-func (s *GDBService) DeleteDB(name string) error {
-	return nil
-}
-
-func (s *GDBService) CreateCollection(collection_name string) error {
+// CreateCollection creates a collection. At most one CollectionOptions may be
+// given to configure its vector index; without it the collection gets an L2
+// "Flat" index sized by the first insert.
+func (s *GDBService) CreateCollection(collection_name string, options ...CollectionOptions) error {
 	kv := s.KvService
 
+	if len(options) > 1 {
+		return fmt.Errorf("[GDBSERVICE:CreateCollection] expected at most one CollectionOptions, got %d", len(options))
+	}
+	var opts CollectionOptions
+	if len(options) == 1 {
+		opts = options[0]
+	}
+
+	if err := checkName("collection", collection_name); err != nil {
+		return fmt.Errorf("[GDBSERVICE:CreateCollection] %w", err)
+	}
+
+	if opts.Embedder != nil {
+		dimension, err := newCollectionEmbedder(*opts.Embedder, opts.Dimension)
+		if err != nil {
+			return fmt.Errorf("[GDBSERVICE:CreateCollection] %w: %v", ErrInvalidOptions, err)
+		}
+		opts.Dimension = dimension
+	}
+
+	vectorIndex, err := newVectorIndexConfig(opts)
+	if err != nil {
+		return fmt.Errorf("[GDBSERVICE:CreateCollection] %w: %v", ErrInvalidOptions, err)
+	}
+	if _, err := lookupAnalyzer(opts.TextAnalyzer); err != nil {
+		return fmt.Errorf("[GDBSERVICE:CreateCollection] %w: %v", ErrInvalidOptions, err)
+	}
+	if err := opts.Schema.Check(); err != nil {
+		return fmt.Errorf("[GDBSERVICE:CreateCollection] %w: %v", ErrInvalidOptions, err)
+	}
+
+	defer s.lockCollection(collection_name)()
+
 	// Pass in the kv service to init tables (to avoid one-off failures)
-	err := InitTablesHelper(kv)
+	err = InitTablesHelper(kv)
 	if err != nil {
 		return err
 	}
 
-	if len(collection_name) == 0 {
-		return fmt.Errorf("collection name cannot be empty")
+	dbExists, err := kv.ExistsBinary(CATALOG, []byte(fmt.Sprintf("db:%s", s.Name)))
+	if err != nil {
+		return err
+	}
+	if !dbExists {
+		return fmt.Errorf("[GDBSERVICE:CreateCollection] %w: %s", ErrDatabaseNotFound, s.Name)
+	}
+
+	collExists, err := kv.ExistsBinary(CATALOG, []byte(fmt.Sprintf("%s.%s", s.Name, collection_name)))
+	if err != nil {
+		return err
+	}
+	if collExists {
+		return fmt.Errorf("[GDBSERVICE:CreateCollection] %w: %s.%s", ErrCollectionExists, s.Name, collection_name)
 	}
 
 	collectionId := primitive.NewObjectID()
 	collectionTableUri := fmt.Sprintf("table:collection-%s-%s", collectionId.Hex(), s.Name)
+	labelToDocUri := fmt.Sprintf("table:label_docID-%s-%s", collectionId.Hex(), s.Name)
+	docToLabelUri := fmt.Sprintf("table:docID_label-%s-%s", collectionId.Hex(), s.Name)
+	textIndexUri := fmt.Sprintf("table:text-%s-%s", collectionId.Hex(), s.Name)
+	keyIndexUri := fmt.Sprintf("table:keys-%s-%s", collectionId.Hex(), s.Name)
 
 	catalogEntry := CollectionCatalogEntry{
 		Id: collectionId,
@@ -109,25 +204,50 @@ func (s *GDBService) CreateCollection(collection_name string) error {
 		Ns: fmt.Sprintf("%s.%s", s.Name, collection_name),
 		// The wiredtiger table where the collection's document
 		TableUri:       collectionTableUri,
-		VectorIndexUri: fmt.Sprintf("%s%s", collection_name, ".index"),
+		VectorIndexUri: fmt.Sprintf("%s.%s%s", s.Name, collection_name, ".index"),
+		VectorIndex:    vectorIndex,
+		LabelToDocUri:  labelToDocUri,
+		DocToLabelUri:  docToLabelUri,
+		TextIndexUri:   textIndexUri,
+		KeyIndexUri:    keyIndexUri,
+		TextAnalyzer:   opts.TextAnalyzer,
+		Embedder:       opts.Embedder,
+		Schema:         opts.Schema,
 		CreatedAt:      primitive.NewDateTimeFromTime(time.Now()),
 		UpdatedAt:      primitive.NewDateTimeFromTime(time.Now()),
 	}
 
 	err = s.KvService.CreateTable(collectionTableUri, "key_format=u,value_format=u")
 	if err != nil {
-		fmt.Printf("[GDBSERVICE:CreateCollection:Goroutine] Failed to create table %s: %v\n", collectionTableUri, err)
 		return fmt.Errorf("[GDBSERVICE:CreateCollection:Goroutine] Failed to create table %s: %v", collectionTableUri, err)
 	}
 
+	if err := createLabelTables(kv, labelToDocUri, docToLabelUri); err != nil {
+		return fmt.Errorf("[GDBSERVICE:CreateCollection] %v", err)
+	}
+
+	for _, tableUri := range []string{textIndexUri, keyIndexUri} {
+		if err := kv.CreateTable(tableUri, "key_format=u,value_format=u"); err != nil {
+			return fmt.Errorf("[GDBSERVICE:CreateCollection] failed to create table %s: %v", tableUri, err)
+		}
+	}
+
+	// With the dimension known up front the index is built now, which also
+	// rejects factory strings FAISS cannot parse before the collection exists.
+	if vectorIndex.Dimension > 0 {
+		idx, err := createVectorIndex(catalogEntry)
+		if err != nil {
+			return fmt.Errorf("[GDBSERVICE:CreateCollection] %w: %v", ErrInvalidOptions, err)
+		}
+		idx.Free()
+	}
+
 	doc, err := bson.Marshal(catalogEntry)
 
 	if err != nil {
 		return fmt.Errorf("[GDBSERVICE:CreateCollection]: Failed to encode catalog entry")
 	}
 
-	err = kv.PutBinaryWithStringKey(CATALOG, fmt.Sprintf("%s.%s", s.Name, collection_name), doc)
-
 	// STATS
 	// Create entry in hot stats table
 	statsEntry := CollectionStats{
@@ -135,280 +255,533 @@ func (s *GDBService) CreateCollection(collection_name string) error {
 		Vector_Index_Size: 0,
 	}
 
-	stats_doc, _ := bson.Marshal(statsEntry)
+	stats_doc, err := bson.Marshal(statsEntry)
 
 	if err != nil {
-		return fmt.Errorf("[GDBSERVICE:CreateCollection]: Failed to encode catalog entry")
+		return fmt.Errorf("[GDBSERVICE:CreateCollection]: Failed to encode stats entry")
 	}
 
-	err = kv.PutBinaryWithStringKey(STATS, fmt.Sprintf("%s.%s", s.Name, collection_name), stats_doc)
+	// The catalog and stats rows are written together so a collection is never
+	// visible without its stats entry.
+	return s.withTransaction(func(sess wt.Session) error {
+		if err := sess.PutBinaryWithStringKey(CATALOG, fmt.Sprintf("%s.%s", s.Name, collection_name), doc); err != nil {
+			return fmt.Errorf("failed to write collection catalog entry: %w", err)
+		}
 
-	if err != nil {
-		return fmt.Errorf("failed to write db catalog entry")
-	}
+		if err := sess.PutBinaryWithStringKey(STATS, fmt.Sprintf("%s.%s", s.Name, collection_name), stats_doc); err != nil {
+			return fmt.Errorf("failed to write collection stats entry: %w", err)
+		}
 
-	return nil
+		return nil
+	})
 }
 
+// InsertDocumentsIntoCollection adds new documents. Documents without an ID
+// are assigned one in place; it is an ErrDuplicateKey error for an ID or Key to
+// be taken already.
 func (s *GDBService) InsertDocumentsIntoCollection(collection_name string, documents []GlowstickDocument) error {
-	kv := s.KvService
-	vectr := faiss.FAISS()
-
-	collectionDefKey := fmt.Sprintf("%s.%s", s.Name, collection_name)
-	val, exists, err := kv.GetBinary(CATALOG, []byte(collectionDefKey))
+	return s.writeDocuments(collection_name, documents, writeInsert)
+}
 
-	if !exists {
-		return fmt.Errorf("collection:%s could not be found in the db", collection_name)
+// writeDocuments stores a batch of documents together with their vectors,
+// label mappings, secondary index entries and stats. For updates and upserts
+// the previous version of a document is unindexed, and if its embedding changed
+// its old vector is tombstoned and a new one is added under a fresh label.
+func (s *GDBService) writeDocuments(collection_name string, documents []GlowstickDocument, mode writeMode) error {
+	// Embedding may wait on a remote provider, so it happens, with handing out
+	// new IDs, before the collection is locked.
+	collection, _, err := s.getCollection(collection_name)
+	if err != nil {
+		return err
 	}
-
+	newIDs(documents, mode)
+	embedded, err := embedDocuments(collection, documents)
 	if err != nil {
 		return err
 	}
 
-	var collection CollectionCatalogEntry
+	defer s.lockCollection(collection_name)()
 
-	bson.Unmarshal(val, &collection)
+	collection, collectionDefKey, err := s.getCollection(collection_name)
+	if err != nil {
+		return err
+	}
 
-	vectorIndexUri := collection.VectorIndexUri
+	// Upserts by Key look up the document holding it only now, when no other
+	// writer can change the key index.
+	if err := s.assignIDs(collection, documents, mode); err != nil {
+		return err
+	}
+	for i := range embedded {
+		embedded[i].ID = documents[i].ID
+	}
+	documents = embedded
 
-	var filePath string
+	if len(documents) == 0 {
+		return nil
+	}
 
-	u, err := url.Parse(vectorIndexUri)
+	// Nothing is written unless the whole batch is valid. A collection created
+	// without a dimension takes it from its first batch and keeps it.
+	dimension, err := validateDocuments(collection, documents)
 	if err != nil {
-		return fmt.Errorf("failed to parse vector index URI: %v", err)
+		return err
 	}
-	filePath = u.Path
-
-	idx, err := vectr.ReadIndex(filePath)
+	inferDimension := collection.VectorIndex.Dimension == 0
+	collection.VectorIndex.Dimension = dimension
 
+	// Re-add any vectors a previous crash left out of the index file before new
+	// labels are handed out.
+	handle, err := s.acquireVectorIndex(collection, collectionDefKey, true)
 	if err != nil {
-		const indexDesc = "Flat"
-		idx, err = vectr.IndexFactory(len(documents[0].Embedding), indexDesc, faiss.MetricL2)
-		if err != nil {
-			return fmt.Errorf("failed to create new vector index for collection: %v (after failing to load old: %w)", err, err)
-		}
-
-		if writeErr := idx.WriteToFile(filePath); writeErr != nil {
-			return fmt.Errorf("failed to persist new IVF index to %s: %v", filePath, writeErr)
-		}
-		//return fmt.Errorf("unable to read vector index index from file path:%s", filePath)
+		return err
 	}
+	defer handle.release()
+	idx, filePath := handle.idx, handle.filePath
 
-	hot_stats, _, err := kv.GetBinary(STATS, []byte(collectionDefKey))
-
+	trained, err := idx.IsTrained()
 	if err != nil {
-		return fmt.Errorf("failed to fetch hot stats:%s", err)
+		return fmt.Errorf("failed to read vector index training state: %v", err)
 	}
 
-	var hot_stats_doc CollectionStats
-
-	err = bson.Unmarshal(hot_stats, &hot_stats_doc)
+	nTotal, err := idx.NTotal()
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal hot stats bson into struct:%s", err)
+		return fmt.Errorf("failed to read vector index size: %v", err)
 	}
+	// Labels still buffered for training are taken even though they are not in the index.
+	nextLabel := nTotal + handle.pending
+	metric := collection.VectorIndex.withDefaults().Metric
 
 	destTableURI := collection.TableUri
+	var labels []int64
 
-	for _, doc := range documents {
-		doc_bytes, err := bson.Marshal(doc)
-		if err != nil {
-			return fmt.Errorf("failed to marshal document to BSON: %v", err)
+	// Documents, label mappings and the stats row are committed as one unit:
+	// either the whole batch lands or none of it does.
+	err = s.withTransaction(func(sess wt.Session) error {
+		if err := checkDuplicateKeys(sess, collection, documents, mode); err != nil {
+			return err
 		}
-		key := doc._Id[:]
 
-		if err := s.KvService.PutBinary(destTableURI, key, doc_bytes); err != nil {
-			return fmt.Errorf("failed to insert document with _id %s: %v", doc._Id.Hex(), err)
+		hot_stats, _, err := sess.GetBinary(STATS, []byte(collectionDefKey))
+
+		if err != nil {
+			return fmt.Errorf("failed to fetch hot stats:%s", err)
 		}
 
-		err = idx.Add(doc.Embedding, 1)
-		var label int64 = -1
+		var hot_stats_doc CollectionStats
+
+		err = bson.Unmarshal(hot_stats, &hot_stats_doc)
 		if err != nil {
-			return fmt.Errorf("failed to add embedding to index for _id %s: %v", doc._Id.Hex(), err)
+			return fmt.Errorf("failed to unmarshal hot stats bson into struct:%s", err)
 		}
 
-		if nTotal, nErr := idx.NTotal(); nErr == nil {
-			label = nTotal - 1
+		for _, doc := range documents {
+			key := doc.ID[:]
+			docIDHex := fmt.Sprintf("%x", key)
+
+			var previous *GlowstickDocument
+			if mode != writeInsert {
+				previous, err = readDocument(sess, collection, doc.ID)
+				if err != nil {
+					return err
+				}
+				if previous == nil && mode == writeUpdate {
+					return fmt.Errorf("%w: _id %s", ErrDocumentNotFound, doc.ID.Hex())
+				}
+			}
+
+			doc_bytes, err := bson.Marshal(doc)
+			if err != nil {
+				return fmt.Errorf("failed to marshal document to BSON: %v", err)
+			}
+
+			if err := sess.PutBinary(destTableURI, key, doc_bytes); err != nil {
+				return fmt.Errorf("failed to insert document with _id %s: %v", doc.ID.Hex(), err)
+			}
+
+			if previous != nil {
+				if err := unindexDocument(sess, collection, *previous); err != nil {
+					return err
+				}
+			}
+
+			if err := indexDocument(sess, collection, doc); err != nil {
+				return err
+			}
+
+			if previous != nil {
+				// Same vector: the existing label keeps pointing at this document.
+				if embeddingsEqual(previous.Embedding, doc.Embedding) {
+					continue
+				}
+				if _, err := tombstoneDocument(sess, collection, docIDHex); err != nil {
+					return err
+				}
+				hot_stats_doc.Tombstone_Count += 1
+			} else {
+				hot_stats_doc.Doc_Count += 1
+			}
+
+			// An untrained index cannot take vectors yet; the WAL entry below
+			// buffers this one until the index is trained.
+			vector := indexVector(metric, doc.Embedding)
+			if trained {
+				if err := idx.Add(vector, 1); err != nil {
+					return fmt.Errorf("failed to add embedding to index for _id %s: %v", doc.ID.Hex(), err)
+				}
+			}
+
+			label := nextLabel
+			nextLabel++
+
+			err = sess.PutString(collection.LabelToDocUri, fmt.Sprintf("%d", label), docIDHex)
+
+			if err != nil {
+				return fmt.Errorf("failed to write label->docID mapping to table: %v", err)
+			}
+
+			err = sess.PutString(collection.DocToLabelUri, docIDHex, fmt.Sprintf("%d", label))
+
+			if err != nil {
+				return fmt.Errorf("failed to write docID->label mapping to table: %v", err)
+			}
+
+			if err := logVectorAdd(sess, collectionDefKey, label, doc.ID, vector); err != nil {
+				return err
+			}
+			labels = append(labels, label)
 		}
 
-		docIDHex := fmt.Sprintf("%x", key)
-		err = s.KvService.PutString(LABELS_TO_DOC_ID_MAPPING_TABLE_URI, fmt.Sprintf("%d", label), docIDHex)
+		info, err := os.Stat(filePath)
 
 		if err != nil {
-			return fmt.Errorf("failed to write label->docID mapping to table: %v", err)
+			return fmt.Errorf("failed to read file info from vector index file")
 		}
 
-		hot_stats_doc.Doc_Count += 1
-	}
+		hot_stats_doc.Vector_Index_Size += float64(info.Size())
 
-	info, err := os.Stat(filePath)
+		bytes, err := bson.Marshal(hot_stats_doc)
 
-	if err != nil {
-		return fmt.Errorf("failed to read file info from vector index file")
-	}
+		if err != nil {
+			return fmt.Errorf("failed to marshal hot stats during write")
+		}
 
-	hot_stats_doc.Vector_Index_Size += float64(info.Size())
+		if err := sess.PutBinary(STATS, []byte(collectionDefKey), bytes); err != nil {
+			return fmt.Errorf("failed to write hot stats: %s", err)
+		}
 
-	bytes, err := bson.Marshal(hot_stats_doc)
+		if inferDimension {
+			entry, err := bson.Marshal(collection)
+			if err != nil {
+				return fmt.Errorf("failed to encode catalog entry: %v", err)
+			}
+			if err := sess.PutBinaryWithStringKey(CATALOG, collectionDefKey, entry); err != nil {
+				return fmt.Errorf("failed to record collection dimension: %v", err)
+			}
+		}
+
+		return nil
+	})
 
 	if err != nil {
-		return fmt.Errorf("failed to marshal hot stats during write")
+		// The index may hold vectors of the rolled back batch.
+		handle.discard()
+		return err
 	}
-	err = kv.PutBinary(STATS, []byte(collectionDefKey), bytes)
 
-	if err != nil {
-		return fmt.Errorf("failed to write hot stats: %s", err)
+	if len(labels) == 0 {
+		return nil
+	}
+
+	// Buffered vectors are trained on and added once there are enough of them.
+	if !trained {
+		pending, err := s.replayVectorWal(idx, collectionDefKey, filePath, collection.VectorIndex.withDefaults().TrainSize)
+		if err != nil {
+			s.logger().Warn("failed to train vector index", "collection", collectionDefKey, "error", err)
+			handle.discard()
+			return nil
+		}
+		handle.setPending(pending)
+		return nil
+	}
+
+	// The batch is committed and logged; a failure from here on is repaired by
+	// replaying the WAL the next time the index is loaded. A cached index may
+	// leave writing the file, and clearing the WAL, to its manager.
+	addedBytes := int64(len(labels) * dimension * 4)
+	if handle.deferFlush() {
+		handle.added(s, labels, addedBytes, false)
+		return nil
 	}
 
 	if err := idx.WriteToFile(filePath); err != nil {
+		handle.discard()
 		return fmt.Errorf("writeToFile failed: %v", err)
 	}
+	handle.added(s, labels, addedBytes, true)
+
+	if err := s.checkpointVectorWal(collectionDefKey, labels); err != nil {
+		s.logger().Warn("failed to checkpoint vector WAL", "collection", collectionDefKey, "error", err)
+	}
 
 	return nil
 }
 
-func (s *GDBService) ListCollections() error {
-	return nil
+func (s *GDBService) QueryCollection(collection_name string, query QueryStruct) ([]QueryResult, error) {
+	// Embedding may wait on a remote provider, so it happens before the
+	// collection is locked.
+	if len(query.QueryEmbedding) == 0 && query.QueryContent != "" {
+		collection, _, err := s.getCollection(collection_name)
+		if err != nil {
+			return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - %w", err)
+		}
+		vectors, err := embedTexts(collection, []string{query.QueryContent})
+		if err != nil {
+			return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - %w", err)
+		}
+		query.QueryEmbedding = vectors[0]
+	}
+
+	defer s.rlockCollection(collection_name)()
+	return s.queryCollection(collection_name, query)
 }
 
-func (s *GDBService) QueryCollection(collection_name string, query QueryStruct) ([]GlowstickDocument, error) {
-	kv := s.KvService
-	vectr_svc := faiss.FAISS()
+// queryCollection answers QueryCollection, with QueryContent already embedded,
+// for a caller holding the collection's lock.
+func (s *GDBService) queryCollection(collection_name string, query QueryStruct) ([]QueryResult, error) {
+	if query.QueryText != "" {
+		return s.hybridQuery(collection_name, query)
+	}
 
-	docs := []GlowstickDocument{}
+	if query.TopK <= 0 {
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - %w: TopK must be positive, got %d", ErrInvalidQuery, query.TopK)
+	}
 
-	collectionDefKey := fmt.Sprintf("%s.%s", s.Name, collection_name)
+	kv := s.KvService
+	log := s.logger()
 
-	val, exists, err := kv.GetBinary(CATALOG, []byte(collectionDefKey))
+	results := []QueryResult{}
 
-	if !exists {
-		return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - collection could not be found in the db")
+	filter, err := CompileFilter(query.Filters)
+	if err != nil {
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - %w: %v", ErrInvalidFilter, err)
 	}
 
+	collection, collectionDefKey, err := s.getCollection(collection_name)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - %w", err)
 	}
 
-	var collection CollectionCatalogEntry
+	if len(query.QueryEmbedding) == 0 {
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - %w: query embedding must not be empty", ErrInvalidQuery)
+	}
+	if dimension := collection.VectorIndex.Dimension; dimension > 0 && len(query.QueryEmbedding) != dimension {
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - %w: query embedding has %d dimensions, collection has %d", ErrInvalidQuery, len(query.QueryEmbedding), dimension)
+	}
 
-	bson.Unmarshal(val, &collection)
+	// When secondary indexes narrow the filter down to a small candidate set,
+	// score those documents directly. Larger sets still go through the vector
+	// index but let us skip non-candidates before fetching them.
+	var candidates map[primitive.ObjectID]struct{}
+	if filter != nil && len(collection.Indexes) > 0 {
+		var indexed bool
+		candidates, indexed, err = s.indexCandidates(collection, filter)
+		if err != nil {
+			return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - %w", err)
+		}
+		if indexed && len(candidates) <= exactSearchMaxCandidates {
+			return s.exactSearch(collection, candidates, filter, query)
+		}
+	}
 
-	vectorIndexUri := collection.VectorIndexUri
+	handle, err := s.acquireVectorIndex(collection, collectionDefKey, false)
+	if errors.Is(err, errNoVectorIndex) {
+		return results, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - %w", err)
+	}
+	defer handle.release()
+	idx := handle.idx
 
-	var filePath string
+	// Until an IVF/PQ index has buffered enough vectors to be trained, the
+	// collection is small enough to score every document directly.
+	trained, err := idx.IsTrained()
+	if err != nil {
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - failed to read vector index training state: %w", err)
+	}
+	if !trained {
+		if candidates == nil {
+			if candidates, err = s.collectionIDs(collection); err != nil {
+				return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - %w", err)
+			}
+		}
+		return s.exactSearch(collection, candidates, filter, query)
+	}
 
-	u, err := url.Parse(vectorIndexUri)
+	nTotal, err := idx.NTotal()
 	if err != nil {
-		return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - failed to parse vector index URI: %v", err)
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - failed to read vector index size: %w", err)
 	}
-	filePath = u.Path
 
-	idx, err := vectr_svc.ReadIndex(filePath)
+	metric := collection.VectorIndex.withDefaults().Metric
+	queryVector := indexVector(metric, query.QueryEmbedding)
 
-	if err != nil {
-		return nil, fmt.Errorf("could not vector index after specfied file path")
+	// Filters and tombstones are applied after the vector search. To still
+	// return TopK documents, over-fetch candidates and keep widening the search
+	// until enough of them survive or the whole index has been searched.
+	k := int(query.TopK)
+	if filter != nil {
+		k *= filterOverFetchFactor
 	}
 
-	distances, ids, err := idx.Search(query.QueryEmbedding, 1, int(query.TopK))
+	var lastErr error
+	for {
+		if int64(k) > nTotal {
+			k = int(nTotal)
+		}
 
-	// Print distances and IDs in a table format
-	fmt.Printf("\n%-10s %-15s\n", "Index", "Distance")
-	fmt.Printf("%-10s %-15s\n", "-----", "--------")
-	for i, distance := range distances {
-		fmt.Printf("%-10d %-15.6f\n", ids[i], distance)
+		distances, ids, err := idx.Search(queryVector, 1, k)
+		if err != nil {
+			return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - failed to search vector index for query embedding: %w", err)
+		}
+		log.Debug("searched vector index", "collection", collectionDefKey, "k", k, "labels", ids, "distances", distances)
+
+		var skipped int
+		results, skipped, lastErr, err = s.resolveHits(kv, collection, collectionDefKey, candidates, filter, query, distances, ids)
+		if err != nil {
+			return nil, err
+		}
+
+		if skipped == 0 || len(results) >= int(query.TopK) || int64(k) >= nTotal {
+			break
+		}
+		k *= 2
 	}
-	fmt.Println()
 
-	if err != nil {
-		return nil, fmt.Errorf("[DB_SERVICE:QueryCollection] - failed to search vector index for query embedding")
+	return results, lastErr
+}
+
+// createLabelTables creates a collection's label->docID and docID->label mapping tables.
+func createLabelTables(kv wt.WTService, labelToDocUri string, docToLabelUri string) error {
+	if err := kv.CreateTable(labelToDocUri, "key_format=S,value_format=S"); err != nil {
+		return fmt.Errorf("failed to create table %s: %v", labelToDocUri, err)
 	}
 
-	indices := make([]int, len(distances))
-	for i := range indices {
-		indices[i] = i
+	if err := kv.CreateTable(docToLabelUri, "key_format=S,value_format=S"); err != nil {
+		return fmt.Errorf("failed to create table %s: %v", docToLabelUri, err)
 	}
 
-	sort.Slice(indices, func(i, j int) bool {
-		return distances[indices[i]] < distances[indices[j]]
-	})
+	return nil
+}
+
+// migrateLabelMappings upgrades a collection created before collections owned
+// their label mapping tables. It creates the tables, copies every row of the
+// legacy global mapping whose document lives in this collection, and records the
+// new table URIs in the catalog, all before the collection is used. Labels that
+// another collection had already overwritten in the global table cannot be
+// recovered and are left unmapped. Collections that already have their own
+// tables are returned untouched.
+func (s *GDBService) migrateLabelMappings(collection *CollectionCatalogEntry, collectionDefKey string) error {
+	if collection.LabelToDocUri != "" && collection.DocToLabelUri != "" {
+		return nil
+	}
 
-	var lastErr error = err
-	for _, index := range indices {
-		id := ids[index]
+	kv := s.KvService
+	labelToDocUri := fmt.Sprintf("table:label_docID-%s-%s", collection.Id.Hex(), s.Name)
+	docToLabelUri := fmt.Sprintf("table:docID_label-%s-%s", collection.Id.Hex(), s.Name)
 
-		fmt.Printf("ID: %d\n", id)
-		distance := distances[index]
+	if err := createLabelTables(kv, labelToDocUri, docToLabelUri); err != nil {
+		return fmt.Errorf("label mapping migration failed: %v", err)
+	}
 
-		// id could be -1 if FAISS returned a "no result"; handle this
-		if id < 0 {
-			continue
-		}
+	// Labels are decimal strings ("-1" at worst), so ":" bounds every key.
+	cursor, err := kv.ScanRange(LABELS_TO_DOC_ID_MAPPING_TABLE_URI, "", ":")
+	if err != nil {
+		return fmt.Errorf("label mapping migration failed to scan %s: %v", LABELS_TO_DOC_ID_MAPPING_TABLE_URI, err)
+	}
+	defer cursor.Close()
+
+	type mapping struct{ label, docIDHex string }
+	var owned []mapping
 
-		key := fmt.Sprintf("%d", id)
-		val, _, err := kv.GetString(LABELS_TO_DOC_ID_MAPPING_TABLE_URI, key)
+	for cursor.Next() {
+		label, docIDHex, err := cursor.CurrentString()
 		if err != nil {
-			fmt.Printf("Failed to get docID for label %s: %v\n", key, err)
-			lastErr = err
-			continue
+			return fmt.Errorf("label mapping migration failed: %v", err)
 		}
 
-		if len(val) != 24 {
-			fmt.Printf("Invalid ObjectID hex length: expected 24, got %d for '%s'\n", len(val), val)
-			lastErr = fmt.Errorf("invalid ObjectID hex length: expected 24, got %d for '%s'", len(val), val)
+		objectID, err := primitive.ObjectIDFromHex(docIDHex)
+		if err != nil {
 			continue
 		}
 
-		objectID, err := primitive.ObjectIDFromHex(val)
+		found, err := kv.ExistsBinary(collection.TableUri, objectID[:])
 		if err != nil {
-			fmt.Printf("Failed to parse docID '%s' as ObjectID hex: %v\n", val, err)
-			lastErr = err
-			continue
+			return fmt.Errorf("label mapping migration failed: %v", err)
 		}
-
-		// Validate the ObjectID is not empty/zero
-		if objectID.IsZero() {
-			fmt.Printf("ObjectID is zero/empty for hex '%s'\n", val)
-			lastErr = fmt.Errorf("ObjectID is zero/empty for hex '%s'", val)
-			continue
+		if found {
+			owned = append(owned, mapping{label, docIDHex})
 		}
+	}
 
-		docIDBytes := objectID[:] // Convert ObjectID to raw [12]byte slice
-		if len(docIDBytes) != 12 {
-			fmt.Printf("Invalid docIDBytes length: expected 12, got %d\n", len(docIDBytes))
-			lastErr = fmt.Errorf("invalid docIDBytes length: expected 12, got %d", len(docIDBytes))
-			continue
-		}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("label mapping migration failed: %v", err)
+	}
 
-		docBin, exists, err := kv.GetBinary(collection.TableUri, docIDBytes)
-		if err != nil {
-			fmt.Printf("Failed to get document for docID %s in table %s: %v\n", val, collection.TableUri, err)
-			lastErr = err
-			continue
+	collection.LabelToDocUri = labelToDocUri
+	collection.DocToLabelUri = docToLabelUri
+	collection.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	entry, err := bson.Marshal(collection)
+	if err != nil {
+		return fmt.Errorf("label mapping migration failed to encode catalog entry: %v", err)
+	}
+
+	return s.withTransaction(func(sess wt.Session) error {
+		for _, m := range owned {
+			if err := sess.PutString(labelToDocUri, m.label, m.docIDHex); err != nil {
+				return fmt.Errorf("label mapping migration failed: %v", err)
+			}
+			if err := sess.PutString(docToLabelUri, m.docIDHex, m.label); err != nil {
+				return fmt.Errorf("label mapping migration failed: %v", err)
+			}
 		}
 
-		if !exists {
-			return nil, fmt.Errorf("failed to get document with id %v", val)
+		if err := sess.PutBinaryWithStringKey(CATALOG, collectionDefKey, entry); err != nil {
+			return fmt.Errorf("label mapping migration failed to update catalog: %v", err)
 		}
 
-		if len(docBin) > 0 {
-			var doc GlowstickDocument
+		return nil
+	})
+}
 
-			if err := bson.Unmarshal(docBin, &doc); err != nil {
-				fmt.Printf("Failed to unmarshal BSON for docID %s: %v\n", val, err)
-				lastErr = err
-				continue
-			}
+// withTransaction runs fn inside a snapshot-isolated WiredTiger transaction on a
+// dedicated session. The transaction commits if fn returns nil and is rolled
+// back otherwise, so every write made through sess lands together or not at all.
+func (s *GDBService) withTransaction(fn func(sess wt.Session) error) error {
+	sess, err := s.KvService.OpenSession()
+	if err != nil {
+		return fmt.Errorf("failed to open session: %w", err)
+	}
+	defer sess.Close()
 
-			fmt.Printf("DocID: %s, Distance: %f\n", val, distance)
+	if err := sess.Begin(wt.IsolationSnapshot); err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
 
-			if query.MaxDistance == 0 || distance < query.MaxDistance {
-				docs = append(docs, doc)
-			} else {
-				fmt.Printf("DocID: %s, skipped\n", val)
-			}
+	if err := fn(sess); err != nil {
+		if rbErr := sess.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
 		}
+		return err
 	}
 
-	return docs, lastErr
+	if err := sess.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
 }
 
 func InitTablesHelper(wtService wt.WTService) error {
@@ -430,6 +803,10 @@ func InitTablesHelper(wtService wt.WTService) error {
 		return fmt.Errorf("failed to create table: %v", err)
 	}
 
+	if err := wtService.CreateTable(VECTOR_WAL, "key_format=u,value_format=u"); err != nil {
+		return fmt.Errorf("failed to create table: %v", err)
+	}
+
 	return nil
 }
 
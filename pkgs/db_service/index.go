@@ -0,0 +1,477 @@
+package dbservice
+
+import (
+	"encoding/binary"
+	"fmt"
+	wt "glowstickdb/pkgs/wiredtiger"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Secondary indexes map a metadata field to the documents holding each value.
+//
+// Each index is a key_format=u WiredTiger table whose keys are
+// <encoded value><12-byte document _id> and whose values are the _id again.
+// Values are encoded so that byte order matches the order used by filters:
+// a type tag first (so ranges never cross types), then an order-preserving
+// body. Array fields are multikey: one entry per element.
+const (
+	indexTagBool   byte = 0x08
+	indexTagNumber byte = 0x10
+	indexTagString byte = 0x20
+	indexTagDate   byte = 0x30
+)
+
+// exactSearchMaxCandidates is the largest index-selected candidate set that
+// QueryCollection scores directly instead of searching the vector index.
+const exactSearchMaxCandidates = 2048
+
+// indexField returns the metadata path a single-field index covers.
+func indexField(index CollectionIndex) string {
+	for field := range index.Key {
+		return field
+	}
+	return ""
+}
+
+// encodeIndexValue encodes a scalar metadata value into its index key prefix.
+// ok is false for values that cannot be indexed (documents, nil, binary, ...).
+func encodeIndexValue(v interface{}) ([]byte, bool) {
+	if f, ok := toFloat64(v); ok {
+		bits := math.Float64bits(f)
+		if f < 0 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		out := make([]byte, 9)
+		out[0] = indexTagNumber
+		binary.BigEndian.PutUint64(out[1:], bits)
+		return out, true
+	}
+
+	switch t := v.(type) {
+	case string:
+		out := make([]byte, 0, len(t)+2)
+		out = append(out, indexTagString)
+		out = append(out, t...)
+		return append(out, 0x00), true
+	case bool:
+		if t {
+			return []byte{indexTagBool, 1}, true
+		}
+		return []byte{indexTagBool, 0}, true
+	}
+
+	if ms, ok := toMillis(v); ok {
+		out := make([]byte, 9)
+		out[0] = indexTagDate
+		binary.BigEndian.PutUint64(out[1:], uint64(ms)^(1<<63))
+		return out, true
+	}
+
+	return nil, false
+}
+
+// prefixEnd returns the smallest key greater than every key starting with prefix.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil // prefix is all 0xff: no upper bound
+}
+
+// indexKeys returns the index table keys for a document.
+func indexKeys(index CollectionIndex, doc GlowstickDocument) [][]byte {
+	value, found := lookupPath(doc.Metadata, strings.Split(indexField(index), "."))
+	if !found {
+		return nil
+	}
+
+	values := []interface{}{value}
+	if items, ok := asArray(value); ok {
+		values = items
+	}
+
+	seen := map[string]bool{}
+	var keys [][]byte
+	for _, v := range values {
+		enc, ok := encodeIndexValue(v)
+		if !ok || seen[string(enc)] {
+			continue
+		}
+		seen[string(enc)] = true
+		keys = append(keys, append(enc, doc.ID[:]...))
+	}
+	return keys
+}
+
+// indexDocument adds a document to every secondary index of its collection
+// and to its text index.
+func indexDocument(sess wt.Session, collection CollectionCatalogEntry, doc GlowstickDocument) error {
+	for _, index := range collection.Indexes {
+		tableUri := collection.IndexTableUriMap[index.Name]
+		for _, key := range indexKeys(index, doc) {
+			if err := sess.PutBinary(tableUri, key, doc.ID[:]); err != nil {
+				return fmt.Errorf("failed to update index %s: %w", index.Name, err)
+			}
+		}
+	}
+	if err := indexDocumentKey(sess, collection, doc); err != nil {
+		return err
+	}
+	return indexText(sess, collection, doc)
+}
+
+// unindexDocument removes a document from every secondary index of its
+// collection and from its text index.
+func unindexDocument(sess wt.Session, collection CollectionCatalogEntry, doc GlowstickDocument) error {
+	for _, index := range collection.Indexes {
+		tableUri := collection.IndexTableUriMap[index.Name]
+		for _, key := range indexKeys(index, doc) {
+			if err := sess.DeleteBinary(tableUri, key); err != nil {
+				return fmt.Errorf("failed to update index %s: %w", index.Name, err)
+			}
+		}
+	}
+	if err := unindexDocumentKey(sess, collection, doc); err != nil {
+		return err
+	}
+	return unindexText(sess, collection, doc)
+}
+
+// CreateIndex builds a secondary index over a metadata path of a collection,
+// backfilling it from the documents already stored. index.Key must name exactly
+// one field, as a dot-separated path into GlowstickDocument.Metadata.
+func (s *GDBService) CreateIndex(collection_name string, index CollectionIndex) error {
+	kv := s.KvService
+
+	if len(index.Key) != 1 {
+		return fmt.Errorf("[GDBSERVICE:CreateIndex] index must cover exactly one field, got %d", len(index.Key))
+	}
+	if index.Type == "" {
+		index.Type = "single"
+	}
+	if index.Type != "single" {
+		return fmt.Errorf("[GDBSERVICE:CreateIndex] unsupported index type %q", index.Type)
+	}
+
+	field := indexField(index)
+	if index.Name == "" {
+		index.Name = fmt.Sprintf("%s_%d", field, index.Key[field])
+	}
+
+	defer s.lockCollection(collection_name)()
+
+	collectionDefKey := fmt.Sprintf("%s.%s", s.Name, collection_name)
+	val, exists, err := kv.GetBinary(CATALOG, []byte(collectionDefKey))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("[GDBSERVICE:CreateIndex] %w: %s", ErrCollectionNotFound, collectionDefKey)
+	}
+
+	var collection CollectionCatalogEntry
+	if err := bson.Unmarshal(val, &collection); err != nil {
+		return fmt.Errorf("[GDBSERVICE:CreateIndex] failed to decode catalog entry: %v", err)
+	}
+
+	if _, taken := collection.IndexTableUriMap[index.Name]; taken {
+		return fmt.Errorf("[GDBSERVICE:CreateIndex] index %s already exists on %s", index.Name, collection_name)
+	}
+
+	index.Id = primitive.NewObjectID().Hex()
+	index.Ns = collectionDefKey
+	index.V = 1
+
+	tableUri := fmt.Sprintf("table:index-%s-%s-%s", collection.Id.Hex(), index.Id, s.Name)
+	if err := kv.CreateTable(tableUri, "key_format=u,value_format=u"); err != nil {
+		return fmt.Errorf("[GDBSERVICE:CreateIndex] failed to create table %s: %v", tableUri, err)
+	}
+
+	if collection.IndexTableUriMap == nil {
+		collection.IndexTableUriMap = map[string]string{}
+	}
+	collection.IndexTableUriMap[index.Name] = tableUri
+	collection.Indexes = append(collection.Indexes, index)
+	collection.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	entry, err := bson.Marshal(collection)
+	if err != nil {
+		return fmt.Errorf("[GDBSERVICE:CreateIndex] failed to encode catalog entry: %v", err)
+	}
+
+	// Backfill and publish the index in one transaction so queries never see a
+	// half-built index in the catalog.
+	backfill := CollectionCatalogEntry{IndexTableUriMap: collection.IndexTableUriMap, Indexes: []CollectionIndex{index}}
+
+	return s.withTransaction(func(sess wt.Session) error {
+		cursor, err := kv.ScanRangeBinary(collection.TableUri, nil, nil)
+		if err != nil {
+			return fmt.Errorf("failed to scan collection %s: %w", collection_name, err)
+		}
+		defer cursor.Close()
+
+		for cursor.Next() {
+			key, docBin, err := cursor.Current()
+			if err != nil {
+				return err
+			}
+
+			var doc GlowstickDocument
+			if err := bson.Unmarshal(docBin, &doc); err != nil {
+				return fmt.Errorf("failed to decode document during index build: %w", err)
+			}
+			copy(doc.ID[:], key) // the row key is the authoritative _id
+
+			if err := indexDocument(sess, backfill, doc); err != nil {
+				return err
+			}
+		}
+
+		if err := cursor.Err(); err != nil {
+			return fmt.Errorf("collection scan failed during index build: %w", err)
+		}
+
+		if err := sess.PutBinaryWithStringKey(CATALOG, collectionDefKey, entry); err != nil {
+			return fmt.Errorf("failed to update catalog entry: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// keyRange is a [start, end) range of index keys; a nil end is unbounded.
+type keyRange struct{ start, end []byte }
+
+// indexRanges translates a field predicate into index key ranges. ok is false
+// when the predicate cannot be answered from an index.
+func indexRanges(f fieldFilter) ([]keyRange, bool) {
+	if f.op == "$in" {
+		var ranges []keyRange
+		for _, candidate := range f.operand.([]interface{}) {
+			enc, ok := encodeIndexValue(candidate)
+			if !ok {
+				return nil, false
+			}
+			ranges = append(ranges, keyRange{enc, prefixEnd(enc)})
+		}
+		return ranges, true
+	}
+
+	enc, ok := encodeIndexValue(f.operand)
+	if !ok {
+		return nil, false
+	}
+	typeStart := enc[:1]
+	typeEnd := prefixEnd(typeStart)
+
+	switch f.op {
+	case "$eq":
+		return []keyRange{{enc, prefixEnd(enc)}}, true
+	case "$gt":
+		return []keyRange{{prefixEnd(enc), typeEnd}}, true
+	case "$gte":
+		return []keyRange{{enc, typeEnd}}, true
+	case "$lt":
+		return []keyRange{{typeStart, enc}}, true
+	case "$lte":
+		return []keyRange{{typeStart, prefixEnd(enc)}}, true
+	}
+	return nil, false
+}
+
+// indexCandidates uses the collection's secondary indexes to compute the set of
+// documents that can possibly satisfy filter. Only top-level predicates (and
+// the direct children of a top-level $and) on indexed fields are used; the
+// caller must still apply the full filter to each candidate. ok is false when
+// no predicate could be answered from an index.
+func (s *GDBService) indexCandidates(collection CollectionCatalogEntry, filter Filter) (map[primitive.ObjectID]struct{}, bool, error) {
+	var clauses []Filter
+	switch f := filter.(type) {
+	case andFilter:
+		clauses = f
+	default:
+		clauses = []Filter{f}
+	}
+
+	indexByField := map[string]CollectionIndex{}
+	for _, index := range collection.Indexes {
+		indexByField[indexField(index)] = index
+	}
+
+	var candidates map[primitive.ObjectID]struct{}
+	used := false
+
+	for _, clause := range clauses {
+		field, ok := clause.(fieldFilter)
+		if !ok {
+			continue
+		}
+		index, indexed := indexByField[strings.Join(field.path, ".")]
+		if !indexed {
+			continue
+		}
+		ranges, ok := indexRanges(field)
+		if !ok {
+			continue
+		}
+
+		matched := map[primitive.ObjectID]struct{}{}
+		for _, r := range ranges {
+			if err := s.scanIndexRange(collection.IndexTableUriMap[index.Name], r, matched); err != nil {
+				return nil, false, err
+			}
+		}
+
+		if !used {
+			candidates = matched
+			used = true
+			continue
+		}
+		for id := range candidates {
+			if _, keep := matched[id]; !keep {
+				delete(candidates, id)
+			}
+		}
+	}
+
+	return candidates, used, nil
+}
+
+func (s *GDBService) scanIndexRange(tableUri string, r keyRange, into map[primitive.ObjectID]struct{}) error {
+	cursor, err := s.KvService.ScanRangeBinary(tableUri, r.start, r.end)
+	if err != nil {
+		return fmt.Errorf("failed to scan index %s: %w", tableUri, err)
+	}
+	defer cursor.Close()
+
+	for cursor.Next() {
+		_, val, err := cursor.Current()
+		if err != nil {
+			return err
+		}
+		var id primitive.ObjectID
+		copy(id[:], val)
+		into[id] = struct{}{}
+	}
+	return cursor.Err()
+}
+
+// exactSearch scores a candidate set directly against the query embedding
+// instead of searching the vector index, returning up to TopK documents that
+// pass the filter, nearest first. filter may be nil.
+func (s *GDBService) exactSearch(collection CollectionCatalogEntry, candidates map[primitive.ObjectID]struct{}, filter Filter, query QueryStruct) ([]QueryResult, error) {
+	if query.TopK <= 0 {
+		return nil, fmt.Errorf("%w: TopK must be positive, got %d", ErrInvalidQuery, query.TopK)
+	}
+
+	var hits []QueryResult
+	metric := collection.VectorIndex.withDefaults().Metric
+
+	for id := range candidates {
+		docBin, exists, err := s.KvService.GetBinary(collection.TableUri, id[:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to get document %s: %w", id.Hex(), err)
+		}
+		if !exists {
+			continue
+		}
+
+		var doc GlowstickDocument
+		if err := bson.Unmarshal(docBin, &doc); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal BSON for docID %s: %w", id.Hex(), err)
+		}
+		doc.ID = id
+
+		if filter != nil && !filter.Match(doc.Metadata) || len(doc.Embedding) != len(query.QueryEmbedding) {
+			continue
+		}
+
+		distance := vectorDistance(metric, doc.Embedding, query.QueryEmbedding)
+		if query.MaxDistance != 0 && distance >= query.MaxDistance {
+			continue
+		}
+		score := distanceScore(metric, distance)
+		hits = append(hits, QueryResult{Document: doc, Distance: distance, Score: score, VectorScore: score})
+	}
+
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Distance < hits[j].Distance })
+
+	if len(hits) > int(query.TopK) {
+		hits = hits[:query.TopK]
+	}
+
+	results := make([]QueryResult, len(hits))
+	for i, hit := range hits {
+		label, err := s.documentLabel(collection, hit.Document.ID)
+		if err != nil {
+			return nil, err
+		}
+		hit.Label = label
+		results[i] = hit
+	}
+	return results, nil
+}
+
+// documentLabel returns the FAISS label mapped to a document, or -1 if it has none.
+func (s *GDBService) documentLabel(collection CollectionCatalogEntry, id primitive.ObjectID) (int64, error) {
+	val, found, err := s.KvService.GetString(collection.DocToLabelUri, id.Hex())
+	if err != nil {
+		return -1, fmt.Errorf("failed to get label for docID %s: %w", id.Hex(), err)
+	}
+	if !found {
+		return -1, nil
+	}
+	label, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return -1, fmt.Errorf("malformed label %q for docID %s: %w", val, id.Hex(), err)
+	}
+	return label, nil
+}
+
+// collectionIDs returns the _id of every document in a collection.
+func (s *GDBService) collectionIDs(collection CollectionCatalogEntry) (map[primitive.ObjectID]struct{}, error) {
+	cursor, err := s.KvService.ScanRangeBinary(collection.TableUri, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan collection %s: %w", collection.Ns, err)
+	}
+	defer cursor.Close()
+
+	ids := map[primitive.ObjectID]struct{}{}
+	for cursor.Next() {
+		key, _, err := cursor.Current()
+		if err != nil {
+			return nil, err
+		}
+		var id primitive.ObjectID
+		copy(id[:], key)
+		ids[id] = struct{}{}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("collection scan failed: %w", err)
+	}
+	return ids, nil
+}
+
+// l2DistanceSqr matches the squared L2 distance reported by FAISS L2 indexes.
+func l2DistanceSqr(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return sum
+}
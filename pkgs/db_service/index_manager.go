@@ -0,0 +1,536 @@
+package dbservice
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"glowstickdb/pkgs/faiss"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// FlushPolicy decides when vectors added to a cached index are written to its
+// index file. Until then they are held in the vector WAL, so no policy loses
+// writes on a crash; later flushes only mean more WAL to replay on restart.
+type FlushPolicy int
+
+const (
+	// FlushOnWrite rewrites the index file after every committed batch.
+	FlushOnWrite FlushPolicy = iota
+	// FlushPeriodically writes changed indexes every FlushInterval, and when
+	// they are evicted or the manager is closed.
+	FlushPeriodically
+	// FlushOnEvict writes changed indexes only when they are evicted, Flush is
+	// called or the manager is closed.
+	FlushOnEvict
+)
+
+const defaultFlushInterval = time.Second
+
+// IndexManagerOptions configures an IndexManager.
+type IndexManagerOptions struct {
+	// MemoryBudget is how many bytes of indexes to keep loaded, estimated from
+	// their serialized size. Least recently used indexes that are not in use
+	// are evicted to stay under it; 0 keeps every index loaded.
+	MemoryBudget  int64
+	FlushPolicy   FlushPolicy
+	FlushInterval time.Duration // for FlushPeriodically; defaults to one second
+	Logger        *slog.Logger  // diagnostics sink; nil discards them
+}
+
+// IndexManagerStats is a snapshot of an IndexManager's cache.
+type IndexManagerStats struct {
+	Loaded    int   // indexes held in memory
+	Bytes     int64 // their estimated size
+	Dirty     int   // indexes with vectors not yet written to their file
+	Hits      int64
+	Misses    int64
+	Evictions int64
+}
+
+// IndexManager keeps collections' FAISS indexes loaded between calls instead
+// of reading them from disk for every insert and query. One manager serves one
+// WiredTiger connection and is shared by every DBService opened on it.
+//
+// Indexes are reference counted: each call holds its index until it returns,
+// and only indexes nobody holds are evicted or freed. Writers hold an index
+// exclusively, queries share it.
+type IndexManager struct {
+	opts IndexManagerOptions
+
+	mu      sync.Mutex
+	entries map[string]*indexEntry
+	lru     *list.List // front is most recently used
+	used    int64
+	closed  bool
+	stats   IndexManagerStats
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type indexEntry struct {
+	key   string
+	elem  *list.Element
+	ready chan struct{} // closed once the index is loaded or failed to load
+	err   error
+
+	// Guards idx: writers lock it, readers and flushes share it.
+	lock sync.RWMutex
+
+	idx      *faiss.Index
+	filePath string
+	pending  int64
+
+	// The rest is guarded by IndexManager.mu.
+	refs     int
+	size     int64
+	removed  bool
+	flushing chan struct{} // closed once the flush under way is done; nil if none
+
+	// Vectors added since the last flush, and the service that can clear
+	// their WAL entries once they are on disk.
+	dirty            bool
+	labels           []int64
+	svc              *GDBService
+	collectionDefKey string
+}
+
+// vectorIndexHandle is a loaded index held by one call. release must be
+// called exactly once when the call is done with it.
+type vectorIndexHandle struct {
+	idx      *faiss.Index
+	filePath string
+	pending  int64 // vectors buffered in the WAL until the index can be trained
+
+	m     *IndexManager
+	e     *indexEntry // nil for an index loaded outside any manager
+	write bool
+}
+
+// NewIndexManager returns an empty IndexManager. Close it to write out and
+// free the indexes it holds.
+func NewIndexManager(opts IndexManagerOptions) *IndexManager {
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultFlushInterval
+	}
+	m := &IndexManager{
+		opts:    opts,
+		entries: make(map[string]*indexEntry),
+		lru:     list.New(),
+	}
+	if opts.FlushPolicy == FlushPeriodically {
+		m.stop = make(chan struct{})
+		m.done = make(chan struct{})
+		go m.flushPeriodically()
+	}
+	return m
+}
+
+func (m *IndexManager) logger() *slog.Logger {
+	if m.opts.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return m.opts.Logger
+}
+
+func (m *IndexManager) flushPeriodically() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			if err := m.Flush(); err != nil {
+				m.logger().Warn("failed to flush vector indexes", "error", err)
+			}
+		}
+	}
+}
+
+// indexCacheKey identifies a collection's index. The collection ID tells a
+// dropped collection apart from a new one created under the same name.
+func indexCacheKey(collection CollectionCatalogEntry, collectionDefKey string) string {
+	return collectionDefKey + "/" + collection.Id.Hex()
+}
+
+// acquireVectorIndex returns the collection's index, loading it through
+// openVectorIndex if the service has no manager or the manager does not hold
+// it yet. write asks for exclusive use, to add vectors.
+func (s *GDBService) acquireVectorIndex(collection CollectionCatalogEntry, collectionDefKey string, write bool) (*vectorIndexHandle, error) {
+	m := s.Indexes
+	if m == nil {
+		return s.openUncachedIndex(collection, collectionDefKey)
+	}
+
+	key := indexCacheKey(collection, collectionDefKey)
+	for {
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			return s.openUncachedIndex(collection, collectionDefKey)
+		}
+		e, cached := m.entries[key]
+		if cached {
+			m.stats.Hits++
+			m.lru.MoveToFront(e.elem)
+		} else {
+			m.stats.Misses++
+			e = &indexEntry{key: key, ready: make(chan struct{}), collectionDefKey: collectionDefKey}
+			e.elem = m.lru.PushFront(e)
+			m.entries[key] = e
+		}
+		e.refs++
+		m.mu.Unlock()
+
+		if cached {
+			<-e.ready
+		} else {
+			e.idx, e.filePath, e.pending, e.err = s.openVectorIndex(collection, collectionDefKey)
+			m.mu.Lock()
+			if e.err != nil {
+				m.remove(e)
+			} else if e.size = indexFileSize(e.filePath); !e.removed {
+				m.used += e.size
+			}
+			m.mu.Unlock()
+			close(e.ready)
+		}
+
+		if e.err != nil {
+			m.unref(e)
+			return nil, e.err
+		}
+
+		if write {
+			e.lock.Lock()
+		} else {
+			e.lock.RLock()
+		}
+		h := &vectorIndexHandle{idx: e.idx, filePath: e.filePath, pending: e.pending, m: m, e: e, write: write}
+
+		// A writer may have discarded the index while this call waited for it.
+		m.mu.Lock()
+		removed := e.removed
+		m.mu.Unlock()
+		if !removed {
+			return h, nil
+		}
+		h.release()
+	}
+}
+
+func (s *GDBService) openUncachedIndex(collection CollectionCatalogEntry, collectionDefKey string) (*vectorIndexHandle, error) {
+	idx, filePath, pending, err := s.openVectorIndex(collection, collectionDefKey)
+	if err != nil {
+		return nil, err
+	}
+	return &vectorIndexHandle{idx: idx, filePath: filePath, pending: pending}, nil
+}
+
+// release gives the index back. An index loaded outside any manager is freed.
+func (h *vectorIndexHandle) release() {
+	if h.e == nil {
+		h.idx.Free()
+		return
+	}
+	if h.write {
+		h.e.lock.Unlock()
+	} else {
+		h.e.lock.RUnlock()
+	}
+	h.m.unref(h.e)
+}
+
+// deferFlush reports whether vectors added through h are left for the manager
+// to write out instead of being written by the caller right away.
+func (h *vectorIndexHandle) deferFlush() bool {
+	return h.e != nil && h.m.opts.FlushPolicy != FlushOnWrite
+}
+
+// setPending records how many vectors the WAL still buffers for training.
+func (h *vectorIndexHandle) setPending(pending int64) {
+	h.pending = pending
+	if h.e != nil {
+		h.e.pending = pending
+	}
+}
+
+// added records a committed batch of vectors that reached the index under
+// labels. Unless they were already written to the index file, s clears their
+// WAL entries once the manager has written them.
+func (h *vectorIndexHandle) added(s *GDBService, labels []int64, bytes int64, flushed bool) {
+	if h.e == nil {
+		return
+	}
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+	h.e.size += bytes
+	if !h.e.removed {
+		h.m.used += bytes
+	}
+	if !flushed {
+		h.e.dirty = true
+		h.e.labels = append(h.e.labels, labels...)
+		h.e.svc = s
+	}
+}
+
+// discard drops the index from the manager because it no longer matches its
+// file and WAL, e.g. after vectors were added for a batch that rolled back.
+// The next call loads it afresh.
+func (h *vectorIndexHandle) discard() {
+	if h.e == nil {
+		return
+	}
+	h.m.mu.Lock()
+	h.m.remove(h.e)
+	h.m.mu.Unlock()
+}
+
+// dropVectorIndex forgets a dropped collection's index without writing it. A
+// flush already under way is waited for, so that the index file can be
+// removed once it returns.
+func (s *GDBService) dropVectorIndex(collection CollectionCatalogEntry, collectionDefKey string) {
+	m := s.Indexes
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	var flushing chan struct{}
+	if e, ok := m.entries[indexCacheKey(collection, collectionDefKey)]; ok {
+		m.remove(e)
+		if e.refs == 0 {
+			m.free(e)
+		}
+		flushing = e.flushing
+	}
+	m.mu.Unlock()
+	if flushing != nil {
+		<-flushing
+	}
+}
+
+// unref releases one reference to e, freeing it if it was removed and evicting
+// indexes if the manager is over budget.
+func (m *IndexManager) unref(e *indexEntry) {
+	m.mu.Lock()
+	e.refs--
+	if e.removed && e.refs == 0 {
+		m.free(e)
+	}
+	dirty := m.evict()
+	m.mu.Unlock()
+	m.evictDirty(dirty)
+}
+
+// remove takes e out of the cache; it is freed once nobody holds it.
+// m.mu must be held.
+func (m *IndexManager) remove(e *indexEntry) {
+	if e.removed {
+		return
+	}
+	e.removed = true
+	delete(m.entries, e.key)
+	m.lru.Remove(e.elem)
+	m.used -= e.size
+}
+
+// free releases e's index. m.mu must be held and e must not be in use.
+func (m *IndexManager) free(e *indexEntry) {
+	if e.idx != nil {
+		e.idx.Free()
+		e.idx = nil
+	}
+}
+
+// evict frees least recently used indexes that are not in use until the
+// manager is back under its budget. Indexes with unwritten vectors are only
+// pinned and returned, for the caller to pass to evictDirty once it has
+// unlocked m.mu. m.mu must be held.
+func (m *IndexManager) evict() []*indexEntry {
+	if m.opts.MemoryBudget <= 0 {
+		return nil
+	}
+	var dirty []*indexEntry
+	over := m.used - m.opts.MemoryBudget
+	for elem := m.lru.Back(); elem != nil && over > 0; {
+		e := elem.Value.(*indexEntry)
+		elem = elem.Prev()
+		if e.refs > 0 {
+			continue
+		}
+		over -= e.size
+		if e.dirty {
+			e.refs++
+			dirty = append(dirty, e)
+			continue
+		}
+		m.remove(e)
+		m.free(e)
+		m.stats.Evictions++
+	}
+	return dirty
+}
+
+// evictDirty writes out the indexes evict pinned and evicts those that are
+// still unused and over budget. A failed write leaves the vectors in the WAL,
+// to be replayed on the next load, so the index can be freed either way. m.mu
+// must not be held.
+func (m *IndexManager) evictDirty(entries []*indexEntry) {
+	for _, e := range entries {
+		e.lock.RLock()
+		err := m.flushEntry(e)
+		e.lock.RUnlock()
+		if err != nil {
+			m.logger().Warn("failed to flush evicted vector index", "collection", e.collectionDefKey, "error", err)
+		}
+
+		m.mu.Lock()
+		e.refs--
+		switch {
+		case e.removed:
+			if e.refs == 0 {
+				m.free(e)
+			}
+		case e.refs == 0 && (err != nil || !e.dirty) && m.used > m.opts.MemoryBudget:
+			m.remove(e)
+			m.free(e)
+			m.stats.Evictions++
+		}
+		m.mu.Unlock()
+	}
+}
+
+// flushEntry writes e's index to its file and clears the WAL entries of the
+// vectors that are now on disk. The caller holds a reference to e and shares
+// e.lock, so no writer changes the index meanwhile. The write and the WAL
+// transaction happen without m.mu, which must not be held; a flush of e
+// already under way is waited for instead.
+func (m *IndexManager) flushEntry(e *indexEntry) error {
+	m.mu.Lock()
+	if flushing := e.flushing; flushing != nil {
+		m.mu.Unlock()
+		<-flushing
+		return nil
+	}
+	// A removed index was dropped with its collection or no longer matches
+	// its WAL, so it is never written.
+	if !e.dirty || e.removed {
+		m.mu.Unlock()
+		return nil
+	}
+	labels, svc := e.labels, e.svc
+	e.dirty, e.labels = false, nil
+	flushing := make(chan struct{})
+	e.flushing = flushing
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		e.flushing = nil
+		m.mu.Unlock()
+		close(flushing)
+	}()
+
+	if err := e.idx.WriteToFile(e.filePath); err != nil {
+		m.mu.Lock()
+		e.dirty, e.labels = true, append(labels, e.labels...)
+		m.mu.Unlock()
+		return fmt.Errorf("failed to write vector index %s: %w", e.filePath, err)
+	}
+
+	size := indexFileSize(e.filePath)
+	m.mu.Lock()
+	if !e.removed {
+		m.used += size - e.size
+	}
+	e.size = size
+	m.mu.Unlock()
+
+	if err := svc.checkpointVectorWal(e.collectionDefKey, labels); err != nil {
+		// The rows are stale now and are dropped on the next replay.
+		m.logger().Warn("failed to checkpoint vector WAL", "collection", e.collectionDefKey, "error", err)
+	}
+	return nil
+}
+
+// Flush writes every index with unwritten vectors to its file. Indexes being
+// written to are flushed once their writer is done.
+func (m *IndexManager) Flush() error {
+	m.mu.Lock()
+	var dirty []*indexEntry
+	for _, e := range m.entries {
+		if e.dirty {
+			e.refs++
+			dirty = append(dirty, e)
+		}
+	}
+	m.mu.Unlock()
+
+	var errs []error
+	for _, e := range dirty {
+		e.lock.RLock()
+		if err := m.flushEntry(e); err != nil {
+			errs = append(errs, err)
+		}
+		e.lock.RUnlock()
+		m.unref(e)
+	}
+	return errors.Join(errs...)
+}
+
+// Stats returns a snapshot of the manager's cache.
+func (m *IndexManager) Stats() IndexManagerStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.stats
+	stats.Loaded = len(m.entries)
+	stats.Bytes = m.used
+	for _, e := range m.entries {
+		if e.dirty {
+			stats.Dirty++
+		}
+	}
+	return stats
+}
+
+// Close flushes and frees every index. Indexes still in use are freed when
+// they are released; calls made after Close load indexes without caching them.
+func (m *IndexManager) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	m.mu.Unlock()
+
+	if m.stop != nil {
+		close(m.stop)
+		<-m.done
+	}
+	err := m.Flush()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.entries {
+		m.remove(e)
+		if e.refs == 0 {
+			m.free(e)
+		}
+	}
+	return err
+}
+
+func indexFileSize(filePath string) int64 {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
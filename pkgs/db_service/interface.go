@@ -2,6 +2,8 @@ package dbservice
 
 import (
 	wt "glowstickdb/pkgs/wiredtiger"
+	"io"
+	"log/slog"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -9,37 +11,136 @@ import (
 // TABLE URIS for creating wiredtiger tables
 var CATALOG = "table:_catalog"
 var STATS = "table:_stats"
+
+// Legacy global label->docID mapping shared by every collection. Collections now
+// own their mapping tables (see CollectionCatalogEntry.LabelToDocUri); this table
+// is only read when migrating collections created before that change.
 var LABELS_TO_DOC_ID_MAPPING_TABLE_URI = "table:label_docID"
+var VECTOR_WAL = "table:_vector_wal"
 
+// GlowstickDocument is a stored document. A document written with Content
+// but no Embedding to a collection with an embedder is embedded on write.
 type GlowstickDocument struct {
-	_Id       primitive.ObjectID `bson:"_id"`
-	Content   string             `bson:"content"`
-	Embedding []float32          `bson:"embedding"`
-	Metadata  interface{}        `bson:"metadata"` // Any BSON- and JSON-serializable type
+	// ID identifies the document within its collection. Documents inserted or
+	// upserted with a zero ID are assigned a new ObjectID, which is written
+	// back into the caller's slice.
+	ID primitive.ObjectID `bson:"_id"`
+	// Key is an optional caller-chosen string ID, unique within the
+	// collection. ResolveKey maps it to the document's ID, and upserts with a
+	// zero ID update the document holding their Key.
+	Key       string      `bson:"key,omitempty"`
+	Content   string      `bson:"content"`
+	Embedding []float32   `bson:"embedding"`
+	Metadata  interface{} `bson:"metadata"` // Any BSON- and JSON-serializable type
 }
 
 type QueryStruct struct {
-	TopK           int32
+	TopK int32
+	// MaxDistance drops results at or beyond this distance; 0 disables it.
+	// Distances grow as results get less similar: squared L2 for l2
+	// collections, 1 - cosine similarity for cosine, and the negated inner
+	// product for ip (so -0.5 keeps inner products above 0.5).
 	MaxDistance    float32
 	QueryEmbedding []float32
 	Filters        map[string]interface{}
+
+	// QueryContent is embedded with the collection's embedder when
+	// QueryEmbedding is empty.
+	QueryContent string
+
+	// QueryText makes the query hybrid: documents are also ranked by BM25 over
+	// Content and both rankings are fused into one. QueryEmbedding may then be
+	// empty for a keyword-only ranking.
+	QueryText    string
+	Fusion       string  // FusionRRF (default) or FusionWeighted
+	VectorWeight float32 // share of the vector score under FusionWeighted, in (0, 1]; 0 means 0.5
+	RRFConstant  int     // k in 1/(k + rank) under FusionRRF; 0 means 60
+}
+
+// BatchQueryStruct is a QueryStruct with several query embeddings, all of the
+// same dimension. TopK, MaxDistance and Filters apply to each of them.
+type BatchQueryStruct struct {
+	TopK            int32
+	MaxDistance     float32
+	QueryEmbeddings [][]float32
+	Filters         map[string]interface{}
+	QueryContents   []string // embedded with the collection's embedder when QueryEmbeddings is empty
+}
+
+// single returns the QueryStruct for the i-th query embedding.
+func (q BatchQueryStruct) single(i int) QueryStruct {
+	return QueryStruct{
+		TopK:           q.TopK,
+		MaxDistance:    q.MaxDistance,
+		QueryEmbedding: q.QueryEmbeddings[i],
+		Filters:        q.Filters,
+	}
+}
+
+// TextQueryStruct is a keyword query over the Content of a collection's documents.
+type TextQueryStruct struct {
+	Text      string // analyzed like Content; documents containing any of its terms match
+	TopK      int32
+	Filters   map[string]interface{}
+	Fuzziness int  // 0 (exact), 1 or 2: edits a term may be from the query term it matches
+	Prefix    bool // also match terms starting with a query term, within Fuzziness edits
+}
+
+// TextResult is one hit returned by SearchText, best first.
+type TextResult struct {
+	Document GlowstickDocument
+	Score    float32 // BM25 relevance; unbounded, higher is better
+}
+
+// QueryResult is one hit returned by QueryCollection, nearest first.
+type QueryResult struct {
+	Document GlowstickDocument
+	// Distance is the collection metric's distance to the query, as compared
+	// against QueryStruct.MaxDistance: smaller is closer.
+	Distance float32
+	// Score maps Distance onto [0, 1], higher is better, so results from
+	// collections with different metrics can be combined. Hybrid queries
+	// replace it with the fused score.
+	Score float32
+	// Label is the document's FAISS label, or -1 if it has none.
+	Label int64
+	// VectorScore and TextScore are the components of Score: the normalized
+	// vector score and the BM25 relevance of Content to QueryText. For plain
+	// vector queries Score is VectorScore and TextScore is 0.
+	VectorScore float32
+	TextScore   float32
 }
 
 type DBService interface {
 	CreateDB() error
-	DeleteDB(name string) error
-	CreateCollection(collection_name string) error
+	DeleteDB() error
+	CreateCollection(collection_name string, options ...CollectionOptions) error
+	DropCollection(collection_name string) error
+	ListCollections() ([]CollectionCatalogEntry, error)
 	InsertDocumentsIntoCollection(collection_name string, documents []GlowstickDocument) error
-	QueryCollection(collection_name string, query QueryStruct) ([]GlowstickDocument, error)
-	ListCollections() error
+	BulkInsert(collection_name string, documents DocumentSource, opts BulkInsertOptions) (int, error)
+	DumpCollection(collection_name string, w io.Writer, format string) (int, error)
+	RestoreCollection(collection_name string, r io.Reader, format string, opts BulkInsertOptions) (int, error)
+	QueryCollection(collection_name string, query QueryStruct) ([]QueryResult, error)
+	QueryCollectionBatch(collection_name string, query BatchQueryStruct) ([][]QueryResult, error)
+	SearchText(collection_name string, query TextQueryStruct) ([]TextResult, error)
+	CreateIndex(collection_name string, index CollectionIndex) error
+	GetDocument(collection_name string, id primitive.ObjectID) (GlowstickDocument, bool, error)
+	ResolveKey(collection_name string, key string) (primitive.ObjectID, bool, error)
+	UpdateDocument(collection_name string, id primitive.ObjectID, document GlowstickDocument) error
+	UpsertDocuments(collection_name string, documents []GlowstickDocument) error
+	DeleteDocuments(collection_name string, ids []primitive.ObjectID) (int, error)
+	GetCollectionStats(collection_name string) (CollectionStats, error)
 }
 
 type DbParams struct {
 	Name        string
 	PutIfAbsent bool
 	KvService   wt.WTService
+	Logger      *slog.Logger  // diagnostics sink; nil discards them
+	Indexes     *IndexManager // keeps vector indexes loaded between calls; nil reads them from disk every time
 }
 
 func DatabaseService(params DbParams) DBService {
-	return &GDBService{Name: params.Name, KvService: params.KvService}
+	return &GDBService{Name: params.Name, KvService: params.KvService, Logger: params.Logger, Indexes: params.Indexes}
 }
@@ -1,12 +1,24 @@
 package dbservice
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	bsonvalidator "glowstickdb/pkgs/bson-validator"
+	"glowstickdb/pkgs/embedder"
 	"glowstickdb/pkgs/faiss"
 	"glowstickdb/pkgs/wiredtiger"
+	"math"
 	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -201,19 +213,19 @@ func TestInsertDocuments(t *testing.T) {
 
 	documents := []GlowstickDocument{
 		{
-			_Id:       primitive.NewObjectID(),
+			ID:        primitive.NewObjectID(),
 			Content:   "First example document",
 			Embedding: genEmbeddings(1536),
 			Metadata:  map[string]interface{}{"type": "example", "index": 1},
 		},
 		{
-			_Id:       primitive.NewObjectID(),
+			ID:        primitive.NewObjectID(),
 			Content:   "Second example document",
 			Embedding: genEmbeddings(1536),
 			Metadata:  map[string]interface{}{"type": "example", "index": 2},
 		},
 		{
-			_Id:       primitive.NewObjectID(),
+			ID:        primitive.NewObjectID(),
 			Content:   "Third example document",
 			Embedding: genEmbeddings(1536),
 			Metadata:  map[string]interface{}{"type": "example", "index": 3},
@@ -245,20 +257,20 @@ func TestInsertDocuments(t *testing.T) {
 	}
 
 	for index, doc := range documents {
-		docKey := doc._Id[:]
+		docKey := doc.ID[:]
 		fmt.Printf("Index: %d, docKey: %x\n", index, docKey)
 
 		record, found, err := wtService.GetBinary(collTableURI, docKey)
 		if err != nil {
-			t.Errorf("failed to read doc _id=%s from table %s: %v", doc._Id.Hex(), collTableURI, err)
+			t.Errorf("failed to read doc _id=%s from table %s: %v", doc.ID.Hex(), collTableURI, err)
 		}
 		if !found {
-			t.Errorf("inserted doc _id=%s not found in collection physical table %s", doc._Id.Hex(), collTableURI)
+			t.Errorf("inserted doc _id=%s not found in collection physical table %s", doc.ID.Hex(), collTableURI)
 		}
 
 		var restoredDoc GlowstickDocument
 		if err := bson.Unmarshal(record, &restoredDoc); err != nil {
-			t.Errorf("unmarshal failed for _id=%s: %v", doc._Id.Hex(), err)
+			t.Errorf("unmarshal failed for _id=%s: %v", doc.ID.Hex(), err)
 		}
 		if doc.Content != restoredDoc.Content {
 			t.Errorf("Retrieved content does not match document saved. Retrieved:%s, Document:%s", restoredDoc.Content, doc.Content)
@@ -333,7 +345,7 @@ func TestBasicVectorQuery(t *testing.T) {
 	documents := make([]GlowstickDocument, 10)
 	for i := 0; i < 10; i++ {
 		documents[i] = GlowstickDocument{
-			_Id:       primitive.NewObjectID(),
+			ID:        primitive.NewObjectID(),
 			Content:   fmt.Sprintf("Example document number %d", i+1),
 			Embedding: genEmbeddings(1536),
 			Metadata:  map[string]interface{}{"type": "example", "index": i + 1},
@@ -383,9 +395,10 @@ func TestBasicVectorQuery(t *testing.T) {
 		t.Log("No docs returned")
 	}
 	t.Logf("Query returned %d documents:\n", len(docs))
-	for i, doc := range docs {
-		t.Logf("Document %d:\n", i+1)
-		t.Logf("  ID: %s\n", doc._Id.Hex())
+	for i, result := range docs {
+		doc := result.Document
+		t.Logf("Document %d (distance %f, score %f, label %d):\n", i+1, result.Distance, result.Score, result.Label)
+		t.Logf("  ID: %s\n", doc.ID.Hex())
 		t.Logf("  Content: %s\n", doc.Content)
 		t.Logf("  Metadata: %+v\n", doc.Metadata)
 		t.Logf("  Embedding length: %d\n", len(doc.Embedding))
@@ -398,11 +411,3032 @@ func TestBasicVectorQuery(t *testing.T) {
 
 }
 
+func TestInsertDocumentsIsAtomic(t *testing.T) {
+	wtService := wiredtiger.WiredTiger()
+
+	if _, err := os.Stat(WIREDTIGER_DIR); os.IsNotExist(err) {
+		if mkErr := os.MkdirAll(WIREDTIGER_DIR, 0755); mkErr != nil {
+			t.Fatalf("failed to create WT_HOME_TEST dir: %v", mkErr)
+		}
+	}
+
+	if err := wtService.Open(WIREDTIGER_DIR, "create"); err != nil {
+		t.Log("Err occured")
+	}
+
+	dbName := "default"
+	collName := "tenant_id_1"
+
+	dbSvc := DatabaseService(DbParams{
+		Name:      dbName,
+		KvService: wtService,
+	})
+
+	if err := dbSvc.CreateDB(); err != nil {
+		t.Errorf("Failed to create Db; %s", err)
+	}
+
+	if err := dbSvc.CreateCollection(collName); err != nil {
+		t.Errorf("Failed to create collection: %s", err)
+	}
+
+	collectionDefKey := fmt.Sprintf("%s.%s", dbName, collName)
+	val, _, _ := wtService.GetBinary(CATALOG, []byte(collectionDefKey))
+	var catalogEntry CollectionCatalogEntry
+	bson.Unmarshal(val, &catalogEntry)
+
+	t.Cleanup(func() {
+		if err := wtService.Close(); err != nil {
+			fmt.Printf("Warning: failed to close connection: %v\n", err)
+		}
+		if catalogEntry.VectorIndexUri != "" {
+			os.Remove(catalogEntry.VectorIndexUri)
+		}
+		os.RemoveAll("volumes/WT_HOME_TEST")
+	})
+
+	// The second document has no embedding, so the FAISS add fails midway
+	// through the batch and the whole insert must be rolled back.
+	documents := []GlowstickDocument{
+		{
+			ID:        primitive.NewObjectID(),
+			Content:   "Valid document",
+			Embedding: genEmbeddings(1536),
+		},
+		{
+			ID:      primitive.NewObjectID(),
+			Content: "Document without an embedding",
+		},
+	}
+
+	if err := dbSvc.InsertDocumentsIntoCollection(collName, documents); err == nil {
+		t.Fatalf("expected InsertDocumentsIntoCollection to fail for a document without an embedding")
+	}
+
+	_, found, err := wtService.GetBinary(catalogEntry.TableUri, documents[0].ID[:])
+	if err != nil {
+		t.Errorf("failed to read doc _id=%s: %v", documents[0].ID.Hex(), err)
+	}
+	if found {
+		t.Errorf("doc _id=%s was persisted even though its batch failed", documents[0].ID.Hex())
+	}
+
+	if _, found, _ := wtService.GetString(catalogEntry.LabelToDocUri, "0"); found {
+		t.Errorf("label mapping was persisted even though its batch failed")
+	}
+
+	statsVal, _, err := wtService.GetBinary(STATS, []byte(collectionDefKey))
+	if err != nil {
+		t.Fatalf("Failed to retrieve _stats entry for collection %s: %v", collName, err)
+	}
+	var hotStats CollectionStats
+	if err := bson.Unmarshal(statsVal, &hotStats); err != nil {
+		t.Fatalf("Unmarshal failed for hot stats: %v", err)
+	}
+	if hotStats.Doc_Count != 0 {
+		t.Errorf("Stats Doc_Count changed by a failed insert, got %d, want 0", hotStats.Doc_Count)
+	}
+}
+
+func TestVectorWalReplay(t *testing.T) {
+	wtService := wiredtiger.WiredTiger()
+
+	if _, err := os.Stat(WIREDTIGER_DIR); os.IsNotExist(err) {
+		if mkErr := os.MkdirAll(WIREDTIGER_DIR, 0755); mkErr != nil {
+			t.Fatalf("failed to create WT_HOME_TEST dir: %v", mkErr)
+		}
+	}
+
+	if err := wtService.Open(WIREDTIGER_DIR, "create"); err != nil {
+		t.Log("Err occured")
+	}
+
+	dbName := "default"
+	collName := "tenant_id_1"
+
+	dbSvc := DatabaseService(DbParams{
+		Name:      dbName,
+		KvService: wtService,
+	})
+
+	if err := dbSvc.CreateDB(); err != nil {
+		t.Errorf("Failed to create Db; %s", err)
+	}
+
+	if err := dbSvc.CreateCollection(collName); err != nil {
+		t.Errorf("Failed to create collection: %s", err)
+	}
+
+	collectionDefKey := fmt.Sprintf("%s.%s", dbName, collName)
+	val, _, _ := wtService.GetBinary(CATALOG, []byte(collectionDefKey))
+	var catalogEntry CollectionCatalogEntry
+	bson.Unmarshal(val, &catalogEntry)
+	indexPath := catalogEntry.VectorIndexUri
+
+	t.Cleanup(func() {
+		if err := wtService.Close(); err != nil {
+			fmt.Printf("Warning: failed to close connection: %v\n", err)
+		}
+		os.Remove(indexPath)
+		os.RemoveAll("volumes/WT_HOME_TEST")
+	})
+
+	newDocs := func(n int) []GlowstickDocument {
+		docs := make([]GlowstickDocument, n)
+		for i := range docs {
+			docs[i] = GlowstickDocument{
+				ID:        primitive.NewObjectID(),
+				Content:   fmt.Sprintf("Example document number %d", i+1),
+				Embedding: genEmbeddings(1536),
+			}
+		}
+		return docs
+	}
+
+	if err := dbSvc.InsertDocumentsIntoCollection(collName, newDocs(3)); err != nil {
+		t.Fatalf("InsertDocumentsIntoCollection returned error: %v", err)
+	}
+
+	snapshot, err := os.ReadFile(indexPath)
+	if err != nil {
+		t.Fatalf("failed to snapshot vector index: %v", err)
+	}
+
+	second := newDocs(2)
+	if err := dbSvc.InsertDocumentsIntoCollection(collName, second); err != nil {
+		t.Fatalf("InsertDocumentsIntoCollection returned error: %v", err)
+	}
+
+	// Simulate a crash between the WiredTiger commit and the index write: the
+	// file only holds the first batch and the WAL still lists the second.
+	if err := os.WriteFile(indexPath, snapshot, 0644); err != nil {
+		t.Fatalf("failed to restore vector index snapshot: %v", err)
+	}
+	for i, doc := range second {
+		entry, _ := bson.Marshal(VectorWalEntry{DocID: doc.ID, Embedding: doc.Embedding})
+		if err := wtService.PutBinary(VECTOR_WAL, walKey(collectionDefKey, int64(3+i)), entry); err != nil {
+			t.Fatalf("failed to seed vector WAL: %v", err)
+		}
+	}
+
+	if _, err := dbSvc.QueryCollection(collName, QueryStruct{TopK: 5, QueryEmbedding: genEmbeddings(1536)}); err != nil {
+		t.Errorf("error occured during query %v", err)
+	}
+
+	idx, err := faiss.FAISS().ReadIndex(indexPath)
+	if err != nil {
+		t.Fatalf("failed to read vector index: %v", err)
+	}
+	defer idx.Free()
+
+	if nTotal, _ := idx.NTotal(); nTotal != 5 {
+		t.Errorf("vector index was not reconciled with the WAL, got %d vectors, want 5", nTotal)
+	}
+
+	start, end := walRange(collectionDefKey)
+	cursor, err := wtService.ScanRangeBinary(VECTOR_WAL, start, end)
+	if err != nil {
+		t.Fatalf("failed to scan vector WAL: %v", err)
+	}
+	defer cursor.Close()
+	if cursor.Next() {
+		t.Errorf("vector WAL still has entries after replay")
+	}
+}
+
+func TestCollectionsHaveSeparateLabelMappings(t *testing.T) {
+	wtService := wiredtiger.WiredTiger()
+
+	if _, err := os.Stat(WIREDTIGER_DIR); os.IsNotExist(err) {
+		if mkErr := os.MkdirAll(WIREDTIGER_DIR, 0755); mkErr != nil {
+			t.Fatalf("failed to create WT_HOME_TEST dir: %v", mkErr)
+		}
+	}
+
+	if err := wtService.Open(WIREDTIGER_DIR, "create"); err != nil {
+		t.Log("Err occured")
+	}
+
+	dbName := "default"
+	collNames := []string{"tenant_id_1", "tenant_id_2"}
+
+	dbSvc := DatabaseService(DbParams{
+		Name:      dbName,
+		KvService: wtService,
+	})
+
+	if err := dbSvc.CreateDB(); err != nil {
+		t.Errorf("Failed to create Db; %s", err)
+	}
+
+	var indexPaths []string
+	for _, collName := range collNames {
+		if err := dbSvc.CreateCollection(collName); err != nil {
+			t.Errorf("Failed to create collection: %s", err)
+		}
+
+		// Both collections hand out FAISS labels starting at 0.
+		documents := make([]GlowstickDocument, 3)
+		for i := range documents {
+			documents[i] = GlowstickDocument{
+				ID:        primitive.NewObjectID(),
+				Content:   fmt.Sprintf("%s document %d", collName, i+1),
+				Embedding: genEmbeddings(1536),
+			}
+		}
+
+		if err := dbSvc.InsertDocumentsIntoCollection(collName, documents); err != nil {
+			t.Errorf("InsertDocumentsIntoCollection returned error: %v", err)
+		}
+
+		val, _, _ := wtService.GetBinary(CATALOG, []byte(fmt.Sprintf("%s.%s", dbName, collName)))
+		var catalogEntry CollectionCatalogEntry
+		bson.Unmarshal(val, &catalogEntry)
+		indexPaths = append(indexPaths, catalogEntry.VectorIndexUri)
+
+		if catalogEntry.LabelToDocUri == "" || catalogEntry.DocToLabelUri == "" {
+			t.Errorf("catalog entry for %s is missing its label mapping tables", collName)
+		}
+	}
+
+	t.Cleanup(func() {
+		if err := wtService.Close(); err != nil {
+			fmt.Printf("Warning: failed to close connection: %v\n", err)
+		}
+		for _, path := range indexPaths {
+			os.Remove(path)
+		}
+		os.RemoveAll("volumes/WT_HOME_TEST")
+	})
+
+	for _, collName := range collNames {
+		docs, err := dbSvc.QueryCollection(collName, QueryStruct{TopK: 3, QueryEmbedding: genEmbeddings(1536)})
+		if err != nil {
+			t.Errorf("error occured during query %v", err)
+		}
+		if len(docs) != 3 {
+			t.Errorf("query on %s returned %d docs, want 3", collName, len(docs))
+		}
+		for _, result := range docs {
+			if !strings.HasPrefix(result.Document.Content, collName+" ") {
+				t.Errorf("query on %s returned document from another collection: %q", collName, result.Document.Content)
+			}
+		}
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	metadata := bson.D{
+		{Key: "type", Value: "example"},
+		{Key: "index", Value: int32(4)},
+		{Key: "tags", Value: bson.A{"news", "sports"}},
+		{Key: "author", Value: bson.D{{Key: "name", Value: "ada"}}},
+	}
+
+	cases := []struct {
+		filter map[string]interface{}
+		want   bool
+	}{
+		{map[string]interface{}{"type": "example"}, true},
+		{map[string]interface{}{"type": map[string]interface{}{"$ne": "example"}}, false},
+		{map[string]interface{}{"index": map[string]interface{}{"$gt": 3, "$lt": 5.5}}, true},
+		{map[string]interface{}{"index": map[string]interface{}{"$gte": 5}}, false},
+		{map[string]interface{}{"tags": "sports"}, true},
+		{map[string]interface{}{"tags": map[string]interface{}{"$in": []interface{}{"tech", "news"}}}, true},
+		{map[string]interface{}{"author.name": "ada"}, true},
+		{map[string]interface{}{"missing": map[string]interface{}{"$exists": false}}, true},
+		{map[string]interface{}{"$or": []interface{}{
+			map[string]interface{}{"type": "other"},
+			map[string]interface{}{"index": 4},
+		}}, true},
+		{map[string]interface{}{"$and": []interface{}{
+			map[string]interface{}{"type": "example"},
+			map[string]interface{}{"index": map[string]interface{}{"$lt": 2}},
+		}}, false},
+	}
+
+	for _, tc := range cases {
+		filter, err := CompileFilter(tc.filter)
+		if err != nil {
+			t.Errorf("CompileFilter(%v) returned error: %v", tc.filter, err)
+			continue
+		}
+		if got := filter.Match(metadata); got != tc.want {
+			t.Errorf("filter %v: got %v, want %v", tc.filter, got, tc.want)
+		}
+	}
+
+	if _, err := CompileFilter(map[string]interface{}{"index": map[string]interface{}{"$regex": "x"}}); err == nil {
+		t.Errorf("expected an error for an unsupported operator")
+	}
+}
+
+func TestFilteredVectorQuery(t *testing.T) {
+	wtService := wiredtiger.WiredTiger()
+
+	if _, err := os.Stat(WIREDTIGER_DIR); os.IsNotExist(err) {
+		if mkErr := os.MkdirAll(WIREDTIGER_DIR, 0755); mkErr != nil {
+			t.Fatalf("failed to create WT_HOME_TEST dir: %v", mkErr)
+		}
+	}
+
+	if err := wtService.Open(WIREDTIGER_DIR, "create"); err != nil {
+		t.Log("Err occured")
+	}
+
+	dbName := "default"
+	collName := "tenant_id_1"
+
+	dbSvc := DatabaseService(DbParams{
+		Name:      dbName,
+		KvService: wtService,
+	})
+
+	if err := dbSvc.CreateDB(); err != nil {
+		t.Errorf("Failed to create Db; %s", err)
+	}
+
+	if err := dbSvc.CreateCollection(collName); err != nil {
+		t.Errorf("Failed to create collection: %s", err)
+	}
+
+	documents := make([]GlowstickDocument, 20)
+	for i := range documents {
+		documents[i] = GlowstickDocument{
+			ID:        primitive.NewObjectID(),
+			Content:   fmt.Sprintf("Example document number %d", i+1),
+			Embedding: genEmbeddings(1536),
+			Metadata:  map[string]interface{}{"type": "example", "index": i + 1},
+		}
+	}
+
+	if err := dbSvc.InsertDocumentsIntoCollection(collName, documents); err != nil {
+		t.Errorf("InsertDocumentsIntoCollection returned error: %v", err)
+	}
+
+	val, _, _ := wtService.GetBinary(CATALOG, []byte(fmt.Sprintf("%s.%s", dbName, collName)))
+	var catalogEntry CollectionCatalogEntry
+	bson.Unmarshal(val, &catalogEntry)
+
+	t.Cleanup(func() {
+		if err := wtService.Close(); err != nil {
+			fmt.Printf("Warning: failed to close connection: %v\n", err)
+		}
+		if catalogEntry.VectorIndexUri != "" {
+			os.Remove(catalogEntry.VectorIndexUri)
+		}
+		os.RemoveAll("volumes/WT_HOME_TEST")
+	})
+
+	// Only 3 of 20 documents match, so the query has to widen its search.
+	query := QueryStruct{
+		TopK:           3,
+		QueryEmbedding: genEmbeddings(1536),
+		Filters:        map[string]interface{}{"index": map[string]interface{}{"$gt": 17}},
+	}
+
+	docs, err := dbSvc.QueryCollection(collName, query)
+	if err != nil {
+		t.Errorf("error occured during query %v", err)
+	}
+
+	if len(docs) != 3 {
+		t.Fatalf("filtered query returned %d docs, want 3", len(docs))
+	}
+
+	filter, _ := CompileFilter(query.Filters)
+	for _, result := range docs {
+		doc := result.Document
+		if !filter.Match(doc.Metadata) {
+			t.Errorf("document %q does not satisfy the filter: %+v", doc.Content, doc.Metadata)
+		}
+	}
+}
+
+func TestEncodeIndexValueOrder(t *testing.T) {
+	ordered := [][]interface{}{
+		{-1e9, int32(-5), -0.5, 0, int64(2), 2.5, 10},
+		{"", "a", "ab", "b"},
+	}
+
+	for _, values := range ordered {
+		for i := 1; i < len(values); i++ {
+			prev, _ := encodeIndexValue(values[i-1])
+			curr, _ := encodeIndexValue(values[i])
+			if bytes.Compare(prev, curr) >= 0 {
+				t.Errorf("encoding of %v does not sort before %v", values[i-1], values[i])
+			}
+		}
+	}
+
+	if _, ok := encodeIndexValue(map[string]interface{}{"a": 1}); ok {
+		t.Errorf("documents should not be indexable")
+	}
+}
+
+func TestSecondaryIndex(t *testing.T) {
+	wtService := wiredtiger.WiredTiger()
+
+	if _, err := os.Stat(WIREDTIGER_DIR); os.IsNotExist(err) {
+		if mkErr := os.MkdirAll(WIREDTIGER_DIR, 0755); mkErr != nil {
+			t.Fatalf("failed to create WT_HOME_TEST dir: %v", mkErr)
+		}
+	}
+
+	if err := wtService.Open(WIREDTIGER_DIR, "create"); err != nil {
+		t.Log("Err occured")
+	}
+
+	dbName := "default"
+	collName := "tenant_id_1"
+	collectionDefKey := fmt.Sprintf("%s.%s", dbName, collName)
+
+	dbSvc := DatabaseService(DbParams{
+		Name:      dbName,
+		KvService: wtService,
+	})
+
+	if err := dbSvc.CreateDB(); err != nil {
+		t.Errorf("Failed to create Db; %s", err)
+	}
+
+	if err := dbSvc.CreateCollection(collName); err != nil {
+		t.Errorf("Failed to create collection: %s", err)
+	}
+
+	newDocs := func(from, n int) []GlowstickDocument {
+		docs := make([]GlowstickDocument, n)
+		for i := range docs {
+			docs[i] = GlowstickDocument{
+				ID:        primitive.NewObjectID(),
+				Content:   fmt.Sprintf("Example document number %d", from+i),
+				Embedding: genEmbeddings(1536),
+				Metadata:  map[string]interface{}{"type": "example", "index": from + i},
+			}
+		}
+		return docs
+	}
+
+	// Documents inserted before the index exists are picked up by the backfill,
+	// later ones by index maintenance on insert.
+	if err := dbSvc.InsertDocumentsIntoCollection(collName, newDocs(1, 20)); err != nil {
+		t.Errorf("InsertDocumentsIntoCollection returned error: %v", err)
+	}
+
+	if err := dbSvc.CreateIndex(collName, CollectionIndex{Key: map[string]int{"index": 1}}); err != nil {
+		t.Fatalf("CreateIndex returned error: %v", err)
+	}
+
+	if err := dbSvc.InsertDocumentsIntoCollection(collName, newDocs(21, 5)); err != nil {
+		t.Errorf("InsertDocumentsIntoCollection returned error: %v", err)
+	}
+
+	val, _, _ := wtService.GetBinary(CATALOG, []byte(collectionDefKey))
+	var catalogEntry CollectionCatalogEntry
+	bson.Unmarshal(val, &catalogEntry)
+
+	t.Cleanup(func() {
+		if err := wtService.Close(); err != nil {
+			fmt.Printf("Warning: failed to close connection: %v\n", err)
+		}
+		if catalogEntry.VectorIndexUri != "" {
+			os.Remove(catalogEntry.VectorIndexUri)
+		}
+		os.RemoveAll("volumes/WT_HOME_TEST")
+	})
+
+	if len(catalogEntry.Indexes) != 1 || catalogEntry.Indexes[0].Name != "index_1" {
+		t.Fatalf("catalog entry does not list the new index: %+v", catalogEntry.Indexes)
+	}
+
+	cursor, err := wtService.ScanRangeBinary(catalogEntry.IndexTableUriMap["index_1"], nil, nil)
+	if err != nil {
+		t.Fatalf("failed to scan index table: %v", err)
+	}
+	entries := 0
+	for cursor.Next() {
+		entries++
+	}
+	cursor.Close()
+	if entries != 25 {
+		t.Errorf("index table has %d entries, want 25", entries)
+	}
+
+	query := QueryStruct{
+		TopK:           2,
+		QueryEmbedding: genEmbeddings(1536),
+		Filters:        map[string]interface{}{"index": map[string]interface{}{"$gt": 22}},
+	}
+
+	docs, err := dbSvc.QueryCollection(collName, query)
+	if err != nil {
+		t.Errorf("error occured during query %v", err)
+	}
+	if len(docs) != 2 {
+		t.Fatalf("indexed query returned %d docs, want 2", len(docs))
+	}
+
+	filter, _ := CompileFilter(query.Filters)
+	for _, result := range docs {
+		doc := result.Document
+		if !filter.Match(doc.Metadata) {
+			t.Errorf("document %q does not satisfy the filter: %+v", doc.Content, doc.Metadata)
+		}
+	}
+
+	if err := dbSvc.CreateIndex(collName, CollectionIndex{Key: map[string]int{"index": 1}}); err == nil {
+		t.Errorf("expected CreateIndex to reject a duplicate index name")
+	}
+}
+
+func TestDocumentLifecycle(t *testing.T) {
+	wtService := wiredtiger.WiredTiger()
+
+	if _, err := os.Stat(WIREDTIGER_DIR); os.IsNotExist(err) {
+		if mkErr := os.MkdirAll(WIREDTIGER_DIR, 0755); mkErr != nil {
+			t.Fatalf("failed to create WT_HOME_TEST dir: %v", mkErr)
+		}
+	}
+
+	if err := wtService.Open(WIREDTIGER_DIR, "create"); err != nil {
+		t.Log("Err occured")
+	}
+
+	dbName := "default"
+	collName := "tenant_id_1"
+	collectionDefKey := fmt.Sprintf("%s.%s", dbName, collName)
+
+	dbSvc := DatabaseService(DbParams{
+		Name:      dbName,
+		KvService: wtService,
+	})
+
+	if err := dbSvc.CreateDB(); err != nil {
+		t.Errorf("Failed to create Db; %s", err)
+	}
+
+	if err := dbSvc.CreateCollection(collName); err != nil {
+		t.Errorf("Failed to create collection: %s", err)
+	}
+
+	documents := make([]GlowstickDocument, 5)
+	for i := range documents {
+		documents[i] = GlowstickDocument{
+			ID:        primitive.NewObjectID(),
+			Content:   fmt.Sprintf("Example document number %d", i+1),
+			Embedding: genEmbeddings(1536),
+		}
+	}
+
+	if err := dbSvc.InsertDocumentsIntoCollection(collName, documents); err != nil {
+		t.Errorf("InsertDocumentsIntoCollection returned error: %v", err)
+	}
+
+	val, _, _ := wtService.GetBinary(CATALOG, []byte(collectionDefKey))
+	var catalogEntry CollectionCatalogEntry
+	bson.Unmarshal(val, &catalogEntry)
+
+	t.Cleanup(func() {
+		if err := wtService.Close(); err != nil {
+			fmt.Printf("Warning: failed to close connection: %v\n", err)
+		}
+		if catalogEntry.VectorIndexUri != "" {
+			os.Remove(catalogEntry.VectorIndexUri)
+		}
+		os.RemoveAll("volumes/WT_HOME_TEST")
+	})
+
+	readStats := func() CollectionStats {
+		statsVal, _, err := wtService.GetBinary(STATS, []byte(collectionDefKey))
+		if err != nil {
+			t.Fatalf("Failed to retrieve _stats entry: %v", err)
+		}
+		var stats CollectionStats
+		if err := bson.Unmarshal(statsVal, &stats); err != nil {
+			t.Fatalf("Unmarshal failed for hot stats: %v", err)
+		}
+		return stats
+	}
+
+	doc, found, err := dbSvc.GetDocument(collName, documents[0].ID)
+	if err != nil || !found {
+		t.Fatalf("GetDocument(%s) = found %v, err %v", documents[0].ID.Hex(), found, err)
+	}
+	if doc.Content != documents[0].Content || doc.ID != documents[0].ID {
+		t.Errorf("GetDocument returned %q (%s), want %q", doc.Content, doc.ID.Hex(), documents[0].Content)
+	}
+
+	// Update with a new embedding: the old vector is tombstoned and the new one
+	// must be what the document is found by.
+	updated := GlowstickDocument{Content: "Updated document", Embedding: genEmbeddings(1536)}
+	if err := dbSvc.UpdateDocument(collName, documents[0].ID, updated); err != nil {
+		t.Fatalf("UpdateDocument returned error: %v", err)
+	}
+
+	docs, err := dbSvc.QueryCollection(collName, QueryStruct{TopK: 1, QueryEmbedding: updated.Embedding})
+	if err != nil {
+		t.Errorf("error occured during query %v", err)
+	}
+	if len(docs) != 1 || docs[0].Document.Content != updated.Content {
+		t.Errorf("query by the updated embedding did not return the updated document: %+v", docs)
+	}
+
+	if stats := readStats(); stats.Doc_Count != 5 || stats.Tombstone_Count != 1 {
+		t.Errorf("after update got Doc_Count %d, Tombstone_Count %d, want 5 and 1", stats.Doc_Count, stats.Tombstone_Count)
+	}
+
+	if err := dbSvc.UpdateDocument(collName, primitive.NewObjectID(), updated); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("UpdateDocument on a missing _id returned %v, want ErrDocumentNotFound", err)
+	}
+
+	deleted, err := dbSvc.DeleteDocuments(collName, []primitive.ObjectID{documents[1].ID, primitive.NewObjectID()})
+	if err != nil {
+		t.Fatalf("DeleteDocuments returned error: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("DeleteDocuments deleted %d documents, want 1", deleted)
+	}
+
+	if _, found, _ := dbSvc.GetDocument(collName, documents[1].ID); found {
+		t.Errorf("deleted document is still readable")
+	}
+
+	docs, err = dbSvc.QueryCollection(collName, QueryStruct{TopK: 5, QueryEmbedding: documents[1].Embedding})
+	if err != nil {
+		t.Errorf("error occured during query %v", err)
+	}
+	if len(docs) != 4 {
+		t.Errorf("query returned %d docs after delete, want 4", len(docs))
+	}
+	for _, d := range docs {
+		if d.Document.Content == documents[1].Content {
+			t.Errorf("query returned the deleted document")
+		}
+	}
+
+	if stats := readStats(); stats.Doc_Count != 4 || stats.Tombstone_Count != 2 {
+		t.Errorf("after delete got Doc_Count %d, Tombstone_Count %d, want 4 and 2", stats.Doc_Count, stats.Tombstone_Count)
+	}
+
+	upserts := []GlowstickDocument{
+		{ID: documents[2].ID, Content: "Upserted existing document", Embedding: documents[2].Embedding},
+		{ID: primitive.NewObjectID(), Content: "Upserted new document", Embedding: genEmbeddings(1536)},
+	}
+	if err := dbSvc.UpsertDocuments(collName, upserts); err != nil {
+		t.Fatalf("UpsertDocuments returned error: %v", err)
+	}
+
+	if doc, _, _ := dbSvc.GetDocument(collName, documents[2].ID); doc.Content != upserts[0].Content {
+		t.Errorf("upsert did not replace the existing document, got %q", doc.Content)
+	}
+
+	// The first upsert kept its embedding, so only the new document adds a vector.
+	if stats := readStats(); stats.Doc_Count != 5 || stats.Tombstone_Count != 2 {
+		t.Errorf("after upsert got Doc_Count %d, Tombstone_Count %d, want 5 and 2", stats.Doc_Count, stats.Tombstone_Count)
+	}
+}
+
+func TestListAndDropCollections(t *testing.T) {
+	wtService := wiredtiger.WiredTiger()
+
+	if _, err := os.Stat(WIREDTIGER_DIR); os.IsNotExist(err) {
+		if mkErr := os.MkdirAll(WIREDTIGER_DIR, 0755); mkErr != nil {
+			t.Fatalf("failed to create WT_HOME_TEST dir: %v", mkErr)
+		}
+	}
+
+	if err := wtService.Open(WIREDTIGER_DIR, "create"); err != nil {
+		t.Log("Err occured")
+	}
+
+	t.Cleanup(func() {
+		if err := wtService.Close(); err != nil {
+			fmt.Printf("Warning: failed to close connection: %v\n", err)
+		}
+		os.RemoveAll("volumes/WT_HOME_TEST")
+	})
+
+	dbSvc := DatabaseService(DbParams{Name: "default", KvService: wtService})
+	// Shares a prefix with "default" but must not show up in its listings.
+	otherSvc := DatabaseService(DbParams{Name: "defaults", KvService: wtService})
+
+	for _, svc := range []DBService{dbSvc, otherSvc} {
+		if err := svc.CreateDB(); err != nil {
+			t.Fatalf("Failed to create Db; %s", err)
+		}
+	}
+
+	collNames := []string{"tenant_id_2", "tenant_id_1"}
+	for _, collName := range collNames {
+		if err := dbSvc.CreateCollection(collName); err != nil {
+			t.Fatalf("Failed to create collection: %s", err)
+		}
+		documents := []GlowstickDocument{{
+			ID:        primitive.NewObjectID(),
+			Content:   "Example document",
+			Embedding: genEmbeddings(1536),
+		}}
+		if err := dbSvc.InsertDocumentsIntoCollection(collName, documents); err != nil {
+			t.Fatalf("InsertDocumentsIntoCollection returned error: %v", err)
+		}
+	}
+	if err := otherSvc.CreateCollection("tenant_id_3"); err != nil {
+		t.Fatalf("Failed to create collection: %s", err)
+	}
+
+	for _, name := range []string{"", "default.other", "default/other"} {
+		if err := DatabaseService(DbParams{Name: name, KvService: wtService}).CreateDB(); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("CreateDB(%q) returned %v, want ErrInvalidName", name, err)
+		}
+	}
+	// "tenant_id_1/x" would share tenant_id_1's vector WAL range.
+	for _, name := range []string{"", "tenant_id_1.x", "tenant_id_1/x"} {
+		if err := dbSvc.CreateCollection(name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("CreateCollection(%q) returned %v, want ErrInvalidName", name, err)
+		}
+	}
+
+	collections, err := dbSvc.ListCollections()
+	if err != nil {
+		t.Fatalf("ListCollections returned error: %v", err)
+	}
+	if len(collections) != 2 || collections[0].Ns != "default.tenant_id_1" || collections[1].Ns != "default.tenant_id_2" {
+		t.Fatalf("ListCollections returned %+v, want default.tenant_id_1 and default.tenant_id_2", collections)
+	}
+
+	dropped := collections[0]
+	if err := dbSvc.DropCollection("tenant_id_1"); err != nil {
+		t.Fatalf("DropCollection returned error: %v", err)
+	}
+
+	if _, err := os.Stat(dropped.VectorIndexUri); !os.IsNotExist(err) {
+		t.Errorf("vector index file %s was not removed", dropped.VectorIndexUri)
+	}
+	if _, exists, _ := wtService.GetBinary(STATS, []byte("default.tenant_id_1")); exists {
+		t.Errorf("stats row survived DropCollection")
+	}
+	if _, err := dbSvc.QueryCollection("tenant_id_1", QueryStruct{TopK: 1, QueryEmbedding: genEmbeddings(1536)}); !errors.Is(err, ErrCollectionNotFound) {
+		t.Errorf("query on dropped collection returned %v, want ErrCollectionNotFound", err)
+	}
+	if err := dbSvc.DropCollection("tenant_id_1"); !errors.Is(err, ErrCollectionNotFound) {
+		t.Errorf("second DropCollection returned %v, want ErrCollectionNotFound", err)
+	}
+
+	// The name can be reused once dropped.
+	if err := dbSvc.CreateCollection("tenant_id_1"); err != nil {
+		t.Errorf("Failed to recreate dropped collection: %s", err)
+	}
+
+	if err := dbSvc.DeleteDB(); err != nil {
+		t.Fatalf("DeleteDB returned error: %v", err)
+	}
+
+	if _, exists, _ := wtService.GetBinaryWithStringKey(CATALOG, "db:default"); exists {
+		t.Errorf("db catalog entry survived DeleteDB")
+	}
+	if _, err := os.Stat(collections[1].VectorIndexUri); !os.IsNotExist(err) {
+		t.Errorf("vector index file %s was not removed", collections[1].VectorIndexUri)
+	}
+	if err := dbSvc.DeleteDB(); !errors.Is(err, ErrDatabaseNotFound) {
+		t.Errorf("second DeleteDB returned %v, want ErrDatabaseNotFound", err)
+	}
+
+	remaining, err := otherSvc.ListCollections()
+	if err != nil || len(remaining) != 1 {
+		t.Errorf("DeleteDB touched another database: %+v, %v", remaining, err)
+	}
+}
+
+func TestDefaultTrainSize(t *testing.T) {
+	cases := map[string]int{
+		"Flat":         1,
+		"HNSW32":       1,
+		"IVF256,Flat":  39 * 256,
+		"IVF1024,PQ16": 39 * 1024,
+		"PQ16x4":       39 * 16,
+		"IVF4,PQ8":     39 * 256,
+	}
+	for factory, want := range cases {
+		if got := defaultTrainSize(factory); got != want {
+			t.Errorf("defaultTrainSize(%q) = %d, want %d", factory, got, want)
+		}
+	}
+}
+
+func TestTrainedVectorIndex(t *testing.T) {
+	wtService := wiredtiger.WiredTiger()
+
+	if _, err := os.Stat(WIREDTIGER_DIR); os.IsNotExist(err) {
+		if mkErr := os.MkdirAll(WIREDTIGER_DIR, 0755); mkErr != nil {
+			t.Fatalf("failed to create WT_HOME_TEST dir: %v", mkErr)
+		}
+	}
+
+	if err := wtService.Open(WIREDTIGER_DIR, "create"); err != nil {
+		t.Log("Err occured")
+	}
+
+	dbName := "default"
+	collName := "tenant_id_1"
+	collectionDefKey := fmt.Sprintf("%s.%s", dbName, collName)
+	const dim = 8
+
+	dbSvc := DatabaseService(DbParams{
+		Name:      dbName,
+		KvService: wtService,
+	})
+
+	if err := dbSvc.CreateDB(); err != nil {
+		t.Errorf("Failed to create Db; %s", err)
+	}
+
+	if err := dbSvc.CreateCollection("bad_metric", CollectionOptions{Metric: "hamming"}); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("CreateCollection with an unknown metric returned %v, want ErrInvalidOptions", err)
+	}
+	if err := dbSvc.CreateCollection("bad_factory", CollectionOptions{Dimension: dim, IndexType: "NotAnIndex"}); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("CreateCollection with an unknown index type returned %v, want ErrInvalidOptions", err)
+	}
+
+	err := dbSvc.CreateCollection(collName, CollectionOptions{Dimension: dim, IndexType: "IVF4,Flat", TrainSize: 20})
+	if err != nil {
+		t.Fatalf("Failed to create collection: %s", err)
+	}
+
+	val, _, _ := wtService.GetBinary(CATALOG, []byte(collectionDefKey))
+	var catalogEntry CollectionCatalogEntry
+	bson.Unmarshal(val, &catalogEntry)
+
+	t.Cleanup(func() {
+		if err := wtService.Close(); err != nil {
+			fmt.Printf("Warning: failed to close connection: %v\n", err)
+		}
+		os.Remove(catalogEntry.VectorIndexUri)
+		os.RemoveAll("volumes/WT_HOME_TEST")
+	})
+
+	if catalogEntry.VectorIndex.Factory != "IVF4,Flat" || catalogEntry.VectorIndex.Dimension != dim || catalogEntry.VectorIndex.Metric != MetricL2 {
+		t.Errorf("catalog recorded vector index %+v", catalogEntry.VectorIndex)
+	}
+
+	newDocs := func(batch string, n int) []GlowstickDocument {
+		docs := make([]GlowstickDocument, n)
+		for i := range docs {
+			docs[i] = GlowstickDocument{
+				ID:        primitive.NewObjectID(),
+				Content:   fmt.Sprintf("%s document number %d", batch, i+1),
+				Embedding: genEmbeddings(dim),
+			}
+		}
+		return docs
+	}
+
+	indexState := func() (bool, int64) {
+		idx, err := faiss.FAISS().ReadIndex(catalogEntry.VectorIndexUri)
+		if err != nil {
+			t.Fatalf("failed to read vector index: %v", err)
+		}
+		defer idx.Free()
+		trained, _ := idx.IsTrained()
+		nTotal, _ := idx.NTotal()
+		return trained, nTotal
+	}
+
+	// Below the training threshold vectors are buffered and queries are exact.
+	first := newDocs("First", 10)
+	if err := dbSvc.InsertDocumentsIntoCollection(collName, first); err != nil {
+		t.Fatalf("InsertDocumentsIntoCollection returned error: %v", err)
+	}
+
+	if trained, nTotal := indexState(); trained || nTotal != 0 {
+		t.Errorf("index after 10 vectors: trained %v, ntotal %d; want untrained and empty", trained, nTotal)
+	}
+
+	docs, err := dbSvc.QueryCollection(collName, QueryStruct{TopK: 1, QueryEmbedding: first[3].Embedding})
+	if err != nil {
+		t.Fatalf("query on untrained index returned error: %v", err)
+	}
+	if len(docs) != 1 || docs[0].Document.Content != first[3].Content {
+		t.Errorf("query on untrained index returned %+v, want %q", docs, first[3].Content)
+	}
+
+	// Crossing the threshold trains the index and adds every buffered vector.
+	second := newDocs("Second", 15)
+	if err := dbSvc.InsertDocumentsIntoCollection(collName, second); err != nil {
+		t.Fatalf("InsertDocumentsIntoCollection returned error: %v", err)
+	}
+
+	if trained, nTotal := indexState(); !trained || nTotal != 25 {
+		t.Errorf("index after 25 vectors: trained %v, ntotal %d; want trained with 25", trained, nTotal)
+	}
+
+	start, end := walRange(collectionDefKey)
+	cursor, err := wtService.ScanRangeBinary(VECTOR_WAL, start, end)
+	if err != nil {
+		t.Fatalf("failed to scan vector WAL: %v", err)
+	}
+	if cursor.Next() {
+		t.Errorf("vector WAL still has entries after training")
+	}
+	cursor.Close()
+
+	docs, err = dbSvc.QueryCollection(collName, QueryStruct{TopK: 1, QueryEmbedding: second[7].Embedding})
+	if err != nil {
+		t.Fatalf("query on trained index returned error: %v", err)
+	}
+	if len(docs) != 1 || docs[0].Document.Content != second[7].Content {
+		t.Errorf("query on trained index returned %+v, want %q", docs, second[7].Content)
+	}
+}
+
+func TestSimilarityMetrics(t *testing.T) {
+	wtService := wiredtiger.WiredTiger()
+
+	if _, err := os.Stat(WIREDTIGER_DIR); os.IsNotExist(err) {
+		if mkErr := os.MkdirAll(WIREDTIGER_DIR, 0755); mkErr != nil {
+			t.Fatalf("failed to create WT_HOME_TEST dir: %v", mkErr)
+		}
+	}
+
+	if err := wtService.Open(WIREDTIGER_DIR, "create"); err != nil {
+		t.Log("Err occured")
+	}
+
+	dbSvc := DatabaseService(DbParams{
+		Name:      "default",
+		KvService: wtService,
+	})
+
+	if err := dbSvc.CreateDB(); err != nil {
+		t.Errorf("Failed to create Db; %s", err)
+	}
+
+	var indexPaths []string
+	t.Cleanup(func() {
+		if err := wtService.Close(); err != nil {
+			fmt.Printf("Warning: failed to close connection: %v\n", err)
+		}
+		for _, path := range indexPaths {
+			os.Remove(path)
+		}
+		os.RemoveAll("volumes/WT_HOME_TEST")
+	})
+
+	const dim = 8
+	scale := func(v []float32, f float32) []float32 {
+		out := make([]float32, len(v))
+		for i := range v {
+			out[i] = v[i] * f
+		}
+		return out
+	}
+
+	query := genEmbeddings(dim)
+	// "near" points the same way as the query but is far away in L2 terms;
+	// "close" is a slightly rotated copy with the query's own length.
+	near := scale(query, 10)
+	close := append([]float32{}, query...)
+	close[0] += 0.3
+
+	for _, metric := range []string{MetricCosine, MetricInnerProduct} {
+		collName := "tenant_" + metric
+		if err := dbSvc.CreateCollection(collName, CollectionOptions{Dimension: dim, Metric: metric}); err != nil {
+			t.Fatalf("Failed to create %s collection: %s", metric, err)
+		}
+		indexPaths = append(indexPaths, fmt.Sprintf("default.%s.index", collName))
+
+		documents := []GlowstickDocument{
+			{ID: primitive.NewObjectID(), Content: "near", Embedding: near},
+			{ID: primitive.NewObjectID(), Content: "close", Embedding: close},
+			{ID: primitive.NewObjectID(), Content: "opposite", Embedding: scale(query, -1)},
+		}
+		if err := dbSvc.InsertDocumentsIntoCollection(collName, documents); err != nil {
+			t.Fatalf("InsertDocumentsIntoCollection returned error: %v", err)
+		}
+
+		docs, err := dbSvc.QueryCollection(collName, QueryStruct{TopK: 3, QueryEmbedding: query})
+		if err != nil {
+			t.Fatalf("%s query returned error: %v", metric, err)
+		}
+		var got []string
+		for i, doc := range docs {
+			got = append(got, doc.Document.Content)
+			if doc.Score < 0 || doc.Score > 1 {
+				t.Errorf("%s score %f of %q is outside [0, 1]", metric, doc.Score, doc.Document.Content)
+			}
+			if i > 0 && doc.Score > docs[i-1].Score {
+				t.Errorf("%s scores are not descending: %f after %f", metric, doc.Score, docs[i-1].Score)
+			}
+			if doc.Label < 0 {
+				t.Errorf("%s result %q has no label", metric, doc.Document.Content)
+			}
+		}
+		if strings.Join(got, ",") != "near,close,opposite" {
+			t.Errorf("%s query ranked %v, want [near close opposite]", metric, got)
+		}
+	}
+
+	// Cosine distances are 1 - similarity: "near" is at ~0, "opposite" at ~2.
+	docs, err := dbSvc.QueryCollection("tenant_cosine", QueryStruct{TopK: 3, QueryEmbedding: query, MaxDistance: 0.001})
+	if err != nil {
+		t.Fatalf("cosine query returned error: %v", err)
+	}
+	if len(docs) != 1 || docs[0].Document.Content != "near" {
+		t.Errorf("cosine query with MaxDistance 0.001 returned %d docs, want only \"near\"", len(docs))
+	}
+
+	// Inner-product distances are negated similarities.
+	docs, err = dbSvc.QueryCollection("tenant_ip", QueryStruct{TopK: 3, QueryEmbedding: query, MaxDistance: -5})
+	if err != nil {
+		t.Fatalf("ip query returned error: %v", err)
+	}
+	if len(docs) != 1 || docs[0].Document.Content != "near" {
+		t.Errorf("ip query with MaxDistance -5 returned %d docs, want only \"near\"", len(docs))
+	}
+
+	// The exact-search path must rank the same way as the index.
+	for _, metric := range []string{MetricCosine, MetricInnerProduct} {
+		if vectorDistance(metric, near, query) >= vectorDistance(metric, close, query) {
+			t.Errorf("%s vectorDistance ranks near after close", metric)
+		}
+	}
+}
+
+func TestQueryCollectionBatch(t *testing.T) {
+	wtService := wiredtiger.WiredTiger()
+
+	if _, err := os.Stat(WIREDTIGER_DIR); os.IsNotExist(err) {
+		if mkErr := os.MkdirAll(WIREDTIGER_DIR, 0755); mkErr != nil {
+			t.Fatalf("failed to create WT_HOME_TEST dir: %v", mkErr)
+		}
+	}
+
+	if err := wtService.Open(WIREDTIGER_DIR, "create"); err != nil {
+		t.Log("Err occured")
+	}
+
+	dbSvc := DatabaseService(DbParams{
+		Name:      "default",
+		KvService: wtService,
+	})
+
+	if err := dbSvc.CreateDB(); err != nil {
+		t.Errorf("Failed to create Db; %s", err)
+	}
+
+	collName := "tenant_id_1"
+	t.Cleanup(func() {
+		if err := wtService.Close(); err != nil {
+			fmt.Printf("Warning: failed to close connection: %v\n", err)
+		}
+		os.Remove("default.tenant_id_1.index")
+		os.RemoveAll("volumes/WT_HOME_TEST")
+	})
+
+	if err := dbSvc.CreateCollection(collName); err != nil {
+		t.Fatalf("Failed to create collection: %s", err)
+	}
+
+	const dim = 16
+	documents := make([]GlowstickDocument, 20)
+	for i := range documents {
+		documents[i] = GlowstickDocument{
+			ID:        primitive.NewObjectID(),
+			Content:   fmt.Sprintf("doc %d", i),
+			Embedding: genEmbeddings(dim),
+			Metadata:  map[string]interface{}{"even": i%2 == 0},
+		}
+	}
+	if err := dbSvc.InsertDocumentsIntoCollection(collName, documents); err != nil {
+		t.Fatalf("InsertDocumentsIntoCollection returned error: %v", err)
+	}
+
+	picks := []int{3, 8, 15}
+	batch := BatchQueryStruct{TopK: 3}
+	for _, i := range picks {
+		batch.QueryEmbeddings = append(batch.QueryEmbeddings, documents[i].Embedding)
+	}
+
+	results, err := dbSvc.QueryCollectionBatch(collName, batch)
+	if err != nil {
+		t.Fatalf("QueryCollectionBatch returned error: %v", err)
+	}
+	if len(results) != len(picks) {
+		t.Fatalf("QueryCollectionBatch returned %d result sets, want %d", len(results), len(picks))
+	}
+	for q, i := range picks {
+		single, err := dbSvc.QueryCollection(collName, QueryStruct{TopK: 3, QueryEmbedding: documents[i].Embedding})
+		if err != nil {
+			t.Fatalf("QueryCollection returned error: %v", err)
+		}
+		if len(results[q]) != len(single) {
+			t.Fatalf("query %d returned %d results in a batch and %d alone", q, len(results[q]), len(single))
+		}
+		for r := range single {
+			if results[q][r].Document.Content != single[r].Document.Content || results[q][r].Label != single[r].Label {
+				t.Errorf("query %d result %d is %q in a batch and %q alone", q, r, results[q][r].Document.Content, single[r].Document.Content)
+			}
+		}
+		if results[q][0].Document.Content != documents[i].Content {
+			t.Errorf("query %d did not rank its own document first, got %q", q, results[q][0].Document.Content)
+		}
+	}
+
+	// Filters apply to every query of the batch; 15 is odd so it is filtered out.
+	batch.Filters = map[string]interface{}{"even": true}
+	filter, err := CompileFilter(batch.Filters)
+	if err != nil {
+		t.Fatalf("CompileFilter returned error: %v", err)
+	}
+	results, err = dbSvc.QueryCollectionBatch(collName, batch)
+	if err != nil {
+		t.Fatalf("filtered QueryCollectionBatch returned error: %v", err)
+	}
+	for q, set := range results {
+		if len(set) != 3 {
+			t.Errorf("filtered query %d returned %d results, want 3", q, len(set))
+		}
+		for _, result := range set {
+			if !filter.Match(result.Document.Metadata) {
+				t.Errorf("filtered query %d returned %q", q, result.Document.Content)
+			}
+		}
+	}
+
+	if _, err := dbSvc.QueryCollectionBatch(collName, BatchQueryStruct{TopK: 1, QueryEmbeddings: [][]float32{genEmbeddings(dim), genEmbeddings(dim / 2)}}); err == nil {
+		t.Errorf("QueryCollectionBatch accepted query embeddings of different dimensions")
+	}
+	if results, err := dbSvc.QueryCollectionBatch(collName, BatchQueryStruct{TopK: 1}); err != nil || len(results) != 0 {
+		t.Errorf("empty batch returned %v, %v", results, err)
+	}
+	if _, err := dbSvc.QueryCollectionBatch("missing", BatchQueryStruct{TopK: 1}); !errors.Is(err, ErrCollectionNotFound) {
+		t.Errorf("batch query on a missing collection returned %v, want ErrCollectionNotFound", err)
+	}
+	for _, topK := range []int32{0, -1} {
+		if _, err := dbSvc.QueryCollectionBatch(collName, BatchQueryStruct{TopK: topK, QueryEmbeddings: [][]float32{genEmbeddings(dim)}}); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("batch query with TopK %d returned %v, want ErrInvalidQuery", topK, err)
+		}
+	}
+}
+
+func TestAnalyzer(t *testing.T) {
+	standard, err := lookupAnalyzer("")
+	if err != nil {
+		t.Fatalf("lookupAnalyzer returned error: %v", err)
+	}
+	got := strings.Join(standard.Analyze("The Quick-brown fox, and the lazy DOG's 2nd ball!"), " ")
+	if want := "quick brown fox lazy dog s 2nd ball"; got != want {
+		t.Errorf("standard analyzer produced %q, want %q", got, want)
+	}
+
+	simple, err := lookupAnalyzer(AnalyzerSimple)
+	if err != nil {
+		t.Fatalf("lookupAnalyzer returned error: %v", err)
+	}
+	if got := strings.Join(simple.Analyze("The Fox"), " "); got != "the fox" {
+		t.Errorf("simple analyzer produced %q, want \"the fox\"", got)
+	}
+
+	if got := standard.Analyze("Grüße aus Zürich " + strings.Repeat("x", maxTokenLength+1)); len(got) != 3 || got[0] != "grüße" {
+		t.Errorf("standard analyzer produced %q", got)
+	}
+
+	if _, err := lookupAnalyzer("klingon"); err == nil {
+		t.Errorf("lookupAnalyzer accepted an unknown analyzer")
+	}
+}
+
+func TestSearchText(t *testing.T) {
+	wtService := wiredtiger.WiredTiger()
+
+	if _, err := os.Stat(WIREDTIGER_DIR); os.IsNotExist(err) {
+		if mkErr := os.MkdirAll(WIREDTIGER_DIR, 0755); mkErr != nil {
+			t.Fatalf("failed to create WT_HOME_TEST dir: %v", mkErr)
+		}
+	}
+
+	if err := wtService.Open(WIREDTIGER_DIR, "create"); err != nil {
+		t.Log("Err occured")
+	}
+
+	dbSvc := DatabaseService(DbParams{
+		Name:      "default",
+		KvService: wtService,
+	})
+
+	if err := dbSvc.CreateDB(); err != nil {
+		t.Errorf("Failed to create Db; %s", err)
+	}
+
+	collName := "tenant_id_1"
+	t.Cleanup(func() {
+		if err := wtService.Close(); err != nil {
+			fmt.Printf("Warning: failed to close connection: %v\n", err)
+		}
+		os.Remove("default.tenant_id_1.index")
+		os.RemoveAll("volumes/WT_HOME_TEST")
+	})
+
+	if err := dbSvc.CreateCollection(collName, CollectionOptions{TextAnalyzer: "klingon"}); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("CreateCollection with an unknown analyzer returned %v, want ErrInvalidOptions", err)
+	}
+	if err := dbSvc.CreateCollection(collName); err != nil {
+		t.Fatalf("Failed to create collection: %s", err)
+	}
+
+	contents := []string{
+		"The quick brown fox jumps over the lazy dog",
+		"A fox, a fox, a fox: foxes everywhere and one more fox",
+		"Lazy afternoons with a good book",
+		"Vector databases store embeddings",
+		"The brown bear and the brown fox are friends of the quick otter in a very long story about forests rivers and mountains",
+	}
+	documents := make([]GlowstickDocument, len(contents))
+	for i, content := range contents {
+		documents[i] = GlowstickDocument{
+			ID:        primitive.NewObjectID(),
+			Content:   content,
+			Embedding: genEmbeddings(8),
+			Metadata:  map[string]interface{}{"n": i},
+		}
+	}
+	if err := dbSvc.InsertDocumentsIntoCollection(collName, documents); err != nil {
+		t.Fatalf("InsertDocumentsIntoCollection returned error: %v", err)
+	}
+
+	search := func(text string, filters map[string]interface{}) []string {
+		t.Helper()
+		results, err := dbSvc.SearchText(collName, TextQueryStruct{Text: text, TopK: 10, Filters: filters})
+		if err != nil {
+			t.Fatalf("SearchText(%q) returned error: %v", text, err)
+		}
+		var got []string
+		for i, result := range results {
+			if result.Score <= 0 {
+				t.Errorf("SearchText(%q) returned %q with score %f", text, result.Document.Content, result.Score)
+			}
+			if i > 0 && result.Score > results[i-1].Score {
+				t.Errorf("SearchText(%q) scores are not descending", text)
+			}
+			got = append(got, result.Document.Content)
+		}
+		return got
+	}
+
+	// The document repeating "fox" ranks first, the long one with a single
+	// "fox" last.
+	if got := search("fox", nil); len(got) != 3 || got[0] != contents[1] || got[2] != contents[4] {
+		t.Errorf("search for fox ranked %q", got)
+	}
+	// Any term matches; rarer terms weigh more.
+	if got := search("Lazy embeddings", nil); len(got) != 3 || got[0] != contents[3] {
+		t.Errorf("search for lazy embeddings ranked %q", got)
+	}
+	if got := search("the", nil); len(got) != 0 {
+		t.Errorf("search for a stopword returned %q", got)
+	}
+	if got := search("fox", map[string]interface{}{"n": map[string]interface{}{"$gte": 2}}); len(got) != 1 || got[0] != contents[4] {
+		t.Errorf("filtered search for fox returned %q", got)
+	}
+
+	// Updates and deletes keep the index in step with the documents.
+	updated := documents[3]
+	updated.Content = "A fox in a vector database"
+	if err := dbSvc.UpdateDocument(collName, updated.ID, updated); err != nil {
+		t.Fatalf("UpdateDocument returned error: %v", err)
+	}
+	if got := search("embeddings", nil); len(got) != 0 {
+		t.Errorf("search still finds the replaced content: %q", got)
+	}
+	if got := search("fox", nil); len(got) != 4 {
+		t.Errorf("search for fox after update returned %q", got)
+	}
+
+	if _, err := dbSvc.DeleteDocuments(collName, []primitive.ObjectID{documents[1].ID}); err != nil {
+		t.Fatalf("DeleteDocuments returned error: %v", err)
+	}
+	if got := search("foxes", nil); len(got) != 0 {
+		t.Errorf("search still finds the deleted document: %q", got)
+	}
+
+	if _, err := dbSvc.SearchText("missing", TextQueryStruct{Text: "fox", TopK: 1}); !errors.Is(err, ErrCollectionNotFound) {
+		t.Errorf("SearchText on a missing collection returned %v, want ErrCollectionNotFound", err)
+	}
+}
+
+func TestFuzzySearch(t *testing.T) {
+	wtService := wiredtiger.WiredTiger()
+
+	if _, err := os.Stat(WIREDTIGER_DIR); os.IsNotExist(err) {
+		if mkErr := os.MkdirAll(WIREDTIGER_DIR, 0755); mkErr != nil {
+			t.Fatalf("failed to create WT_HOME_TEST dir: %v", mkErr)
+		}
+	}
+
+	if err := wtService.Open(WIREDTIGER_DIR, "create"); err != nil {
+		t.Log("Err occured")
+	}
+
+	dbSvc := DatabaseService(DbParams{
+		Name:      "default",
+		KvService: wtService,
+	})
+
+	if err := dbSvc.CreateDB(); err != nil {
+		t.Errorf("Failed to create Db; %s", err)
+	}
+
+	collName := "tenant_id_1"
+	t.Cleanup(func() {
+		if err := wtService.Close(); err != nil {
+			fmt.Printf("Warning: failed to close connection: %v\n", err)
+		}
+		os.Remove("default.tenant_id_1.index")
+		os.RemoveAll("volumes/WT_HOME_TEST")
+	})
+
+	if err := dbSvc.CreateCollection(collName); err != nil {
+		t.Fatalf("Failed to create collection: %s", err)
+	}
+
+	// Nothing is indexed yet: fuzzy terms expand to nothing.
+	if results, err := dbSvc.SearchText(collName, TextQueryStruct{Text: "databse", TopK: 10, Fuzziness: 1}); err != nil || len(results) != 0 {
+		t.Errorf("fuzzy search of an empty collection returned %d results, %v", len(results), err)
+	}
+
+	contents := []string{
+		"database engines and storage",
+		"databases for embeddings",
+		"a datastore of images",
+		"storage of vectors",
+	}
+	documents := make([]GlowstickDocument, len(contents))
+	for i, content := range contents {
+		documents[i] = GlowstickDocument{
+			ID:        primitive.NewObjectID(),
+			Content:   content,
+			Embedding: genEmbeddings(8),
+		}
+	}
+	if err := dbSvc.InsertDocumentsIntoCollection(collName, documents); err != nil {
+		t.Fatalf("InsertDocumentsIntoCollection returned error: %v", err)
+	}
+
+	search := func(query TextQueryStruct) []string {
+		t.Helper()
+		query.TopK = 10
+		results, err := dbSvc.SearchText(collName, query)
+		if err != nil {
+			t.Fatalf("SearchText(%+v) returned error: %v", query, err)
+		}
+		var got []string
+		for _, result := range results {
+			got = append(got, result.Document.Content)
+		}
+		return got
+	}
+
+	// Exact search does not forgive typos.
+	if got := search(TextQueryStruct{Text: "databse"}); len(got) != 0 {
+		t.Errorf("exact search for databse returned %q", got)
+	}
+	// One edit away from "database" only.
+	if got := search(TextQueryStruct{Text: "databse", Fuzziness: 1}); len(got) != 1 || got[0] != contents[0] {
+		t.Errorf("fuzzy search for databse with 1 edit returned %q", got)
+	}
+	// Two edits reach "databases" too, which ranks below the closer term.
+	if got := search(TextQueryStruct{Text: "databse", Fuzziness: 2}); len(got) != 2 || got[0] != contents[0] || got[1] != contents[1] {
+		t.Errorf("fuzzy search for databse with 2 edits returned %q", got)
+	}
+	// An exact match outranks one with an edit.
+	if got := search(TextQueryStruct{Text: "databases", Fuzziness: 1}); len(got) != 2 || got[0] != contents[1] {
+		t.Errorf("fuzzy search for databases returned %q", got)
+	}
+
+	// Prefix matching, with and without typos.
+	if got := search(TextQueryStruct{Text: "data", Prefix: true}); len(got) != 3 {
+		t.Errorf("prefix search for data returned %q", got)
+	}
+	if got := search(TextQueryStruct{Text: "stor", Prefix: true}); len(got) != 2 {
+		t.Errorf("prefix search for stor returned %q", got)
+	}
+	if got := search(TextQueryStruct{Text: "embd", Prefix: true, Fuzziness: 1}); len(got) != 1 || got[0] != contents[1] {
+		t.Errorf("fuzzy prefix search for embd returned %q", got)
+	}
+
+	// SearchNear may land on the dictionary term before a probe, leaving
+	// seekText to scan forward to the next one.
+	scanning := DatabaseService(DbParams{Name: "default", KvService: searchNearBeforeService{wtService}})
+	for _, query := range []TextQueryStruct{
+		{Text: "databse", TopK: 10, Fuzziness: 1},
+		{Text: "stor", TopK: 10, Prefix: true},
+		{Text: "embd", TopK: 10, Prefix: true, Fuzziness: 1},
+	} {
+		want := search(query)
+		results, err := scanning.SearchText(collName, query)
+		if err != nil || len(results) != len(want) {
+			t.Errorf("SearchText(%+v) stepping past the probe returned %d results, %v; want %q", query, len(results), err, want)
+		}
+	}
+
+	for _, fuzziness := range []int{-1, maxFuzzyEdits + 1} {
+		if _, err := dbSvc.SearchText(collName, TextQueryStruct{Text: "data", TopK: 10, Fuzziness: fuzziness}); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("SearchText with fuzziness %d returned %v, want ErrInvalidQuery", fuzziness, err)
+		}
+	}
+	for _, topK := range []int32{0, -1} {
+		if _, err := dbSvc.SearchText(collName, TextQueryStruct{Text: "data", TopK: topK}); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("SearchText with TopK %d returned %v, want ErrInvalidQuery", topK, err)
+		}
+	}
+
+	// Deleting the only document with a term removes it from the dictionary.
+	if _, err := dbSvc.DeleteDocuments(collName, []primitive.ObjectID{documents[2].ID}); err != nil {
+		t.Fatalf("DeleteDocuments returned error: %v", err)
+	}
+	collection, _, err := dbSvc.(*GDBService).getCollection(collName)
+	if err != nil {
+		t.Fatalf("getCollection returned error: %v", err)
+	}
+	if found, err := wtService.ExistsBinary(collection.TextIndexUri, textDictionaryKey("datastore")); err != nil || found {
+		t.Errorf("dictionary still has datastore after delete: %v, %v", found, err)
+	}
+	if got := search(TextQueryStruct{Text: "data", Prefix: true}); len(got) != 2 {
+		t.Errorf("prefix search for data after delete returned %q", got)
+	}
+}
+
+func TestHybridQuery(t *testing.T) {
+	wtService := wiredtiger.WiredTiger()
+
+	if _, err := os.Stat(WIREDTIGER_DIR); os.IsNotExist(err) {
+		if mkErr := os.MkdirAll(WIREDTIGER_DIR, 0755); mkErr != nil {
+			t.Fatalf("failed to create WT_HOME_TEST dir: %v", mkErr)
+		}
+	}
+
+	if err := wtService.Open(WIREDTIGER_DIR, "create"); err != nil {
+		t.Log("Err occured")
+	}
+
+	dbSvc := DatabaseService(DbParams{
+		Name:      "default",
+		KvService: wtService,
+	})
+
+	if err := dbSvc.CreateDB(); err != nil {
+		t.Errorf("Failed to create Db; %s", err)
+	}
+
+	collName := "tenant_id_1"
+	t.Cleanup(func() {
+		if err := wtService.Close(); err != nil {
+			fmt.Printf("Warning: failed to close connection: %v\n", err)
+		}
+		os.Remove("default.tenant_id_1.index")
+		os.RemoveAll("volumes/WT_HOME_TEST")
+	})
+
+	if err := dbSvc.CreateCollection(collName); err != nil {
+		t.Fatalf("Failed to create collection: %s", err)
+	}
+
+	const dim = 8
+	axis := func(values map[int]float32) []float32 {
+		v := make([]float32, dim)
+		for i, value := range values {
+			v[i] = value
+		}
+		return v
+	}
+	query := axis(map[int]float32{0: 1})
+
+	// "vector" is the query itself, "keyword" matches both query terms but
+	// points away from it, and "both" is close to the query and matches one
+	// term. The fillers sit between them in vector space only.
+	documents := []GlowstickDocument{
+		{ID: primitive.NewObjectID(), Content: "nothing to see here", Embedding: query},
+		{ID: primitive.NewObjectID(), Content: "glowstick database internals", Embedding: axis(map[int]float32{7: 1})},
+		{ID: primitive.NewObjectID(), Content: "a glowstick at night", Embedding: axis(map[int]float32{0: 0.8, 1: 0.6})},
+	}
+	for i := 2; i <= 4; i++ {
+		documents = append(documents, GlowstickDocument{ID: primitive.NewObjectID(), Content: "unrelated filler", Embedding: axis(map[int]float32{0: 0.5, i: 0.866})})
+	}
+	if err := dbSvc.InsertDocumentsIntoCollection(collName, documents); err != nil {
+		t.Fatalf("InsertDocumentsIntoCollection returned error: %v", err)
+	}
+	vector, keyword, both := documents[0].Content, documents[1].Content, documents[2].Content
+
+	run := func(q QueryStruct) []QueryResult {
+		t.Helper()
+		results, err := dbSvc.QueryCollection(collName, q)
+		if err != nil {
+			t.Fatalf("hybrid query %+v returned error: %v", q, err)
+		}
+		return results
+	}
+	contents := func(results []QueryResult) []string {
+		var got []string
+		for _, result := range results {
+			got = append(got, result.Document.Content)
+		}
+		return got
+	}
+
+	// Reciprocal rank fusion favours the document both rankings agree on.
+	results := run(QueryStruct{TopK: 3, QueryEmbedding: query, QueryText: "glowstick database"})
+	if got := contents(results); strings.Join(got, "|") != strings.Join([]string{both, keyword, vector}, "|") {
+		t.Errorf("RRF ranked %q", got)
+	}
+	for _, result := range results {
+		if result.Label < 0 {
+			t.Errorf("hybrid result %q has no label", result.Document.Content)
+		}
+	}
+	if r := results[0]; r.TextScore <= 0 || r.VectorScore < 0.7 || r.VectorScore > 0.72 {
+		t.Errorf("RRF result %q has vector score %f and text score %f", r.Document.Content, r.VectorScore, r.TextScore)
+	}
+	if r := results[1]; r.TextScore <= results[0].TextScore || r.VectorScore <= 0 {
+		t.Errorf("keyword-only match has vector score %f and text score %f", r.VectorScore, r.TextScore)
+	}
+	if r := results[2]; r.TextScore != 0 || r.VectorScore < 0.99 {
+		t.Errorf("vector-only match has vector score %f and text score %f", r.VectorScore, r.TextScore)
+	}
+
+	// With TopK 1 only four vector candidates are drawn, which leaves out
+	// "keyword"; "both" still wins.
+	if got := contents(run(QueryStruct{TopK: 1, QueryEmbedding: query, QueryText: "glowstick database"})); len(got) != 1 || got[0] != both {
+		t.Errorf("RRF with TopK 1 returned %q", got)
+	}
+
+	// Weighted blending leans on whichever component carries the weight.
+	if got := contents(run(QueryStruct{TopK: 1, QueryEmbedding: query, QueryText: "glowstick database", Fusion: FusionWeighted, VectorWeight: 1})); len(got) != 1 || got[0] != vector {
+		t.Errorf("vector-weighted fusion returned %q", got)
+	}
+	if got := contents(run(QueryStruct{TopK: 1, QueryEmbedding: query, QueryText: "glowstick database", Fusion: FusionWeighted, VectorWeight: 0.01})); len(got) != 1 || got[0] != keyword {
+		t.Errorf("text-weighted fusion returned %q", got)
+	}
+
+	// Without an embedding only the keyword ranking contributes.
+	if got := contents(run(QueryStruct{TopK: 5, QueryText: "glowstick"})); len(got) != 2 {
+		t.Errorf("keyword-only hybrid query returned %q", got)
+	}
+
+	for _, q := range []QueryStruct{
+		{TopK: 1, QueryEmbedding: query, QueryText: "glowstick", Fusion: "borda"},
+		{TopK: 1, QueryEmbedding: query, QueryText: "glowstick", Fusion: FusionWeighted, VectorWeight: 2},
+		{TopK: 0, QueryEmbedding: query, QueryText: "glowstick"},
+		{TopK: -1, QueryText: "glowstick"},
+	} {
+		if _, err := dbSvc.QueryCollection(collName, q); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("hybrid query %+v returned %v, want ErrInvalidQuery", q, err)
+		}
+	}
+}
+
 func genEmbeddings(dim int) []float32 {
 	fs := faiss.FAISS()
 	randVec := make([]float32, dim)
 	for i := 0; i < dim; i++ {
 		randVec[i] = rand.Float32()
 	}
-	return fs.NormalizeBatch(randVec, dim)
+	return fs.NormalizeBatch(randVec, dim)
+}
+
+func TestEmbedder(t *testing.T) {
+	wtService := wiredtiger.WiredTiger()
+
+	if _, err := os.Stat(WIREDTIGER_DIR); os.IsNotExist(err) {
+		if mkErr := os.MkdirAll(WIREDTIGER_DIR, 0755); mkErr != nil {
+			t.Fatalf("failed to create WT_HOME_TEST dir: %v", mkErr)
+		}
+	}
+
+	if err := wtService.Open(WIREDTIGER_DIR, "create"); err != nil {
+		t.Log("Err occured")
+	}
+
+	dbSvc := DatabaseService(DbParams{
+		Name:      "default",
+		KvService: wtService,
+	})
+
+	if err := dbSvc.CreateDB(); err != nil {
+		t.Errorf("Failed to create Db; %s", err)
+	}
+
+	collName := "tenant_id_1"
+	t.Cleanup(func() {
+		if err := wtService.Close(); err != nil {
+			fmt.Printf("Warning: failed to close connection: %v\n", err)
+		}
+		os.Remove("default.tenant_id_1.index")
+		os.Remove("default.remote.index")
+		os.RemoveAll("volumes/WT_HOME_TEST")
+	})
+
+	for _, config := range []embedder.Config{
+		{Provider: "word2vec"},
+		{Provider: embedder.ProviderHash, Dimension: -1},
+		{Provider: embedder.ProviderOpenAI, APIKeyEnv: "GLOWSTICK_TEST_UNSET_KEY"},
+	} {
+		if err := dbSvc.CreateCollection(collName, CollectionOptions{Embedder: &config}); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("CreateCollection with embedder %+v returned %v, want ErrInvalidOptions", config, err)
+		}
+	}
+	if err := dbSvc.CreateCollection(collName, CollectionOptions{Dimension: 32, Embedder: &embedder.Config{Provider: embedder.ProviderHash, Dimension: 64}}); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("CreateCollection with mismatched dimensions returned %v, want ErrInvalidOptions", err)
+	}
+
+	// The embedder's dimension becomes the collection's.
+	if err := dbSvc.CreateCollection(collName, CollectionOptions{Metric: MetricCosine, Embedder: &embedder.Config{Provider: embedder.ProviderHash, Dimension: 64}}); err != nil {
+		t.Fatalf("Failed to create collection: %s", err)
+	}
+	collections, err := dbSvc.ListCollections()
+	if err != nil || len(collections) != 1 || collections[0].VectorIndex.Dimension != 64 || collections[0].Embedder == nil {
+		t.Fatalf("ListCollections returned %+v, %v", collections, err)
+	}
+
+	contents := []string{
+		"the quick brown fox",
+		"vector databases store embeddings",
+		"lazy afternoons reading books",
+	}
+	documents := make([]GlowstickDocument, len(contents))
+	for i, content := range contents {
+		documents[i] = GlowstickDocument{ID: primitive.NewObjectID(), Content: content}
+	}
+	if err := dbSvc.InsertDocumentsIntoCollection(collName, documents); err != nil {
+		t.Fatalf("InsertDocumentsIntoCollection returned error: %v", err)
+	}
+	if len(documents[0].Embedding) != 0 {
+		t.Errorf("InsertDocumentsIntoCollection modified the caller's documents")
+	}
+
+	stored, found, err := dbSvc.GetDocument(collName, documents[0].ID)
+	if err != nil || !found {
+		t.Fatalf("GetDocument returned %v, %v", found, err)
+	}
+	want, _ := embedder.NewHash(64).Embed([]string{contents[0]})
+	if !embeddingsEqual(stored.Embedding, want[0]) {
+		t.Errorf("stored embedding differs from the hash embedding of its content")
+	}
+
+	// A document that brings its own embedding keeps it.
+	own := genEmbeddings(64)
+	ownID := primitive.NewObjectID()
+	if err := dbSvc.InsertDocumentsIntoCollection(collName, []GlowstickDocument{{ID: ownID, Content: "precomputed", Embedding: own}}); err != nil {
+		t.Fatalf("InsertDocumentsIntoCollection returned error: %v", err)
+	}
+	if stored, _, _ := dbSvc.GetDocument(collName, ownID); !embeddingsEqual(stored.Embedding, own) {
+		t.Errorf("caller-provided embedding was replaced")
+	}
+
+	results, err := dbSvc.QueryCollection(collName, QueryStruct{TopK: 1, QueryContent: "brown fox"})
+	if err != nil || len(results) != 1 || results[0].Document.Content != contents[0] {
+		t.Errorf("QueryCollection by content returned %+v, %v", results, err)
+	}
+
+	batch, err := dbSvc.QueryCollectionBatch(collName, BatchQueryStruct{TopK: 1, QueryContents: []string{"embeddings databases", "reading books"}})
+	if err != nil || len(batch) != 2 {
+		t.Fatalf("QueryCollectionBatch by content returned %+v, %v", batch, err)
+	}
+	for i, want := range []string{contents[1], contents[2]} {
+		if len(batch[i]) != 1 || batch[i][0].Document.Content != want {
+			t.Errorf("QueryCollectionBatch query %d returned %+v, want %q", i, batch[i], want)
+		}
+	}
+
+	// An OpenAI-compatible server, answering out of order.
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path != "/v1/embeddings" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, `{"error":{"message":"unauthorized"}}`, http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Model != "test-model" {
+			http.Error(w, `{"error":{"message":"bad request"}}`, http.StatusBadRequest)
+			return
+		}
+		type item struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		}
+		var data []item
+		for i := len(req.Input) - 1; i >= 0; i-- {
+			data = append(data, item{Index: i, Embedding: []float32{float32(len(req.Input[i])), 1, 0, 0}})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	defer server.Close()
+
+	t.Setenv("GLOWSTICK_TEST_EMBEDDING_KEY", "secret")
+	remote := embedder.Config{Provider: embedder.ProviderOpenAI, BaseURL: server.URL + "/v1", Model: "test-model", APIKeyEnv: "GLOWSTICK_TEST_EMBEDDING_KEY"}
+	if err := dbSvc.CreateCollection("remote", CollectionOptions{Embedder: &remote}); err != nil {
+		t.Fatalf("Failed to create collection: %s", err)
+	}
+	remoteDocs := []GlowstickDocument{
+		{ID: primitive.NewObjectID(), Content: "a"},
+		{ID: primitive.NewObjectID(), Content: "abcdef"},
+	}
+	if err := dbSvc.InsertDocumentsIntoCollection("remote", remoteDocs); err != nil {
+		t.Fatalf("InsertDocumentsIntoCollection returned error: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("a batch of documents took %d embedding requests, want 1", calls)
+	}
+	for _, doc := range remoteDocs {
+		stored, _, err := dbSvc.GetDocument("remote", doc.ID)
+		if err != nil || len(stored.Embedding) != 4 || stored.Embedding[0] != float32(len(doc.Content)) {
+			t.Errorf("document %q was stored with embedding %v, %v", doc.Content, stored.Embedding, err)
+		}
+	}
+
+	t.Setenv("GLOWSTICK_TEST_EMBEDDING_KEY", "wrong")
+	if _, err := dbSvc.QueryCollection("remote", QueryStruct{TopK: 1, QueryContent: "abcdef"}); !errors.Is(err, ErrEmbeddingFailed) {
+		t.Errorf("QueryCollection with a rejected API key returned %v, want ErrEmbeddingFailed", err)
+	}
+
+	// Collections without an embedder need embeddings.
+	if err := dbSvc.DropCollection("remote"); err != nil {
+		t.Fatalf("DropCollection returned error: %v", err)
+	}
+	if err := dbSvc.CreateCollection("remote"); err != nil {
+		t.Fatalf("Failed to create collection: %s", err)
+	}
+	if err := dbSvc.InsertDocumentsIntoCollection("remote", []GlowstickDocument{{ID: primitive.NewObjectID(), Content: "text only"}}); !errors.Is(err, ErrNoEmbedder) {
+		t.Errorf("InsertDocumentsIntoCollection without an embedder returned %v, want ErrNoEmbedder", err)
+	}
+	if _, err := dbSvc.QueryCollection("remote", QueryStruct{TopK: 1, QueryContent: "text"}); !errors.Is(err, ErrNoEmbedder) {
+		t.Errorf("QueryCollection without an embedder returned %v, want ErrNoEmbedder", err)
+	}
+}
+
+func TestDocumentValidation(t *testing.T) {
+	wtService := wiredtiger.WiredTiger()
+
+	if _, err := os.Stat(WIREDTIGER_DIR); os.IsNotExist(err) {
+		if mkErr := os.MkdirAll(WIREDTIGER_DIR, 0755); mkErr != nil {
+			t.Fatalf("failed to create WT_HOME_TEST dir: %v", mkErr)
+		}
+	}
+
+	if err := wtService.Open(WIREDTIGER_DIR, "create"); err != nil {
+		t.Log("Err occured")
+	}
+
+	dbSvc := DatabaseService(DbParams{
+		Name:      "default",
+		KvService: wtService,
+	})
+
+	if err := dbSvc.CreateDB(); err != nil {
+		t.Errorf("Failed to create Db; %s", err)
+	}
+
+	collName := "tenant_id_1"
+	t.Cleanup(func() {
+		if err := wtService.Close(); err != nil {
+			fmt.Printf("Warning: failed to close connection: %v\n", err)
+		}
+		os.Remove("default.tenant_id_1.index")
+		os.RemoveAll("volumes/WT_HOME_TEST")
+	})
+
+	if err := dbSvc.CreateCollection(collName); err != nil {
+		t.Fatalf("Failed to create collection: %s", err)
+	}
+
+	dimension := func() int {
+		t.Helper()
+		collections, err := dbSvc.ListCollections()
+		if err != nil || len(collections) != 1 {
+			t.Fatalf("ListCollections returned %+v, %v", collections, err)
+		}
+		return collections[0].VectorIndex.Dimension
+	}
+	docCount := func() int {
+		t.Helper()
+		stats, err := dbSvc.GetCollectionStats(collName)
+		if err != nil {
+			t.Fatalf("GetCollectionStats returned error: %v", err)
+		}
+		return stats.Doc_Count
+	}
+
+	// An empty batch is a no-op rather than a panic.
+	if err := dbSvc.InsertDocumentsIntoCollection(collName, nil); err != nil {
+		t.Errorf("InsertDocumentsIntoCollection(nil) returned %v", err)
+	}
+	if got := dimension(); got != 0 {
+		t.Errorf("empty batch set the dimension to %d", got)
+	}
+
+	nan := genEmbeddings(8)
+	nan[3] = float32(math.NaN())
+	invalid := []GlowstickDocument{
+		{ID: primitive.NewObjectID(), Embedding: genEmbeddings(8)},
+		{ID: primitive.NewObjectID(), Embedding: genEmbeddings(4)},
+		{ID: primitive.NewObjectID(), Embedding: genEmbeddings(8)},
+		{ID: primitive.NewObjectID(), Embedding: nan},
+		{ID: primitive.NewObjectID()},
+	}
+	err := dbSvc.InsertDocumentsIntoCollection(collName, invalid)
+	if !errors.Is(err, ErrInvalidDocument) {
+		t.Fatalf("InsertDocumentsIntoCollection with invalid documents returned %v, want ErrInvalidDocument", err)
+	}
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("InsertDocumentsIntoCollection returned %T, want *BatchError", err)
+	}
+	if batchErr.Total != len(invalid) || len(batchErr.Documents) != 3 {
+		t.Fatalf("BatchError lists %d of %d documents: %v", len(batchErr.Documents), batchErr.Total, batchErr)
+	}
+	for i, index := range []int{1, 3, 4} {
+		if got := batchErr.Documents[i]; got.Index != index || got.Id != invalid[index].ID {
+			t.Errorf("BatchError.Documents[%d] = %+v, want documents[%d]", i, got, index)
+		}
+	}
+
+	// Nothing from a rejected batch is written, not even the dimension.
+	if got := docCount(); got != 0 {
+		t.Errorf("rejected batch wrote %d documents", got)
+	}
+	if got := dimension(); got != 0 {
+		t.Errorf("rejected batch set the dimension to %d", got)
+	}
+
+	if err := dbSvc.InsertDocumentsIntoCollection(collName, []GlowstickDocument{
+		{ID: primitive.NewObjectID(), Embedding: genEmbeddings(8)},
+		{ID: primitive.NewObjectID(), Embedding: genEmbeddings(8)},
+	}); err != nil {
+		t.Fatalf("InsertDocumentsIntoCollection returned error: %v", err)
+	}
+	if got := dimension(); got != 8 {
+		t.Errorf("dimension is %d after the first batch, want 8", got)
+	}
+
+	// Once fixed, the dimension applies to every later write and query.
+	id := primitive.NewObjectID()
+	if err := dbSvc.InsertDocumentsIntoCollection(collName, []GlowstickDocument{{ID: id, Embedding: genEmbeddings(16)}}); !errors.Is(err, ErrInvalidDocument) {
+		t.Errorf("insert with another dimension returned %v, want ErrInvalidDocument", err)
+	}
+	if err := dbSvc.UpsertDocuments(collName, []GlowstickDocument{{ID: id, Embedding: genEmbeddings(16)}}); !errors.Is(err, ErrInvalidDocument) {
+		t.Errorf("upsert with another dimension returned %v, want ErrInvalidDocument", err)
+	}
+	if got := docCount(); got != 2 {
+		t.Errorf("doc count is %d, want 2", got)
+	}
+
+	if _, err := dbSvc.QueryCollection(collName, QueryStruct{TopK: 1, QueryEmbedding: genEmbeddings(16)}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("query with another dimension returned %v, want ErrInvalidQuery", err)
+	}
+	if _, err := dbSvc.QueryCollection(collName, QueryStruct{TopK: 1}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("query without an embedding returned %v, want ErrInvalidQuery", err)
+	}
+	for _, topK := range []int32{0, -1} {
+		if _, err := dbSvc.QueryCollection(collName, QueryStruct{TopK: topK, QueryEmbedding: genEmbeddings(8)}); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("query with TopK %d returned %v, want ErrInvalidQuery", topK, err)
+		}
+	}
+	if results, err := dbSvc.QueryCollection(collName, QueryStruct{TopK: 1, QueryEmbedding: genEmbeddings(8)}); err != nil || len(results) != 1 {
+		t.Errorf("valid query returned %d results, %v", len(results), err)
+	}
+}
+
+func TestCollectionSchema(t *testing.T) {
+	wtService := wiredtiger.WiredTiger()
+
+	if _, err := os.Stat(WIREDTIGER_DIR); os.IsNotExist(err) {
+		if mkErr := os.MkdirAll(WIREDTIGER_DIR, 0755); mkErr != nil {
+			t.Fatalf("failed to create WT_HOME_TEST dir: %v", mkErr)
+		}
+	}
+
+	if err := wtService.Open(WIREDTIGER_DIR, "create"); err != nil {
+		t.Log("Err occured")
+	}
+
+	dbSvc := DatabaseService(DbParams{
+		Name:      "default",
+		KvService: wtService,
+	})
+
+	if err := dbSvc.CreateDB(); err != nil {
+		t.Errorf("Failed to create Db; %s", err)
+	}
+
+	collName := "tenant_id_1"
+	t.Cleanup(func() {
+		if err := wtService.Close(); err != nil {
+			fmt.Printf("Warning: failed to close connection: %v\n", err)
+		}
+		os.Remove("default.tenant_id_1.index")
+		os.RemoveAll("volumes/WT_HOME_TEST")
+	})
+
+	negative := -1
+	for _, schema := range []*bsonvalidator.Schema{
+		{Type: "text"},
+		{Properties: map[string]*bsonvalidator.Schema{"metadata": {Properties: map[string]*bsonvalidator.Schema{"tags": {MaxItems: &negative}}}}},
+	} {
+		if err := dbSvc.CreateCollection(collName, CollectionOptions{Schema: schema}); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("CreateCollection with schema %+v returned %v, want ErrInvalidOptions", schema, err)
+		}
+	}
+
+	var schema bsonvalidator.Schema
+	if err := json.Unmarshal([]byte(`{
+		"type": "object",
+		"required": ["content", "metadata"],
+		"properties": {
+			"content": {"type": "string", "minLength": 1, "maxLength": 64},
+			"metadata": {
+				"type": "object",
+				"required": ["year"],
+				"additionalProperties": false,
+				"properties": {
+					"year": {"type": "integer", "minimum": 1900, "maximum": 2100},
+					"tags": {"type": "array", "maxItems": 3, "items": {"type": "string"}},
+					"author": {"type": "object", "required": ["name"], "properties": {"name": {"type": "string"}}}
+				}
+			}
+		}
+	}`), &schema); err != nil {
+		t.Fatalf("failed to decode schema: %v", err)
+	}
+	if err := dbSvc.CreateCollection(collName, CollectionOptions{Schema: &schema}); err != nil {
+		t.Fatalf("Failed to create collection: %s", err)
+	}
+
+	valid := GlowstickDocument{
+		ID:        primitive.NewObjectID(),
+		Content:   "a valid document",
+		Embedding: genEmbeddings(8),
+		Metadata:  map[string]interface{}{"year": 2024, "tags": []string{"a", "b"}, "author": map[string]interface{}{"name": "x"}},
+	}
+	if err := dbSvc.InsertDocumentsIntoCollection(collName, []GlowstickDocument{valid}); err != nil {
+		t.Fatalf("InsertDocumentsIntoCollection with a valid document returned error: %v", err)
+	}
+
+	invalid := []GlowstickDocument{
+		{ID: primitive.NewObjectID(), Content: "ok", Embedding: genEmbeddings(8), Metadata: map[string]interface{}{"year": 1850}},
+		{ID: primitive.NewObjectID(), Content: "ok", Embedding: genEmbeddings(8), Metadata: map[string]interface{}{"year": 2000}},
+		{ID: primitive.NewObjectID(), Content: "ok", Embedding: genEmbeddings(8), Metadata: map[string]interface{}{"year": 2000, "tags": []interface{}{"a", 7}}},
+		{ID: primitive.NewObjectID(), Content: "ok", Embedding: genEmbeddings(8), Metadata: map[string]interface{}{"year": 2000, "author": map[string]interface{}{}, "color": "red"}},
+		{ID: primitive.NewObjectID(), Embedding: genEmbeddings(8), Metadata: map[string]interface{}{"year": 2000.5}},
+	}
+	err := dbSvc.InsertDocumentsIntoCollection(collName, invalid)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("InsertDocumentsIntoCollection with invalid documents returned %v, want a *BatchError", err)
+	}
+
+	// Every violation is reported with the path of the offending value.
+	want := map[int][]string{
+		0: {"metadata.year: must be at least 1900"},
+		2: {"metadata.tags[1]: must be of type string"},
+		3: {"metadata.author.name: is required", "metadata.color: is not allowed"},
+		4: {"content: must be at least 1 characters long", "metadata.year: must be of type integer"},
+	}
+	if len(batchErr.Documents) != len(want) {
+		t.Fatalf("BatchError lists %d documents, want %d: %v", len(batchErr.Documents), len(want), batchErr)
+	}
+	for _, docErr := range batchErr.Documents {
+		messages, ok := want[docErr.Index]
+		if !ok {
+			t.Errorf("documents[%d] was rejected: %v", docErr.Index, docErr.Err)
+			continue
+		}
+		var validationErr *bsonvalidator.ValidationError
+		if !errors.As(docErr, &validationErr) || len(validationErr.Fields) != len(messages) {
+			t.Errorf("documents[%d] error %v does not list %d fields", docErr.Index, docErr.Err, len(messages))
+		}
+		for _, message := range messages {
+			if !strings.Contains(docErr.Error(), message) {
+				t.Errorf("documents[%d] error %q does not mention %q", docErr.Index, docErr.Error(), message)
+			}
+		}
+	}
+	if stats, err := dbSvc.GetCollectionStats(collName); err != nil || stats.Doc_Count != 1 {
+		t.Errorf("doc count after a rejected batch is %d, %v; want 1", stats.Doc_Count, err)
+	}
+
+	// Updates are held to the schema too.
+	update := valid
+	update.Metadata = map[string]interface{}{"year": "2024"}
+	if err := dbSvc.UpdateDocument(collName, valid.ID, update); !errors.Is(err, ErrInvalidDocument) {
+		t.Errorf("UpdateDocument with an invalid document returned %v, want ErrInvalidDocument", err)
+	}
+	stored, _, err := dbSvc.GetDocument(collName, valid.ID)
+	if err != nil || stored.Content != valid.Content {
+		t.Errorf("GetDocument after a rejected update returned %+v, %v", stored, err)
+	}
+
+	update.Metadata = map[string]interface{}{"year": 1999}
+	if err := dbSvc.UpdateDocument(collName, valid.ID, update); err != nil {
+		t.Errorf("UpdateDocument with a valid document returned error: %v", err)
+	}
+}
+
+func TestDocumentIDs(t *testing.T) {
+	wtService := wiredtiger.WiredTiger()
+
+	if _, err := os.Stat(WIREDTIGER_DIR); os.IsNotExist(err) {
+		if mkErr := os.MkdirAll(WIREDTIGER_DIR, 0755); mkErr != nil {
+			t.Fatalf("failed to create WT_HOME_TEST dir: %v", mkErr)
+		}
+	}
+
+	if err := wtService.Open(WIREDTIGER_DIR, "create"); err != nil {
+		t.Log("Err occured")
+	}
+
+	dbSvc := DatabaseService(DbParams{
+		Name:      "default",
+		KvService: wtService,
+	})
+
+	if err := dbSvc.CreateDB(); err != nil {
+		t.Errorf("Failed to create Db; %s", err)
+	}
+
+	collName := "tenant_id_1"
+	t.Cleanup(func() {
+		if err := wtService.Close(); err != nil {
+			fmt.Printf("Warning: failed to close connection: %v\n", err)
+		}
+		os.Remove("default.tenant_id_1.index")
+		os.RemoveAll("volumes/WT_HOME_TEST")
+	})
+
+	if err := dbSvc.CreateCollection(collName, CollectionOptions{Dimension: 3}); err != nil {
+		t.Fatalf("CreateCollection returned error: %v", err)
+	}
+
+	// Documents without an ID get one, written back into the caller's slice,
+	// and are stored under it rather than overwriting each other.
+	documents := []GlowstickDocument{
+		{Content: "first", Embedding: []float32{1, 0, 0}},
+		{Content: "second", Embedding: []float32{0, 1, 0}},
+		{Key: "doc-3", Content: "third", Embedding: []float32{0, 0, 1}},
+	}
+	if err := dbSvc.InsertDocumentsIntoCollection(collName, documents); err != nil {
+		t.Fatalf("InsertDocumentsIntoCollection returned error: %v", err)
+	}
+	seen := map[primitive.ObjectID]bool{}
+	for i, doc := range documents {
+		if doc.ID.IsZero() || seen[doc.ID] {
+			t.Fatalf("documents[%d] was assigned _id %s, want a fresh ObjectID", i, doc.ID.Hex())
+		}
+		seen[doc.ID] = true
+
+		stored, found, err := dbSvc.GetDocument(collName, doc.ID)
+		if err != nil || !found || stored.ID != doc.ID || stored.Content != doc.Content || stored.Key != doc.Key {
+			t.Errorf("GetDocument(%s) returned %+v, %t, %v", doc.ID.Hex(), stored, found, err)
+		}
+	}
+	if stats, err := dbSvc.GetCollectionStats(collName); err != nil || stats.Doc_Count != 3 {
+		t.Errorf("doc count is %d, %v; want 3", stats.Doc_Count, err)
+	}
+
+	// The _id is part of the stored BSON document.
+	collections, err := dbSvc.ListCollections()
+	if err != nil || len(collections) != 1 {
+		t.Fatalf("ListCollections returned %v, %v", collections, err)
+	}
+	record, _, err := wtService.GetBinary(collections[0].TableUri, documents[0].ID[:])
+	if err != nil {
+		t.Fatalf("failed to read stored document: %v", err)
+	}
+	if storedID, ok := bson.Raw(record).Lookup("_id").ObjectIDOK(); !ok || storedID != documents[0].ID {
+		t.Errorf("stored document has _id %v, want %s", bson.Raw(record).Lookup("_id"), documents[0].ID.Hex())
+	}
+
+	if id, found, err := dbSvc.ResolveKey(collName, "doc-3"); err != nil || !found || id != documents[2].ID {
+		t.Errorf("ResolveKey(doc-3) returned %s, %t, %v; want %s", id.Hex(), found, err, documents[2].ID.Hex())
+	}
+	if _, found, err := dbSvc.ResolveKey(collName, "missing"); err != nil || found {
+		t.Errorf("ResolveKey(missing) returned %t, %v; want not found", found, err)
+	}
+
+	// Inserts reject IDs and keys that are taken, in the collection or the
+	// batch, and write nothing.
+	for name, batch := range map[string][]GlowstickDocument{
+		"existing _id": {{ID: documents[0].ID, Content: "again", Embedding: []float32{1, 1, 0}}},
+		"existing key": {{Key: "doc-3", Content: "again", Embedding: []float32{1, 1, 0}}},
+		"repeated _id": {{ID: primitive.NewObjectID(), Embedding: []float32{1, 1, 0}}, {Embedding: []float32{0, 1, 1}}},
+		"repeated key": {{Key: "doc-4", Embedding: []float32{1, 1, 0}}, {Key: "doc-4", Embedding: []float32{0, 1, 1}}},
+	} {
+		if name == "repeated _id" {
+			batch[1].ID = batch[0].ID
+		}
+		err := dbSvc.InsertDocumentsIntoCollection(collName, batch)
+		var batchErr *BatchError
+		if !errors.Is(err, ErrDuplicateKey) || !errors.As(err, &batchErr) || len(batchErr.Documents) != 1 {
+			t.Errorf("insert with %s returned %v, want one ErrDuplicateKey", name, err)
+		}
+	}
+	if stats, err := dbSvc.GetCollectionStats(collName); err != nil || stats.Doc_Count != 3 {
+		t.Errorf("doc count after rejected inserts is %d, %v; want 3", stats.Doc_Count, err)
+	}
+	if _, found, _ := dbSvc.ResolveKey(collName, "doc-4"); found {
+		t.Errorf("key doc-4 was recorded by a rejected insert")
+	}
+
+	// An upsert by key replaces the document holding it.
+	upsert := []GlowstickDocument{{Key: "doc-3", Content: "third, revised", Embedding: []float32{0, 0, 1}}}
+	if err := dbSvc.UpsertDocuments(collName, upsert); err != nil {
+		t.Fatalf("UpsertDocuments returned error: %v", err)
+	}
+	if upsert[0].ID != documents[2].ID {
+		t.Errorf("upsert by key was assigned _id %s, want %s", upsert[0].ID.Hex(), documents[2].ID.Hex())
+	}
+	if stored, _, err := dbSvc.GetDocument(collName, documents[2].ID); err != nil || stored.Content != "third, revised" {
+		t.Errorf("GetDocument after upsert returned %+v, %v", stored, err)
+	}
+
+	// A key cannot move onto another document, but an update can change a
+	// document's own key, releasing the old one.
+	update := documents[0]
+	update.Key = "doc-3"
+	if err := dbSvc.UpdateDocument(collName, update.ID, update); !errors.Is(err, ErrDuplicateKey) {
+		t.Errorf("UpdateDocument taking another document's key returned %v, want ErrDuplicateKey", err)
+	}
+	update = documents[2]
+	update.Key = "doc-3b"
+	if err := dbSvc.UpdateDocument(collName, update.ID, update); err != nil {
+		t.Fatalf("UpdateDocument returned error: %v", err)
+	}
+	if _, found, _ := dbSvc.ResolveKey(collName, "doc-3"); found {
+		t.Errorf("old key doc-3 still resolves after the update")
+	}
+	if id, found, _ := dbSvc.ResolveKey(collName, "doc-3b"); !found || id != documents[2].ID {
+		t.Errorf("new key doc-3b resolves to %s, %t; want %s", id.Hex(), found, documents[2].ID.Hex())
+	}
+
+	if deleted, err := dbSvc.DeleteDocuments(collName, []primitive.ObjectID{documents[2].ID}); err != nil || deleted != 1 {
+		t.Fatalf("DeleteDocuments returned %d, %v", deleted, err)
+	}
+	if _, found, _ := dbSvc.ResolveKey(collName, "doc-3b"); found {
+		t.Errorf("key doc-3b still resolves after the document was deleted")
+	}
+}
+
+func TestIndexManager(t *testing.T) {
+	wtService := wiredtiger.WiredTiger()
+
+	if _, err := os.Stat(WIREDTIGER_DIR); os.IsNotExist(err) {
+		if mkErr := os.MkdirAll(WIREDTIGER_DIR, 0755); mkErr != nil {
+			t.Fatalf("failed to create WT_HOME_TEST dir: %v", mkErr)
+		}
+	}
+
+	if err := wtService.Open(WIREDTIGER_DIR, "create"); err != nil {
+		t.Log("Err occured")
+	}
+
+	indexes := NewIndexManager(IndexManagerOptions{FlushPolicy: FlushOnEvict})
+	dbSvc := DatabaseService(DbParams{
+		Name:      "default",
+		KvService: wtService,
+		Indexes:   indexes,
+	})
+
+	if err := dbSvc.CreateDB(); err != nil {
+		t.Errorf("Failed to create Db; %s", err)
+	}
+
+	collName := "tenant_id_1"
+	collectionDefKey := "default." + collName
+	t.Cleanup(func() {
+		indexes.Close()
+		if err := wtService.Close(); err != nil {
+			fmt.Printf("Warning: failed to close connection: %v\n", err)
+		}
+		os.Remove("default.tenant_id_1.index")
+		os.Remove("default.tenant_id_2.index")
+		os.RemoveAll("volumes/WT_HOME_TEST")
+	})
+
+	fileVectors := func(path string) int64 {
+		idx, err := faiss.FAISS().ReadIndex(path)
+		if err != nil {
+			t.Fatalf("failed to read vector index %s: %v", path, err)
+		}
+		defer idx.Free()
+		nTotal, _ := idx.NTotal()
+		return nTotal
+	}
+	walEntries := func(collectionDefKey string) int {
+		start, end := walRange(collectionDefKey)
+		cursor, err := wtService.ScanRangeBinary(VECTOR_WAL, start, end)
+		if err != nil {
+			t.Fatalf("failed to scan vector WAL: %v", err)
+		}
+		defer cursor.Close()
+		n := 0
+		for cursor.Next() {
+			n++
+		}
+		return n
+	}
+	insert := func(svc DBService, collName string, n int) {
+		documents := make([]GlowstickDocument, n)
+		for i := range documents {
+			documents[i] = GlowstickDocument{Content: fmt.Sprintf("doc %d", i), Embedding: []float32{float32(i), 1, 0, 0}}
+		}
+		if err := svc.InsertDocumentsIntoCollection(collName, documents); err != nil {
+			t.Fatalf("InsertDocumentsIntoCollection(%s) returned error: %v", collName, err)
+		}
+	}
+	query := func(svc DBService, collName string) int {
+		results, err := svc.QueryCollection(collName, QueryStruct{TopK: 100, QueryEmbedding: []float32{0, 1, 0, 0}})
+		if err != nil {
+			t.Fatalf("QueryCollection(%s) returned error: %v", collName, err)
+		}
+		return len(results)
+	}
+
+	if err := dbSvc.CreateCollection(collName, CollectionOptions{Dimension: 4}); err != nil {
+		t.Fatalf("CreateCollection returned error: %v", err)
+	}
+
+	// The index stays loaded across calls, and with FlushOnEvict its new
+	// vectors stay in the WAL rather than the file.
+	insert(dbSvc, collName, 5)
+	insert(dbSvc, collName, 5)
+	if got := query(dbSvc, collName); got != 10 {
+		t.Errorf("query returned %d documents, want 10", got)
+	}
+	stats := indexes.Stats()
+	if stats.Loaded != 1 || stats.Misses != 1 || stats.Hits != 2 || stats.Dirty != 1 {
+		t.Errorf("after two inserts and a query the manager has %+v, want 1 loaded and dirty index, 1 miss and 2 hits", stats)
+	}
+	if got := fileVectors(collectionDefKey + ".index"); got != 0 {
+		t.Errorf("index file has %d vectors before a flush, want 0", got)
+	}
+	if got := walEntries(collectionDefKey); got != 10 {
+		t.Errorf("vector WAL has %d entries before a flush, want 10", got)
+	}
+
+	insert(dbSvc, collName, 2)
+	if err := indexes.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	if got := fileVectors(collectionDefKey + ".index"); got != 12 {
+		t.Errorf("index file has %d vectors after a flush, want 12", got)
+	}
+	if got := walEntries(collectionDefKey); got != 0 {
+		t.Errorf("vector WAL has %d entries after a flush, want 0", got)
+	}
+	if stats := indexes.Stats(); stats.Dirty != 0 || stats.Bytes <= 0 {
+		t.Errorf("after a flush the manager has %+v", stats)
+	}
+
+	// A failed batch must not leave its vectors in the cached index.
+	bad := []GlowstickDocument{{Key: "dup", Embedding: []float32{1, 1, 1, 1}}, {Key: "dup", Embedding: []float32{2, 2, 2, 2}}}
+	if err := dbSvc.InsertDocumentsIntoCollection(collName, bad); !errors.Is(err, ErrDuplicateKey) {
+		t.Errorf("insert with a repeated key returned %v, want ErrDuplicateKey", err)
+	}
+	if got := query(dbSvc, collName); got != 12 {
+		t.Errorf("query after a rejected batch returned %d documents, want 12", got)
+	}
+
+	// A recreated collection does not see its predecessor's index.
+	if err := dbSvc.DropCollection(collName); err != nil {
+		t.Fatalf("DropCollection returned error: %v", err)
+	}
+	if stats := indexes.Stats(); stats.Loaded != 0 {
+		t.Errorf("dropped collection's index is still loaded: %+v", stats)
+	}
+	if err := dbSvc.CreateCollection(collName, CollectionOptions{Dimension: 4}); err != nil {
+		t.Fatalf("CreateCollection returned error: %v", err)
+	}
+	insert(dbSvc, collName, 3)
+	if got := query(dbSvc, collName); got != 3 {
+		t.Errorf("query of the recreated collection returned %d documents, want 3", got)
+	}
+
+	// Under a tight budget an index is written out and evicted as soon as
+	// nobody holds it.
+	small := NewIndexManager(IndexManagerOptions{MemoryBudget: 1, FlushPolicy: FlushOnEvict})
+	defer small.Close()
+	budgeted := DatabaseService(DbParams{Name: "default", KvService: wtService, Indexes: small})
+	if err := budgeted.CreateCollection("tenant_id_2", CollectionOptions{Dimension: 4}); err != nil {
+		t.Fatalf("CreateCollection returned error: %v", err)
+	}
+	insert(budgeted, "tenant_id_2", 4)
+	if stats := small.Stats(); stats.Loaded != 0 || stats.Evictions != 1 {
+		t.Errorf("over budget the manager has %+v, want nothing loaded and 1 eviction", stats)
+	}
+	if got := fileVectors("default.tenant_id_2.index"); got != 4 {
+		t.Errorf("evicted index file has %d vectors, want 4", got)
+	}
+	if got := walEntries("default.tenant_id_2"); got != 0 {
+		t.Errorf("vector WAL has %d entries after eviction, want 0", got)
+	}
+
+	// Closing the manager writes out what it still holds.
+	if err := indexes.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	if got := fileVectors(collectionDefKey + ".index"); got != 3 {
+		t.Errorf("index file has %d vectors after Close, want 3", got)
+	}
+	if got := query(dbSvc, collName); got != 3 {
+		t.Errorf("query after Close returned %d documents, want 3", got)
+	}
+}
+
+// TestConcurrentWritesAndQueries hammers collections with parallel inserts,
+// updates and queries, each through its own DBService as the API server does,
+// and checks that no write is lost or mislabeled. Run it with -race.
+func TestConcurrentWritesAndQueries(t *testing.T) {
+	wtService := wiredtiger.WiredTiger()
+
+	if _, err := os.Stat(WIREDTIGER_DIR); os.IsNotExist(err) {
+		if mkErr := os.MkdirAll(WIREDTIGER_DIR, 0755); mkErr != nil {
+			t.Fatalf("failed to create WT_HOME_TEST dir: %v", mkErr)
+		}
+	}
+
+	if err := wtService.Open(WIREDTIGER_DIR, "create"); err != nil {
+		t.Log("Err occured")
+	}
+
+	indexes := NewIndexManager(IndexManagerOptions{FlushPolicy: FlushPeriodically, FlushInterval: 5 * time.Millisecond})
+	dbSvc := DatabaseService(DbParams{
+		Name:      "default",
+		KvService: wtService,
+	})
+
+	if err := dbSvc.CreateDB(); err != nil {
+		t.Errorf("Failed to create Db; %s", err)
+	}
+
+	t.Cleanup(func() {
+		indexes.Close()
+		if err := wtService.Close(); err != nil {
+			fmt.Printf("Warning: failed to close connection: %v\n", err)
+		}
+		os.Remove("default.tenant_id_1.index")
+		os.Remove("default.tenant_id_2.index")
+		os.RemoveAll("volumes/WT_HOME_TEST")
+	})
+
+	const (
+		dim        = 8
+		writers    = 4
+		batches    = 10
+		batchSize  = 5
+		readers    = 4
+		totalCount = writers * batches * batchSize
+	)
+
+	for _, tc := range []struct {
+		collName string
+		indexes  *IndexManager
+	}{
+		{"tenant_id_1", nil},
+		{"tenant_id_2", indexes},
+	} {
+		if err := dbSvc.CreateCollection(tc.collName, CollectionOptions{Dimension: dim}); err != nil {
+			t.Fatalf("CreateCollection(%s) returned error: %v", tc.collName, err)
+		}
+		service := func() DBService {
+			return DatabaseService(DbParams{Name: "default", KvService: wtService, Indexes: tc.indexes})
+		}
+
+		var written atomic.Int64
+		var queries atomic.Int64
+		done := make(chan struct{})
+
+		var writersWG sync.WaitGroup
+		for w := 0; w < writers; w++ {
+			writersWG.Add(1)
+			go func(w int) {
+				defer writersWG.Done()
+				for b := 0; b < batches; b++ {
+					documents := make([]GlowstickDocument, batchSize)
+					for i := range documents {
+						documents[i] = GlowstickDocument{
+							Key:       fmt.Sprintf("w%d-b%d-d%d", w, b, i),
+							Content:   fmt.Sprintf("writer %d batch %d", w, b),
+							Embedding: genEmbeddings(dim),
+						}
+					}
+					if err := service().InsertDocumentsIntoCollection(tc.collName, documents); err != nil {
+						t.Errorf("%s: writer %d batch %d insert failed: %v", tc.collName, w, b, err)
+						return
+					}
+					written.Add(batchSize)
+
+					// Re-embedding a document tombstones its old label.
+					documents[0].Embedding = genEmbeddings(dim)
+					if err := service().UpdateDocument(tc.collName, documents[0].ID, documents[0]); err != nil {
+						t.Errorf("%s: writer %d batch %d update failed: %v", tc.collName, w, b, err)
+						return
+					}
+				}
+			}(w)
+		}
+
+		var readersWG sync.WaitGroup
+		for r := 0; r < readers; r++ {
+			readersWG.Add(1)
+			go func() {
+				defer readersWG.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					before := written.Load()
+					results, err := service().QueryCollection(tc.collName, QueryStruct{TopK: totalCount, QueryEmbedding: genEmbeddings(dim)})
+					if err != nil {
+						t.Errorf("%s: query failed: %v", tc.collName, err)
+						return
+					}
+					if int64(len(results)) < before || len(results) > totalCount {
+						t.Errorf("%s: query returned %d documents with at least %d written", tc.collName, len(results), before)
+						return
+					}
+					if _, err := service().SearchText(tc.collName, TextQueryStruct{Text: "writer batch", TopK: 10}); err != nil {
+						t.Errorf("%s: text search failed: %v", tc.collName, err)
+						return
+					}
+					queries.Add(1)
+				}
+			}()
+		}
+
+		writersWG.Wait()
+		close(done)
+		readersWG.Wait()
+		t.Logf("%s: %d documents written alongside %d queries", tc.collName, written.Load(), queries.Load())
+
+		if stats, err := dbSvc.GetCollectionStats(tc.collName); err != nil || stats.Doc_Count != totalCount || stats.Tombstone_Count != writers*batches {
+			t.Errorf("%s: stats are %+v, %v; want %d documents and %d tombstones", tc.collName, stats, err, totalCount, writers*batches)
+		}
+
+		results, err := service().QueryCollection(tc.collName, QueryStruct{TopK: 2 * totalCount, QueryEmbedding: genEmbeddings(dim)})
+		if err != nil {
+			t.Fatalf("%s: final query failed: %v", tc.collName, err)
+		}
+		labels := map[int64]bool{}
+		keys := map[string]bool{}
+		for _, result := range results {
+			labels[result.Label] = true
+			keys[result.Document.Key] = true
+		}
+		if len(results) != totalCount || len(labels) != totalCount || len(keys) != totalCount {
+			t.Errorf("%s: final query returned %d documents with %d distinct labels and %d distinct keys, want %d", tc.collName, len(results), len(labels), len(keys), totalCount)
+		}
+	}
+
+	// Every vector reaches the index file once the manager is closed.
+	if err := indexes.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	idx, err := faiss.FAISS().ReadIndex("default.tenant_id_2.index")
+	if err != nil {
+		t.Fatalf("failed to read vector index: %v", err)
+	}
+	defer idx.Free()
+	if nTotal, _ := idx.NTotal(); nTotal != totalCount+writers*batches {
+		t.Errorf("index file has %d vectors, want %d", nTotal, totalCount+writers*batches)
+	}
+	start, end := walRange("default.tenant_id_2")
+	cursor, err := wtService.ScanRangeBinary(VECTOR_WAL, start, end)
+	if err != nil {
+		t.Fatalf("failed to scan vector WAL: %v", err)
+	}
+	defer cursor.Close()
+	if cursor.Next() {
+		t.Errorf("vector WAL still has entries after Close")
+	}
+}
+
+func TestBulkInsert(t *testing.T) {
+	wtService := wiredtiger.WiredTiger()
+
+	if _, err := os.Stat(WIREDTIGER_DIR); os.IsNotExist(err) {
+		if mkErr := os.MkdirAll(WIREDTIGER_DIR, 0755); mkErr != nil {
+			t.Fatalf("failed to create WT_HOME_TEST dir: %v", mkErr)
+		}
+	}
+
+	if err := wtService.Open(WIREDTIGER_DIR, "create"); err != nil {
+		t.Log("Err occured")
+	}
+
+	indexes := NewIndexManager(IndexManagerOptions{FlushPolicy: FlushOnEvict})
+	dbSvc := DatabaseService(DbParams{
+		Name:      "default",
+		KvService: wtService,
+		Indexes:   indexes,
+	})
+
+	if err := dbSvc.CreateDB(); err != nil {
+		t.Errorf("Failed to create Db; %s", err)
+	}
+
+	t.Cleanup(func() {
+		indexes.Close()
+		if err := wtService.Close(); err != nil {
+			fmt.Printf("Warning: failed to close connection: %v\n", err)
+		}
+		os.Remove("default.tenant_id_1.index")
+		os.Remove("default.tenant_id_2.index")
+		os.Remove("default.tenant_id_3.index")
+		os.Remove("default.tenant_id_4.index")
+		os.Remove("default.tenant_id_5.index")
+		os.RemoveAll("volumes/WT_HOME_TEST")
+	})
+
+	const dim = 8
+	makeDocuments := func(n int) []GlowstickDocument {
+		documents := make([]GlowstickDocument, n)
+		for i := range documents {
+			documents[i] = GlowstickDocument{
+				Key:       fmt.Sprintf("doc-%d", i),
+				Content:   fmt.Sprintf("bulk document number %d", i),
+				Embedding: genEmbeddings(dim),
+				Metadata:  map[string]interface{}{"n": i},
+			}
+		}
+		return documents
+	}
+	walEntries := func(collectionDefKey string) int {
+		start, end := walRange(collectionDefKey)
+		cursor, err := wtService.ScanRangeBinary(VECTOR_WAL, start, end)
+		if err != nil {
+			t.Fatalf("failed to scan vector WAL: %v", err)
+		}
+		defer cursor.Close()
+		n := 0
+		for cursor.Next() {
+			n++
+		}
+		return n
+	}
+	indexSize := func(filePath string) int64 {
+		idx, err := faiss.FAISS().ReadIndex(filePath)
+		if err != nil {
+			t.Fatalf("failed to read vector index %s: %v", filePath, err)
+		}
+		defer idx.Free()
+		nTotal, _ := idx.NTotal()
+		return nTotal
+	}
+
+	// A Flat index takes every batch as it comes.
+	if err := dbSvc.CreateCollection("tenant_id_1", CollectionOptions{Dimension: dim}); err != nil {
+		t.Fatalf("CreateCollection returned error: %v", err)
+	}
+	documents := makeDocuments(2500)
+	var progress []BulkProgress
+	n, err := dbSvc.BulkInsert("tenant_id_1", SliceSource(documents), BulkInsertOptions{
+		BatchSize: 1000,
+		Progress:  func(p BulkProgress) { progress = append(progress, p) },
+	})
+	if err != nil || n != 2500 {
+		t.Fatalf("BulkInsert returned %d, %v; want 2500 documents", n, err)
+	}
+	if len(progress) != 3 || progress[2].Inserted != 2500 || progress[2].Indexed != 2500 || progress[0].Inserted != 1000 {
+		t.Errorf("unexpected progress reports %+v", progress)
+	}
+
+	if stats, err := dbSvc.GetCollectionStats("tenant_id_1"); err != nil || stats.Doc_Count != 2500 {
+		t.Errorf("stats are %+v, %v; want 2500 documents", stats, err)
+	}
+	if got := walEntries("default.tenant_id_1"); got != 0 {
+		t.Errorf("vector WAL holds %d entries after the load, want 0", got)
+	}
+	if got := indexSize("default.tenant_id_1.index"); got != 2500 {
+		t.Errorf("index file holds %d vectors, want 2500", got)
+	}
+
+	for _, i := range []int{0, 1234, 2499} {
+		id, found, err := dbSvc.ResolveKey("tenant_id_1", documents[i].Key)
+		if err != nil || !found {
+			t.Fatalf("ResolveKey(%q) returned %v, %v", documents[i].Key, found, err)
+		}
+		doc, found, err := dbSvc.GetDocument("tenant_id_1", id)
+		if err != nil || !found || doc.Content != documents[i].Content {
+			t.Errorf("GetDocument(%s) returned %+v, %v, %v", id.Hex(), doc, found, err)
+		}
+		results, err := dbSvc.QueryCollection("tenant_id_1", QueryStruct{TopK: 1, QueryEmbedding: documents[i].Embedding})
+		if err != nil || len(results) != 1 || results[0].Document.ID != id {
+			t.Errorf("querying with documents[%d]'s embedding returned %+v, %v", i, results, err)
+		}
+	}
+	if results, err := dbSvc.SearchText("tenant_id_1", TextQueryStruct{Text: "number 1234", TopK: 1}); err != nil || len(results) != 1 || results[0].Document.Key != "doc-1234" {
+		t.Errorf("text search returned %+v, %v", results, err)
+	}
+
+	// Loaded collections take regular writes, and no second load.
+	if err := dbSvc.InsertDocumentsIntoCollection("tenant_id_1", makeDocuments(1)); err == nil {
+		t.Errorf("inserting a duplicate Key after the load succeeded")
+	}
+	extra := []GlowstickDocument{{Key: "extra", Embedding: genEmbeddings(dim)}}
+	if err := dbSvc.InsertDocumentsIntoCollection("tenant_id_1", extra); err != nil {
+		t.Errorf("insert after the load failed: %v", err)
+	}
+	if results, err := dbSvc.QueryCollection("tenant_id_1", QueryStruct{TopK: 1, QueryEmbedding: extra[0].Embedding}); err != nil || len(results) != 1 || results[0].Document.Key != "extra" || results[0].Label != 2500 {
+		t.Errorf("querying the inserted document returned %+v, %v", results, err)
+	}
+	if _, err := dbSvc.BulkInsert("tenant_id_1", SliceSource(makeDocuments(1)), BulkInsertOptions{}); !errors.Is(err, ErrCollectionNotEmpty) {
+		t.Errorf("BulkInsert into a loaded collection returned %v, want ErrCollectionNotEmpty", err)
+	}
+
+	// An IVF index is trained on the first TrainSize vectors; those loaded
+	// before then are added once it is.
+	if err := dbSvc.CreateCollection("tenant_id_2", CollectionOptions{Dimension: dim, IndexType: "IVF4,Flat", TrainSize: 120}); err != nil {
+		t.Fatalf("CreateCollection returned error: %v", err)
+	}
+	progress = nil
+	n, err = dbSvc.BulkInsert("tenant_id_2", SliceSource(makeDocuments(250)), BulkInsertOptions{
+		BatchSize: 50,
+		Progress:  func(p BulkProgress) { progress = append(progress, p) },
+	})
+	if err != nil || n != 250 {
+		t.Fatalf("BulkInsert returned %d, %v; want 250 documents", n, err)
+	}
+	indexed := make([]int, len(progress))
+	for i, p := range progress {
+		indexed[i] = p.Indexed
+	}
+	if fmt.Sprint(indexed) != "[0 0 150 200 250]" {
+		t.Errorf("indexed counts are %v, want [0 0 150 200 250]", indexed)
+	}
+	if got := indexSize("default.tenant_id_2.index"); got != 250 {
+		t.Errorf("IVF index file holds %d vectors, want 250", got)
+	}
+	if got := walEntries("default.tenant_id_2"); got != 0 {
+		t.Errorf("vector WAL holds %d entries after the load, want 0", got)
+	}
+
+	// Caller IDs out of order end the bulk cursor; the rest of the load goes
+	// through transactions and duplicates are still caught, counted from the
+	// start of the load.
+	if err := dbSvc.CreateCollection("tenant_id_3", CollectionOptions{Dimension: dim}); err != nil {
+		t.Fatalf("CreateCollection returned error: %v", err)
+	}
+	documents = makeDocuments(30)
+	for i := range documents {
+		documents[i].ID = primitive.NewObjectID()
+	}
+	documents[15].ID, documents[25].ID = documents[25].ID, documents[15].ID
+	documents[28].Key = documents[3].Key
+	n, err = dbSvc.BulkInsert("tenant_id_3", SliceSource(documents), BulkInsertOptions{BatchSize: 10})
+	var batchErr *BatchError
+	if n != 20 || !errors.As(err, &batchErr) || !errors.Is(err, ErrDuplicateKey) || len(batchErr.Documents) != 1 || batchErr.Documents[0].Index != 28 {
+		t.Fatalf("BulkInsert returned %d, %v; want 20 documents and documents[28] rejected", n, err)
+	}
+	if batchErr.Offset != 20 || batchErr.Total != 10 || !strings.Contains(err.Error(), "1 of 10 documents in documents[20:30]") {
+		t.Errorf("BatchError places the batch at %d, %d documents: %v", batchErr.Offset, batchErr.Total, err)
+	}
+	for _, doc := range documents[:20] {
+		if _, found, err := dbSvc.GetDocument("tenant_id_3", doc.ID); err != nil || !found {
+			t.Errorf("document %s of a committed batch is missing: %v", doc.ID.Hex(), err)
+		}
+	}
+	if stats, err := dbSvc.GetCollectionStats("tenant_id_3"); err != nil || stats.Doc_Count != 20 {
+		t.Errorf("stats are %+v, %v; want 20 documents", stats, err)
+	}
+	if results, err := dbSvc.QueryCollection("tenant_id_3", QueryStruct{TopK: 30, QueryEmbedding: genEmbeddings(dim)}); err != nil || len(results) != 20 {
+		t.Errorf("query returned %d results, %v; want 20", len(results), err)
+	}
+
+	// A bulk cursor failing part way: the rows it took are committed before
+	// anything refers to them, and the rest goes through transactions.
+	failing := DatabaseService(DbParams{
+		Name:      "default",
+		KvService: failingBulkService{WTService: wtService, after: 15},
+		Indexes:   indexes,
+	})
+	if err := failing.CreateCollection("tenant_id_4", CollectionOptions{Dimension: dim}); err != nil {
+		t.Fatalf("CreateCollection returned error: %v", err)
+	}
+	documents = makeDocuments(40)
+	n, err = failing.BulkInsert("tenant_id_4", SliceSource(documents), BulkInsertOptions{BatchSize: 10})
+	if err != nil || n != 40 {
+		t.Fatalf("BulkInsert with a failing bulk cursor returned %d, %v; want 40 documents", n, err)
+	}
+	if stats, err := failing.GetCollectionStats("tenant_id_4"); err != nil || stats.Doc_Count != 40 {
+		t.Errorf("stats are %+v, %v; want 40 documents", stats, err)
+	}
+	for i, doc := range documents {
+		id, found, err := failing.ResolveKey("tenant_id_4", doc.Key)
+		if err != nil || !found {
+			t.Fatalf("ResolveKey(%q) returned %v, %v", doc.Key, found, err)
+		}
+		if got, found, err := failing.GetDocument("tenant_id_4", id); err != nil || !found || got.Content != doc.Content {
+			t.Errorf("GetDocument(%s) returned %+v, %v, %v", id.Hex(), got, found, err)
+		}
+		results, err := failing.QueryCollection("tenant_id_4", QueryStruct{TopK: 1, QueryEmbedding: doc.Embedding})
+		if err != nil || len(results) != 1 || results[0].Document.ID != id {
+			t.Errorf("querying with documents[%d]'s embedding returned %+v, %v", i, results, err)
+		}
+	}
+	if got := walEntries("default.tenant_id_4"); got != 0 {
+		t.Errorf("vector WAL holds %d entries after the load, want 0", got)
+	}
+	if got := indexSize("default.tenant_id_4.index"); got != 40 {
+		t.Errorf("index file holds %d vectors, want 40", got)
+	}
+
+	// Rows a failed load left without committing them are cleared by the next.
+	if err := dbSvc.CreateCollection("tenant_id_5", CollectionOptions{Dimension: dim}); err != nil {
+		t.Fatalf("CreateCollection returned error: %v", err)
+	}
+	collection, _, err := dbSvc.(*GDBService).getCollection("tenant_id_5")
+	if err != nil {
+		t.Fatalf("getCollection returned error: %v", err)
+	}
+	stray := primitive.NewObjectID()
+	strayDoc, _ := bson.Marshal(GlowstickDocument{ID: stray, Content: "stray row", Embedding: genEmbeddings(dim)})
+	if err := wtService.PutBinary(collection.TableUri, stray[:], strayDoc); err != nil {
+		t.Fatalf("PutBinary returned error: %v", err)
+	}
+	if n, err := dbSvc.BulkInsert("tenant_id_5", SliceSource(makeDocuments(10)), BulkInsertOptions{}); err != nil || n != 10 {
+		t.Fatalf("BulkInsert over a stray row returned %d, %v; want 10 documents", n, err)
+	}
+	if _, found, err := dbSvc.GetDocument("tenant_id_5", stray); err != nil || found {
+		t.Errorf("stray row is still there: %v, %v", found, err)
+	}
+	if results, err := dbSvc.QueryCollection("tenant_id_5", QueryStruct{TopK: 20, QueryEmbedding: genEmbeddings(dim)}); err != nil || len(results) != 10 {
+		t.Errorf("query returned %d results, %v; want 10", len(results), err)
+	}
+}
+
+// failingBulkService fails the bulk cursors it opens once they have taken
+// after rows.
+// searchNearBeforeService reports every inexact SearchNearBinary as landing
+// before the probe, which WiredTiger is free to do.
+type searchNearBeforeService struct {
+	wiredtiger.WTService
+}
+
+func (s searchNearBeforeService) SearchNearBinary(table string, probeKey []byte) ([]byte, []byte, int, bool, error) {
+	key, val, exact, found, err := s.WTService.SearchNearBinary(table, probeKey)
+	if err != nil || !found || exact <= 0 {
+		return key, val, exact, found, err
+	}
+	return nil, nil, -1, true, nil
+}
+
+type failingBulkService struct {
+	wiredtiger.WTService
+	after int
+}
+
+func (f failingBulkService) OpenBulkCursor(table string) (wiredtiger.BulkCursor, error) {
+	cursor, err := f.WTService.OpenBulkCursor(table)
+	if err != nil {
+		return nil, err
+	}
+	return &failingBulkCursor{BulkCursor: cursor, left: f.after}, nil
+}
+
+type failingBulkCursor struct {
+	wiredtiger.BulkCursor
+	left int
+}
+
+func (c *failingBulkCursor) Insert(key []byte, value []byte) error {
+	if c.left == 0 {
+		return errors.New("injected bulk insert failure")
+	}
+	c.left--
+	return c.BulkCursor.Insert(key, value)
+}
+
+func TestDumpRestore(t *testing.T) {
+	wtService := wiredtiger.WiredTiger()
+
+	if _, err := os.Stat(WIREDTIGER_DIR); os.IsNotExist(err) {
+		if mkErr := os.MkdirAll(WIREDTIGER_DIR, 0755); mkErr != nil {
+			t.Fatalf("failed to create WT_HOME_TEST dir: %v", mkErr)
+		}
+	}
+
+	if err := wtService.Open(WIREDTIGER_DIR, "create"); err != nil {
+		t.Log("Err occured")
+	}
+
+	dbSvc := DatabaseService(DbParams{
+		Name:      "default",
+		KvService: wtService,
+	})
+	restoredSvc := DatabaseService(DbParams{
+		Name:      "restored",
+		KvService: wtService,
+	})
+
+	for _, svc := range []DBService{dbSvc, restoredSvc} {
+		if err := svc.CreateDB(); err != nil {
+			t.Errorf("Failed to create Db; %s", err)
+		}
+	}
+
+	t.Cleanup(func() {
+		if err := wtService.Close(); err != nil {
+			fmt.Printf("Warning: failed to close connection: %v\n", err)
+		}
+		for _, name := range []string{"default.tenant_id_1", "default.from_jsonl", "default.from_bson", "default.truncated", "default.miscounted", "default.extended", "default.typed", "default.typed_copy", "restored.tenant_id_1"} {
+			os.Remove(name + ".index")
+		}
+		os.RemoveAll("volumes/WT_HOME_TEST")
+	})
+
+	const dim = 8
+	minLength := 1
+	schema := &bsonvalidator.Schema{
+		Type:       "object",
+		Required:   []string{"content"},
+		Properties: map[string]*bsonvalidator.Schema{"content": {Type: "string", MinLength: &minLength}},
+	}
+	err := dbSvc.CreateCollection("tenant_id_1", CollectionOptions{Dimension: dim, Metric: MetricCosine, TextAnalyzer: AnalyzerSimple, Schema: schema})
+	if err != nil {
+		t.Fatalf("CreateCollection returned error: %v", err)
+	}
+	if err := dbSvc.CreateIndex("tenant_id_1", CollectionIndex{Key: map[string]int{"n": 1}}); err != nil {
+		t.Fatalf("CreateIndex returned error: %v", err)
+	}
+
+	documents := make([]GlowstickDocument, 300)
+	for i := range documents {
+		documents[i] = GlowstickDocument{
+			Key:       fmt.Sprintf("doc-%d", i),
+			Content:   fmt.Sprintf("dumped document number %d", i),
+			Embedding: genEmbeddings(dim),
+			Metadata:  map[string]interface{}{"n": i, "tags": []string{"dump", fmt.Sprint(i % 3)}},
+		}
+	}
+	if err := dbSvc.InsertDocumentsIntoCollection("tenant_id_1", documents); err != nil {
+		t.Fatalf("InsertDocumentsIntoCollection returned error: %v", err)
+	}
+	// Deleted documents are not dumped.
+	deleted := []primitive.ObjectID{documents[7].ID, documents[150].ID}
+	if n, err := dbSvc.DeleteDocuments("tenant_id_1", deleted); err != nil || n != 2 {
+		t.Fatalf("DeleteDocuments returned %d, %v", n, err)
+	}
+
+	dumps := map[string]*bytes.Buffer{}
+	for _, format := range []string{DumpFormatJSONL, DumpFormatBSON} {
+		dumps[format] = &bytes.Buffer{}
+		n, err := dbSvc.DumpCollection("tenant_id_1", dumps[format], format)
+		if err != nil || n != 298 {
+			t.Fatalf("DumpCollection(%s) returned %d, %v; want 298 documents", format, n, err)
+		}
+	}
+	if lines := strings.Count(dumps[DumpFormatJSONL].String(), "\n"); lines != 300 {
+		t.Errorf("JSONL dump has %d lines, want a header, 298 documents and a trailer", lines)
+	}
+	if _, err := dbSvc.DumpCollection("tenant_id_1", &bytes.Buffer{}, "csv"); !errors.Is(err, ErrInvalidDump) {
+		t.Errorf("DumpCollection in an unknown format returned %v, want ErrInvalidDump", err)
+	}
+	if _, err := dbSvc.DumpCollection("missing", &bytes.Buffer{}, DumpFormatBSON); !errors.Is(err, ErrCollectionNotFound) {
+		t.Errorf("DumpCollection of a missing collection returned %v, want ErrCollectionNotFound", err)
+	}
+
+	checkRestored := func(svc DBService, collection_name string) {
+		t.Helper()
+		if stats, err := svc.GetCollectionStats(collection_name); err != nil || stats.Doc_Count != 298 {
+			t.Errorf("%s stats are %+v, %v; want 298 documents", collection_name, stats, err)
+		}
+
+		entry, _, err := svc.(*GDBService).getCollection(collection_name)
+		if err != nil {
+			t.Fatalf("getCollection(%s) returned error: %v", collection_name, err)
+		}
+		if entry.VectorIndex.Metric != MetricCosine || entry.VectorIndex.Dimension != dim || entry.TextAnalyzer != AnalyzerSimple || entry.Schema == nil {
+			t.Errorf("%s was restored with settings %+v", collection_name, entry)
+		}
+		if len(entry.Indexes) != 1 || entry.Indexes[0].Key["n"] != 1 {
+			t.Errorf("%s was restored with indexes %+v", collection_name, entry.Indexes)
+		}
+
+		for _, i := range []int{0, 151, 299} {
+			results, err := svc.QueryCollection(collection_name, QueryStruct{TopK: 1, QueryEmbedding: documents[i].Embedding})
+			if err != nil || len(results) != 1 || results[0].Document.ID != documents[i].ID || results[0].Document.Key != documents[i].Key {
+				t.Errorf("querying %s with documents[%d]'s embedding returned %+v, %v", collection_name, i, results, err)
+				continue
+			}
+			if got := results[0].Document; got.Content != documents[i].Content || fmt.Sprint(got.Embedding) != fmt.Sprint(documents[i].Embedding) {
+				t.Errorf("documents[%d] was restored as %+v", i, got)
+			}
+		}
+		if _, found, err := svc.GetDocument(collection_name, documents[7].ID); err != nil || found {
+			t.Errorf("deleted document was restored: %v, %v", found, err)
+		}
+		if id, found, err := svc.ResolveKey(collection_name, "doc-42"); err != nil || !found || id != documents[42].ID {
+			t.Errorf("ResolveKey returned %s, %v, %v", id.Hex(), found, err)
+		}
+		results, err := svc.QueryCollection(collection_name, QueryStruct{
+			TopK:           300,
+			QueryEmbedding: genEmbeddings(dim),
+			Filters:        map[string]interface{}{"n": map[string]interface{}{"$gt": 289}},
+		})
+		if err != nil || len(results) != 10 {
+			t.Errorf("filtered query on %s returned %d results, %v; want 10", collection_name, len(results), err)
+		}
+		if results, err := svc.SearchText(collection_name, TextQueryStruct{Text: "number 123", TopK: 1}); err != nil || len(results) != 1 || results[0].Document.Key != "doc-123" {
+			t.Errorf("text search on %s returned %+v, %v", collection_name, results, err)
+		}
+		if err := svc.InsertDocumentsIntoCollection(collection_name, []GlowstickDocument{{Embedding: genEmbeddings(dim)}}); !errors.Is(err, ErrInvalidDocument) {
+			t.Errorf("inserting a document without content into %s returned %v, want ErrInvalidDocument", collection_name, err)
+		}
+	}
+
+	for _, format := range []string{DumpFormatJSONL, DumpFormatBSON} {
+		collection_name := "from_" + format
+		n, err := dbSvc.RestoreCollection(collection_name, bytes.NewReader(dumps[format].Bytes()), format, BulkInsertOptions{BatchSize: 100})
+		if err != nil || n != 298 {
+			t.Fatalf("RestoreCollection(%s) returned %d, %v; want 298 documents", format, n, err)
+		}
+		checkRestored(dbSvc, collection_name)
+	}
+
+	// Without a name the collection keeps the one it was dumped under.
+	if n, err := restoredSvc.RestoreCollection("", bytes.NewReader(dumps[DumpFormatBSON].Bytes()), DumpFormatBSON, BulkInsertOptions{}); err != nil || n != 298 {
+		t.Fatalf("RestoreCollection into another database returned %d, %v", n, err)
+	}
+	checkRestored(restoredSvc, "tenant_id_1")
+
+	if _, err := dbSvc.RestoreCollection("from_bson", bytes.NewReader(dumps[DumpFormatBSON].Bytes()), DumpFormatBSON, BulkInsertOptions{}); !errors.Is(err, ErrCollectionExists) {
+		t.Errorf("restoring over an existing collection returned %v, want ErrCollectionExists", err)
+	}
+
+	for _, input := range []string{"", "not a dump\n", `{"collection": "tenant_id_1"}` + "\n"} {
+		if _, err := dbSvc.RestoreCollection("garbage", strings.NewReader(input), DumpFormatJSONL, BulkInsertOptions{}); !errors.Is(err, ErrInvalidDump) {
+			t.Errorf("restoring %q returned %v, want ErrInvalidDump", input, err)
+		}
+	}
+
+	// JSONL dumps keep BSON types that plain JSON numbers and strings would lose.
+	if err := dbSvc.CreateCollection("typed", CollectionOptions{Dimension: dim}); err != nil {
+		t.Fatalf("CreateCollection returned error: %v", err)
+	}
+	metadata := map[string]interface{}{
+		"small_long": int64(5),
+		"large_long": int64(1 << 40),
+		"int":        int32(7),
+		"double":     2.0,
+		"at":         primitive.NewDateTimeFromTime(time.Date(2024, 2, 29, 12, 30, 0, 0, time.UTC)),
+	}
+	typed := []GlowstickDocument{{Embedding: genEmbeddings(dim), Metadata: metadata}}
+	if err := dbSvc.InsertDocumentsIntoCollection("typed", typed); err != nil {
+		t.Fatalf("InsertDocumentsIntoCollection returned error: %v", err)
+	}
+	var typedDump bytes.Buffer
+	if _, err := dbSvc.DumpCollection("typed", &typedDump, DumpFormatJSONL); err != nil {
+		t.Fatalf("DumpCollection returned error: %v", err)
+	}
+	if _, err := dbSvc.RestoreCollection("typed_copy", &typedDump, DumpFormatJSONL, BulkInsertOptions{}); err != nil {
+		t.Fatalf("RestoreCollection returned error: %v", err)
+	}
+	restored, found, err := dbSvc.GetDocument("typed_copy", typed[0].ID)
+	if err != nil || !found {
+		t.Fatalf("GetDocument returned %v, %v", found, err)
+	}
+	fields, ok := restored.Metadata.(primitive.D)
+	if !ok || len(fields) != len(metadata) {
+		t.Fatalf("metadata was restored as %#v", restored.Metadata)
+	}
+	for _, field := range fields {
+		if want := metadata[field.Key]; field.Value != want {
+			t.Errorf("metadata %s was restored as %#v (%T), want %#v (%T)", field.Key, field.Value, field.Value, want, want)
+		}
+	}
+
+	// A dump cut short loads what it holds and reports it.
+	lines := strings.SplitAfter(dumps[DumpFormatJSONL].String(), "\n")
+	truncated := strings.Join(lines[:51], "")
+	n, err := dbSvc.RestoreCollection("truncated", strings.NewReader(truncated), DumpFormatJSONL, BulkInsertOptions{})
+	if n != 50 || !errors.Is(err, ErrInvalidDump) {
+		t.Errorf("restoring a truncated dump returned %d, %v; want 50 documents and ErrInvalidDump", n, err)
+	}
+
+	// So does one whose trailer disagrees with what precedes it, or is not last.
+	trailer, err := bson.MarshalExtJSON(DumpTrailer{Count: 297}, true, false)
+	if err != nil {
+		t.Fatalf("failed to encode trailer: %v", err)
+	}
+	miscounted := strings.Join(lines[:299], "") + string(trailer) + "\n"
+	if n, err := dbSvc.RestoreCollection("miscounted", strings.NewReader(miscounted), DumpFormatJSONL, BulkInsertOptions{}); n != 298 || !errors.Is(err, ErrInvalidDump) {
+		t.Errorf("restoring a dump with a wrong trailer returned %d, %v; want 298 documents and ErrInvalidDump", n, err)
+	}
+	extended := dumps[DumpFormatJSONL].String() + lines[1]
+	if _, err := dbSvc.RestoreCollection("extended", strings.NewReader(extended), DumpFormatJSONL, BulkInsertOptions{}); !errors.Is(err, ErrInvalidDump) {
+		t.Errorf("restoring a dump with records after its trailer returned %v, want ErrInvalidDump", err)
+	}
 }
@@ -0,0 +1,173 @@
+package dbservice
+
+import (
+	"bytes"
+	"fmt"
+	wt "glowstickdb/pkgs/wiredtiger"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaxDocumentKeyLength bounds GlowstickDocument.Key, which is stored as a
+// WiredTiger key.
+const MaxDocumentKeyLength = 1024
+
+// indexDocumentKey records the mapping from a document's Key to its ID.
+func indexDocumentKey(sess wt.Session, collection CollectionCatalogEntry, doc GlowstickDocument) error {
+	if doc.Key == "" || collection.KeyIndexUri == "" {
+		return nil
+	}
+	if err := sess.PutBinary(collection.KeyIndexUri, []byte(doc.Key), doc.ID[:]); err != nil {
+		return fmt.Errorf("failed to write key %q: %w", doc.Key, err)
+	}
+	return nil
+}
+
+// unindexDocumentKey removes the mapping from a document's Key to its ID.
+func unindexDocumentKey(sess wt.Session, collection CollectionCatalogEntry, doc GlowstickDocument) error {
+	if doc.Key == "" || collection.KeyIndexUri == "" {
+		return nil
+	}
+	if err := sess.DeleteBinary(collection.KeyIndexUri, []byte(doc.Key)); err != nil {
+		return fmt.Errorf("failed to delete key %q: %w", doc.Key, err)
+	}
+	return nil
+}
+
+// assignIDs gives every document without an ID one, in place. An upserted
+// document with a Key takes the ID of the document already holding that Key,
+// so that it replaces it.
+func (s *GDBService) assignIDs(collection CollectionCatalogEntry, documents []GlowstickDocument, mode writeMode) error {
+	for i := range documents {
+		if !documents[i].ID.IsZero() {
+			continue
+		}
+		if mode == writeUpsert && documents[i].Key != "" {
+			val, found, err := s.KvService.GetBinary(collection.KeyIndexUri, []byte(documents[i].Key))
+			if err != nil {
+				return fmt.Errorf("failed to resolve key %q: %w", documents[i].Key, err)
+			}
+			if found {
+				copy(documents[i].ID[:], val)
+				continue
+			}
+		}
+		documents[i].ID = primitive.NewObjectID()
+	}
+	return nil
+}
+
+// newIDs assigns a new ObjectID to the documents without an ID, except the
+// upserts with a Key, for which assignIDs first looks for the document holding
+// it. Unlike assignIDs it reads nothing, so it needs no lock.
+func newIDs(documents []GlowstickDocument, mode writeMode) {
+	for i := range documents {
+		if documents[i].ID.IsZero() && (mode != writeUpsert || documents[i].Key == "") {
+			documents[i].ID = primitive.NewObjectID()
+		}
+	}
+}
+
+// checkDuplicateKeys rejects, as a *BatchError, documents whose ID or Key is
+// used twice in the batch or already belongs to another document. Inserts may
+// not reuse an existing ID; updates and upserts replace the document under it.
+func checkDuplicateKeys(sess wt.Session, collection CollectionCatalogEntry, documents []GlowstickDocument, mode writeMode) error {
+	var duplicates []DocumentError
+	reject := func(i int, err error) {
+		duplicates = append(duplicates, DocumentError{Index: i, Id: documents[i].ID, Err: err})
+	}
+
+	ids := make(map[primitive.ObjectID]int, len(documents))
+	keys := make(map[string]int)
+	for i, doc := range documents {
+		if first, seen := ids[doc.ID]; seen {
+			reject(i, fmt.Errorf("%w: _id %s is also used by documents[%d]", ErrDuplicateKey, doc.ID.Hex(), first))
+			continue
+		}
+		ids[doc.ID] = i
+		if doc.Key != "" {
+			if first, seen := keys[doc.Key]; seen {
+				reject(i, fmt.Errorf("%w: key %q is also used by documents[%d]", ErrDuplicateKey, doc.Key, first))
+				continue
+			}
+			keys[doc.Key] = i
+		}
+
+		if mode == writeInsert {
+			exists, err := sess.ExistsBinary(collection.TableUri, doc.ID[:])
+			if err != nil {
+				return fmt.Errorf("failed to check _id %s: %w", doc.ID.Hex(), err)
+			}
+			if exists {
+				reject(i, fmt.Errorf("%w: _id %s already exists", ErrDuplicateKey, doc.ID.Hex()))
+				continue
+			}
+		}
+		if doc.Key != "" {
+			owner, found, err := sess.GetBinary(collection.KeyIndexUri, []byte(doc.Key))
+			if err != nil {
+				return fmt.Errorf("failed to check key %q: %w", doc.Key, err)
+			}
+			if found && !bytes.Equal(owner, doc.ID[:]) {
+				reject(i, fmt.Errorf("%w: key %q already belongs to _id %x", ErrDuplicateKey, doc.Key, owner))
+			}
+		}
+	}
+
+	if len(duplicates) > 0 {
+		return &BatchError{Documents: duplicates, Total: len(documents)}
+	}
+	return nil
+}
+
+// ResolveKey returns the ID of the document whose Key is key.
+func (s *GDBService) ResolveKey(collection_name string, key string) (primitive.ObjectID, bool, error) {
+	var id primitive.ObjectID
+
+	defer s.rlockCollection(collection_name)()
+
+	collection, _, err := s.getCollection(collection_name)
+	if err != nil {
+		return id, false, err
+	}
+
+	val, found, err := s.KvService.GetBinary(collection.KeyIndexUri, []byte(key))
+	if err != nil {
+		return id, false, fmt.Errorf("failed to resolve key %q: %w", key, err)
+	}
+	if !found {
+		return id, false, nil
+	}
+	copy(id[:], val)
+	return id, true, nil
+}
+
+// migrateKeyIndex gives collections created before documents had a Key their
+// key table. Their documents have no Key, so it starts out empty.
+func (s *GDBService) migrateKeyIndex(collection *CollectionCatalogEntry, collectionDefKey string) error {
+	if collection.KeyIndexUri != "" {
+		return nil
+	}
+
+	tableUri := fmt.Sprintf("table:keys-%s-%s", collection.Id.Hex(), s.Name)
+	if err := s.KvService.CreateTable(tableUri, "key_format=u,value_format=u"); err != nil {
+		return fmt.Errorf("key index migration failed to create table %s: %v", tableUri, err)
+	}
+
+	migrated := *collection
+	migrated.KeyIndexUri = tableUri
+	migrated.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	entry, err := bson.Marshal(migrated)
+	if err != nil {
+		return fmt.Errorf("key index migration failed to encode catalog entry: %v", err)
+	}
+	if err := s.KvService.PutBinaryWithStringKey(CATALOG, collectionDefKey, entry); err != nil {
+		return fmt.Errorf("key index migration failed to update catalog entry: %v", err)
+	}
+
+	*collection = migrated
+	return nil
+}
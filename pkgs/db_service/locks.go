@@ -0,0 +1,87 @@
+package dbservice
+
+import (
+	wt "glowstickdb/pkgs/wiredtiger"
+	"sync"
+)
+
+// Concurrency model
+//
+// A DBService is safe for concurrent use, and so are several DBServices
+// opened on the same WiredTiger connection, as the API server does for every
+// request. WiredTiger keeps each row consistent, but a collection spans many
+// rows, the FAISS index and its file, which are not safe to change while they
+// are read. Collections are therefore guarded by a reader/writer lock shared
+// by every DBService on the connection:
+//
+//   - Calls that change a collection (inserts, updates, upserts, deletes,
+//     bulk loads, CreateIndex, CreateCollection and DropCollection) hold its
+//     lock exclusively while they write. Label allocation, the WiredTiger
+//     transaction, adding to the FAISS index and rewriting the index file
+//     happen one writer at a time, and writers to one collection never
+//     conflict in WiredTiger. Embedding documents, which may call a remote
+//     provider, happens before the lock is taken.
+//   - Writers also hold the collection's write mutex, for their whole
+//     duration. A bulk load holds it from start to end but the lock only
+//     while it writes a batch, so that readers get in between batches and
+//     other writers do not.
+//   - Reads (queries, GetDocument, ResolveKey and dumps) hold the lock shared,
+//     so they run in parallel with each other but never see a collection in
+//     the middle of a write, such as an index half added to, or a bulk load's
+//     rows before its labels and Keys.
+//     Query texts, like documents, are embedded before the lock is taken.
+//   - GetCollectionStats and catalog scans take no lock; WiredTiger gives
+//     each a consistent view.
+//   - Calls on different collections never wait for each other.
+//
+// Two steps may write while only the shared lock is held, and have a mutex of
+// their own per collection: upgrading a legacy catalog entry in getCollection,
+// and loading the vector index, which may replay the vector WAL into the file.
+
+// collectionLock coordinates the calls made on one collection.
+type collectionLock struct {
+	sync.RWMutex
+	write   sync.Mutex // held by writers, before the lock itself
+	migrate sync.Mutex // held while a legacy catalog entry is upgraded
+	load    sync.Mutex // held while the vector index is read and its WAL replayed
+}
+
+type collectionLockKey struct {
+	kv wt.WTService
+	ns string
+}
+
+// collectionLocks holds the lock of every collection used in the process, per
+// WiredTiger connection. Locks are never removed: a collection recreated
+// under a dropped one's name takes over its lock.
+var collectionLocks sync.Map // collectionLockKey -> *collectionLock
+
+// collectionLock returns the lock of a collection, given as "<db>.<collection>".
+func (s *GDBService) collectionLock(collectionDefKey string) *collectionLock {
+	key := collectionLockKey{kv: s.KvService, ns: collectionDefKey}
+	if lock, ok := collectionLocks.Load(key); ok {
+		return lock.(*collectionLock)
+	}
+	lock, _ := collectionLocks.LoadOrStore(key, &collectionLock{})
+	return lock.(*collectionLock)
+}
+
+// lockCollection takes a collection's write mutex and its lock exclusively,
+// and returns the function that releases them.
+func (s *GDBService) lockCollection(collection_name string) func() {
+	lock := s.collectionLock(s.Name + "." + collection_name)
+	lock.write.Lock()
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		lock.write.Unlock()
+	}
+}
+
+// rlockCollection takes a collection's lock shared and returns the function
+// that releases it.
+func (s *GDBService) rlockCollection(collection_name string) func() {
+	lock := s.collectionLock(s.Name + "." + collection_name)
+	lock.RLock()
+	return lock.RUnlock
+}
@@ -0,0 +1,308 @@
+package dbservice
+
+import (
+	"errors"
+	"fmt"
+	wt "glowstickdb/pkgs/wiredtiger"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// kvReader is the read side shared by wt.WTService and wt.Session, so hits can
+// be resolved either with one-off lookups or through a single session.
+type kvReader interface {
+	GetString(table string, key string) (string, bool, error)
+	GetBinary(table string, key []byte) ([]byte, bool, error)
+}
+
+// resolveHits turns the labels and raw distances of one FAISS result set into
+// up to TopK documents, nearest first. Results that are tombstoned, outside
+// candidates or rejected by filter are counted in skipped, so the caller can
+// tell whether a wider search could return more. Lookup failures skip the hit
+// and are reported as lastErr; err is only set when the tables are inconsistent.
+func (s *GDBService) resolveHits(r kvReader, collection CollectionCatalogEntry, collectionDefKey string, candidates map[primitive.ObjectID]struct{}, filter Filter, query QueryStruct, distances []float32, ids []int64) (results []QueryResult, skipped int, lastErr error, err error) {
+	log := s.logger()
+	metric := collection.VectorIndex.withDefaults().Metric
+	results = []QueryResult{}
+
+	indices := make([]int, len(distances))
+	for i := range indices {
+		indices[i] = i
+	}
+
+	// Inner-product searches report similarities, so compare as distances.
+	sort.Slice(indices, func(i, j int) bool {
+		return metricDistance(metric, distances[indices[i]]) < metricDistance(metric, distances[indices[j]])
+	})
+
+	for _, index := range indices {
+		id := ids[index]
+		distance := metricDistance(metric, distances[index])
+
+		// id could be -1 if FAISS returned a "no result"; handle this
+		if id < 0 {
+			continue
+		}
+
+		key := fmt.Sprintf("%d", id)
+		val, found, err := r.GetString(collection.LabelToDocUri, key)
+		if err != nil {
+			log.Warn("failed to get docID for label", "collection", collectionDefKey, "label", id, "error", err)
+			lastErr = err
+			continue
+		}
+
+		// The label was tombstoned by an update or delete.
+		if !found {
+			skipped++
+			continue
+		}
+
+		if len(val) != 24 {
+			lastErr = fmt.Errorf("invalid ObjectID hex length: expected 24, got %d for '%s'", len(val), val)
+			log.Warn("invalid label mapping", "collection", collectionDefKey, "label", id, "error", lastErr)
+			continue
+		}
+
+		objectID, err := primitive.ObjectIDFromHex(val)
+		if err != nil {
+			log.Warn("failed to parse docID as ObjectID hex", "collection", collectionDefKey, "label", id, "doc_id", val, "error", err)
+			lastErr = err
+			continue
+		}
+
+		// Validate the ObjectID is not empty/zero
+		if objectID.IsZero() {
+			lastErr = fmt.Errorf("ObjectID is zero/empty for hex '%s'", val)
+			log.Warn("invalid label mapping", "collection", collectionDefKey, "label", id, "error", lastErr)
+			continue
+		}
+
+		if candidates != nil {
+			if _, ok := candidates[objectID]; !ok {
+				skipped++
+				continue
+			}
+		}
+
+		docBin, exists, err := r.GetBinary(collection.TableUri, objectID[:])
+		if err != nil {
+			log.Warn("failed to get document", "collection", collectionDefKey, "doc_id", val, "error", err)
+			lastErr = err
+			continue
+		}
+
+		if !exists {
+			return nil, 0, nil, fmt.Errorf("failed to get document with id %v", val)
+		}
+
+		if len(docBin) > 0 {
+			var doc GlowstickDocument
+
+			if err := bson.Unmarshal(docBin, &doc); err != nil {
+				log.Warn("failed to unmarshal document", "collection", collectionDefKey, "doc_id", val, "error", err)
+				lastErr = err
+				continue
+			}
+
+			if query.MaxDistance != 0 && distance >= query.MaxDistance {
+				log.Debug("result beyond max distance", "collection", collectionDefKey, "doc_id", val, "distance", distance)
+				continue
+			}
+
+			if filter != nil && !filter.Match(doc.Metadata) {
+				skipped++
+				continue
+			}
+
+			results = append(results, QueryResult{
+				Document:    doc,
+				Distance:    distance,
+				Score:       distanceScore(metric, distance),
+				VectorScore: distanceScore(metric, distance),
+				Label:       id,
+			})
+			if len(results) == int(query.TopK) {
+				break
+			}
+		}
+	}
+
+	return results, skipped, lastErr, nil
+}
+
+// QueryCollectionBatch runs several queries that share TopK, MaxDistance and
+// filters against a collection in one FAISS search call, and resolves every
+// result set through a single WiredTiger session so its cursors are reused
+// across lookups. results[i] holds the ranked hits for QueryEmbeddings[i].
+func (s *GDBService) QueryCollectionBatch(collection_name string, query BatchQueryStruct) ([][]QueryResult, error) {
+	if query.TopK <= 0 {
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollectionBatch] - %w: TopK must be positive, got %d", ErrInvalidQuery, query.TopK)
+	}
+
+	// As in QueryCollection, query texts are embedded before the collection
+	// is locked.
+	if len(query.QueryEmbeddings) == 0 && len(query.QueryContents) > 0 {
+		collection, _, err := s.getCollection(collection_name)
+		if err != nil {
+			return nil, fmt.Errorf("[DB_SERVICE:QueryCollectionBatch] - %w", err)
+		}
+		if query.QueryEmbeddings, err = embedTexts(collection, query.QueryContents); err != nil {
+			return nil, fmt.Errorf("[DB_SERVICE:QueryCollectionBatch] - %w", err)
+		}
+	}
+
+	defer s.rlockCollection(collection_name)()
+
+	log := s.logger()
+
+	filter, err := CompileFilter(query.Filters)
+	if err != nil {
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollectionBatch] - %w: %v", ErrInvalidFilter, err)
+	}
+
+	collection, collectionDefKey, err := s.getCollection(collection_name)
+	if err != nil {
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollectionBatch] - %w", err)
+	}
+
+	results := make([][]QueryResult, len(query.QueryEmbeddings))
+	for i := range results {
+		results[i] = []QueryResult{}
+	}
+	if len(query.QueryEmbeddings) == 0 {
+		return results, nil
+	}
+
+	// The embeddings are searched as one nq x d matrix.
+	dimension := collection.VectorIndex.Dimension
+	if dimension == 0 {
+		dimension = len(query.QueryEmbeddings[0])
+	}
+	for i, embedding := range query.QueryEmbeddings {
+		if len(embedding) != dimension {
+			return nil, fmt.Errorf("[DB_SERVICE:QueryCollectionBatch] - %w: query embedding %d has %d dimensions, collection has %d", ErrInvalidQuery, i, len(embedding), dimension)
+		}
+	}
+
+	var candidates map[primitive.ObjectID]struct{}
+	if filter != nil && len(collection.Indexes) > 0 {
+		var indexed bool
+		candidates, indexed, err = s.indexCandidates(collection, filter)
+		if err != nil {
+			return nil, fmt.Errorf("[DB_SERVICE:QueryCollectionBatch] - %w", err)
+		}
+		if indexed && len(candidates) <= exactSearchMaxCandidates {
+			return s.exactSearchBatch(collection, candidates, filter, query)
+		}
+	}
+
+	handle, err := s.acquireVectorIndex(collection, collectionDefKey, false)
+	if errors.Is(err, errNoVectorIndex) {
+		return results, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollectionBatch] - %w", err)
+	}
+	defer handle.release()
+	idx := handle.idx
+
+	trained, err := idx.IsTrained()
+	if err != nil {
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollectionBatch] - failed to read vector index training state: %w", err)
+	}
+	if !trained {
+		if candidates == nil {
+			if candidates, err = s.collectionIDs(collection); err != nil {
+				return nil, fmt.Errorf("[DB_SERVICE:QueryCollectionBatch] - %w", err)
+			}
+		}
+		return s.exactSearchBatch(collection, candidates, filter, query)
+	}
+
+	nTotal, err := idx.NTotal()
+	if err != nil {
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollectionBatch] - failed to read vector index size: %w", err)
+	}
+
+	sess, err := s.KvService.OpenSession()
+	if err != nil {
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollectionBatch] - failed to open session: %w", err)
+	}
+	defer sess.Close()
+
+	// Resolve every result set against the same snapshot. The transaction
+	// only reads, so closing the session rolls it back.
+	if err := sess.Begin(wt.IsolationSnapshot); err != nil {
+		return nil, fmt.Errorf("[DB_SERVICE:QueryCollectionBatch] - failed to begin transaction: %w", err)
+	}
+
+	metric := collection.VectorIndex.withDefaults().Metric
+
+	// As in QueryCollection, widen the search while filters or tombstones
+	// leave a query short of TopK. Only the queries that came up short are
+	// searched again.
+	k := int(query.TopK)
+	if filter != nil {
+		k *= filterOverFetchFactor
+	}
+
+	pending := make([]int, len(query.QueryEmbeddings))
+	for i := range pending {
+		pending[i] = i
+	}
+
+	var lastErr error
+	for len(pending) > 0 {
+		if int64(k) > nTotal {
+			k = int(nTotal)
+		}
+
+		queryVectors := make([]float32, 0, len(pending)*dimension)
+		for _, q := range pending {
+			queryVectors = append(queryVectors, indexVector(metric, query.QueryEmbeddings[q])...)
+		}
+
+		distances, ids, err := idx.Search(queryVectors, len(pending), k)
+		if err != nil {
+			return nil, fmt.Errorf("[DB_SERVICE:QueryCollectionBatch] - failed to search vector index for %d query embeddings: %w", len(pending), err)
+		}
+		log.Debug("searched vector index", "collection", collectionDefKey, "queries", len(pending), "k", k)
+
+		var short []int
+		for j, q := range pending {
+			hits, skipped, hitErr, err := s.resolveHits(sess, collection, collectionDefKey, candidates, filter, query.single(q), distances[j*k:(j+1)*k], ids[j*k:(j+1)*k])
+			if err != nil {
+				return nil, fmt.Errorf("[DB_SERVICE:QueryCollectionBatch] - %w", err)
+			}
+			if hitErr != nil {
+				lastErr = hitErr
+			}
+			results[q] = hits
+
+			if skipped > 0 && len(hits) < int(query.TopK) && int64(k) < nTotal {
+				short = append(short, q)
+			}
+		}
+
+		pending = short
+		k *= 2
+	}
+
+	return results, lastErr
+}
+
+// exactSearchBatch runs exactSearch for each query of a batch.
+func (s *GDBService) exactSearchBatch(collection CollectionCatalogEntry, candidates map[primitive.ObjectID]struct{}, filter Filter, query BatchQueryStruct) ([][]QueryResult, error) {
+	results := make([][]QueryResult, len(query.QueryEmbeddings))
+	for i := range query.QueryEmbeddings {
+		hits, err := s.exactSearch(collection, candidates, filter, query.single(i))
+		if err != nil {
+			return nil, fmt.Errorf("[DB_SERVICE:QueryCollectionBatch] - %w", err)
+		}
+		results[i] = hits
+	}
+	return results, nil
+}
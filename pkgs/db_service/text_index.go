@@ -0,0 +1,489 @@
+package dbservice
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	wt "glowstickdb/pkgs/wiredtiger"
+	"math"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The text index is an inverted index over GlowstickDocument.Content, kept in
+// one key_format=u table per collection and updated in the same transaction as
+// the documents it covers. It holds four kinds of rows:
+//
+//   - postings:   'p' <term> 0x00 <12-byte _id> -> term frequency (uvarint), so
+//     every posting of a term is one prefix scan;
+//   - dictionary: 'd' <term> -> number of documents containing it (uvarint),
+//     the sorted term list fuzzy queries walk;
+//   - lengths:    'l' <12-byte _id> -> document length in terms (uvarint);
+//   - stats:      's' -> BSON textIndexStats, the corpus totals BM25 needs.
+//
+// Terms are produced by the collection's Analyzer and never contain 0x00.
+const (
+	textTagPosting    = 'p'
+	textTagDictionary = 'd'
+	textTagLength     = 'l'
+	textTagStats      = 's'
+)
+
+// BM25 parameters: term frequency saturation and document length normalization.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// textIndexStats is the value of a text index's stats row.
+type textIndexStats struct {
+	DocCount   int64 `bson:"doc_count"`
+	TokenCount int64 `bson:"token_count"`
+}
+
+func textPostingPrefix(term string) []byte {
+	key := make([]byte, 0, len(term)+2+12)
+	key = append(key, textTagPosting)
+	key = append(key, term...)
+	return append(key, 0x00)
+}
+
+func textPostingKey(term string, id primitive.ObjectID) []byte {
+	return append(textPostingPrefix(term), id[:]...)
+}
+
+func textDictionaryKey(term string) []byte {
+	return append([]byte{textTagDictionary}, term...)
+}
+
+// adjustDocFrequency adds delta to a term's dictionary entry, removing the
+// entry once no document contains the term.
+func adjustDocFrequency(sess wt.Session, tableUri string, term string, delta int64) error {
+	key := textDictionaryKey(term)
+	val, _, err := sess.GetBinary(tableUri, key)
+	if err != nil {
+		return fmt.Errorf("failed to read text dictionary: %w", err)
+	}
+	df, _ := binary.Uvarint(val)
+
+	if next := int64(df) + delta; next > 0 {
+		err = sess.PutBinary(tableUri, key, binary.AppendUvarint(nil, uint64(next)))
+	} else {
+		err = sess.DeleteBinary(tableUri, key)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update text dictionary: %w", err)
+	}
+	return nil
+}
+
+func textLengthKey(id primitive.ObjectID) []byte {
+	return append([]byte{textTagLength}, id[:]...)
+}
+
+// termFrequencies analyzes content and counts each term. total is the number of
+// terms, i.e. the document length.
+func termFrequencies(analyzer Analyzer, content string) (freqs map[string]uint64, total int) {
+	terms := analyzer.Analyze(content)
+	freqs = make(map[string]uint64, len(terms))
+	for _, term := range terms {
+		freqs[term]++
+	}
+	return freqs, len(terms)
+}
+
+func readTextStats(sess wt.Session, tableUri string) (textIndexStats, error) {
+	var stats textIndexStats
+	val, found, err := sess.GetBinary(tableUri, []byte{textTagStats})
+	if err != nil {
+		return stats, fmt.Errorf("failed to read text index stats: %w", err)
+	}
+	if found {
+		if err := bson.Unmarshal(val, &stats); err != nil {
+			return stats, fmt.Errorf("failed to decode text index stats: %w", err)
+		}
+	}
+	return stats, nil
+}
+
+func writeTextStats(sess wt.Session, tableUri string, stats textIndexStats) error {
+	val, err := bson.Marshal(stats)
+	if err != nil {
+		return fmt.Errorf("failed to encode text index stats: %w", err)
+	}
+	if err := sess.PutBinary(tableUri, []byte{textTagStats}, val); err != nil {
+		return fmt.Errorf("failed to write text index stats: %w", err)
+	}
+	return nil
+}
+
+// indexText adds a document's content to its collection's text index.
+func indexText(sess wt.Session, collection CollectionCatalogEntry, doc GlowstickDocument) error {
+	if collection.TextIndexUri == "" {
+		return nil
+	}
+	analyzer, err := lookupAnalyzer(collection.TextAnalyzer)
+	if err != nil {
+		return err
+	}
+
+	freqs, length := termFrequencies(analyzer, doc.Content)
+	for term, tf := range freqs {
+		if err := sess.PutBinary(collection.TextIndexUri, textPostingKey(term, doc.ID), binary.AppendUvarint(nil, tf)); err != nil {
+			return fmt.Errorf("failed to update text index: %w", err)
+		}
+		if err := adjustDocFrequency(sess, collection.TextIndexUri, term, 1); err != nil {
+			return err
+		}
+	}
+	if err := sess.PutBinary(collection.TextIndexUri, textLengthKey(doc.ID), binary.AppendUvarint(nil, uint64(length))); err != nil {
+		return fmt.Errorf("failed to update text index: %w", err)
+	}
+
+	stats, err := readTextStats(sess, collection.TextIndexUri)
+	if err != nil {
+		return err
+	}
+	stats.DocCount++
+	stats.TokenCount += int64(length)
+	return writeTextStats(sess, collection.TextIndexUri, stats)
+}
+
+// unindexText removes a stored document from its collection's text index. The
+// postings are found by analyzing the stored content again.
+func unindexText(sess wt.Session, collection CollectionCatalogEntry, doc GlowstickDocument) error {
+	if collection.TextIndexUri == "" {
+		return nil
+	}
+	analyzer, err := lookupAnalyzer(collection.TextAnalyzer)
+	if err != nil {
+		return err
+	}
+
+	// A document written while the index was being migrated may never have
+	// been added; removing it must not skew the corpus stats.
+	indexed, err := sess.ExistsBinary(collection.TextIndexUri, textLengthKey(doc.ID))
+	if err != nil {
+		return fmt.Errorf("failed to read text index: %w", err)
+	}
+	if !indexed {
+		return nil
+	}
+
+	freqs, length := termFrequencies(analyzer, doc.Content)
+	for term := range freqs {
+		if err := sess.DeleteBinary(collection.TextIndexUri, textPostingKey(term, doc.ID)); err != nil {
+			return fmt.Errorf("failed to update text index: %w", err)
+		}
+		if err := adjustDocFrequency(sess, collection.TextIndexUri, term, -1); err != nil {
+			return err
+		}
+	}
+	if err := sess.DeleteBinary(collection.TextIndexUri, textLengthKey(doc.ID)); err != nil {
+		return fmt.Errorf("failed to update text index: %w", err)
+	}
+
+	stats, err := readTextStats(sess, collection.TextIndexUri)
+	if err != nil {
+		return err
+	}
+	stats.DocCount--
+	stats.TokenCount -= int64(length)
+	return writeTextStats(sess, collection.TextIndexUri, stats)
+}
+
+// migrateTextIndex gives a collection created before text search existed its
+// text index, built from the documents already stored.
+func (s *GDBService) migrateTextIndex(collection *CollectionCatalogEntry, collectionDefKey string) error {
+	if collection.TextIndexUri != "" {
+		return nil
+	}
+
+	kv := s.KvService
+	tableUri := fmt.Sprintf("table:text-%s-%s", collection.Id.Hex(), s.Name)
+	if err := kv.CreateTable(tableUri, "key_format=u,value_format=u"); err != nil {
+		return fmt.Errorf("text index migration failed to create table %s: %v", tableUri, err)
+	}
+
+	migrated := *collection
+	migrated.TextIndexUri = tableUri
+	migrated.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	entry, err := bson.Marshal(migrated)
+	if err != nil {
+		return fmt.Errorf("text index migration failed to encode catalog entry: %v", err)
+	}
+
+	err = s.withTransaction(func(sess wt.Session) error {
+		// Start from empty stats so a migration interrupted before commit
+		// (or raced by another one) does not count documents twice.
+		if err := writeTextStats(sess, tableUri, textIndexStats{}); err != nil {
+			return err
+		}
+
+		cursor, err := kv.ScanRangeBinary(collection.TableUri, nil, nil)
+		if err != nil {
+			return fmt.Errorf("text index migration failed to scan %s: %w", collection.TableUri, err)
+		}
+		defer cursor.Close()
+
+		for cursor.Next() {
+			key, docBin, err := cursor.Current()
+			if err != nil {
+				return err
+			}
+			var doc GlowstickDocument
+			if err := bson.Unmarshal(docBin, &doc); err != nil {
+				return fmt.Errorf("failed to unmarshal BSON for docID %x: %w", key, err)
+			}
+			copy(doc.ID[:], key)
+
+			if err := indexText(sess, migrated, doc); err != nil {
+				return err
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			return fmt.Errorf("text index migration scan failed: %w", err)
+		}
+
+		return sess.PutBinaryWithStringKey(CATALOG, collectionDefKey, entry)
+	})
+	if err != nil {
+		return fmt.Errorf("text index migration failed: %w", err)
+	}
+
+	*collection = migrated
+	return nil
+}
+
+// SearchText ranks a collection's documents against a keyword query with BM25
+// over their Content. Documents match if they contain any query term; up to
+// TopK of those passing the filter are returned, best first.
+func (s *GDBService) SearchText(collection_name string, query TextQueryStruct) ([]TextResult, error) {
+	if query.TopK <= 0 {
+		return nil, fmt.Errorf("[DB_SERVICE:SearchText] - %w: TopK must be positive, got %d", ErrInvalidQuery, query.TopK)
+	}
+
+	defer s.rlockCollection(collection_name)()
+
+	filter, err := CompileFilter(query.Filters)
+	if err != nil {
+		return nil, fmt.Errorf("[DB_SERVICE:SearchText] - %w: %v", ErrInvalidFilter, err)
+	}
+
+	collection, _, err := s.getCollection(collection_name)
+	if err != nil {
+		return nil, fmt.Errorf("[DB_SERVICE:SearchText] - %w", err)
+	}
+
+	scores, err := s.textScores(collection, query.Text, query.Fuzziness, query.Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("[DB_SERVICE:SearchText] - %w", err)
+	}
+
+	results, err := s.rankTextResults(collection, scores, filter, int(query.TopK))
+	if err != nil {
+		return nil, fmt.Errorf("[DB_SERVICE:SearchText] - %w", err)
+	}
+	return results, nil
+}
+
+// textScores analyzes text with the collection's analyzer and returns the BM25
+// score of every document matching any of its terms. With maxEdits > 0 or
+// prefix set, each term also matches the dictionary terms it expands to.
+func (s *GDBService) textScores(collection CollectionCatalogEntry, text string, maxEdits int, prefix bool) (map[primitive.ObjectID]float64, error) {
+	if maxEdits < 0 || maxEdits > maxFuzzyEdits {
+		return nil, fmt.Errorf("%w: fuzziness must be between 0 and %d, got %d", ErrInvalidQuery, maxFuzzyEdits, maxEdits)
+	}
+
+	analyzer, err := lookupAnalyzer(collection.TextAnalyzer)
+	if err != nil {
+		return nil, err
+	}
+
+	terms, _ := termFrequencies(analyzer, text)
+	groups := make([]termGroup, 0, len(terms))
+	for term := range terms {
+		if maxEdits == 0 && !prefix {
+			groups = append(groups, termGroup{term: 1})
+			continue
+		}
+		group, err := s.expandTerm(collection, term, maxEdits, prefix)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return s.bm25Scores(collection, groups)
+}
+
+// rankTextResults orders scored documents best first and fetches them until
+// topK pass the filter, which may be nil.
+func (s *GDBService) rankTextResults(collection CollectionCatalogEntry, scores map[primitive.ObjectID]float64, filter Filter, topK int) ([]TextResult, error) {
+	results := []TextResult{}
+	if topK <= 0 {
+		return results, nil
+	}
+
+	ranked := make([]primitive.ObjectID, 0, len(scores))
+	for id := range scores {
+		ranked = append(ranked, id)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if scores[ranked[i]] != scores[ranked[j]] {
+			return scores[ranked[i]] > scores[ranked[j]]
+		}
+		return bytes.Compare(ranked[i][:], ranked[j][:]) < 0
+	})
+
+	// Documents are only fetched, and filtered, in rank order until topK pass.
+	for _, id := range ranked {
+		docBin, found, err := s.KvService.GetBinary(collection.TableUri, id[:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to get document %s: %w", id.Hex(), err)
+		}
+		if !found {
+			continue
+		}
+
+		var doc GlowstickDocument
+		if err := bson.Unmarshal(docBin, &doc); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal BSON for docID %s: %w", id.Hex(), err)
+		}
+		doc.ID = id
+
+		if filter != nil && !filter.Match(doc.Metadata) {
+			continue
+		}
+
+		results = append(results, TextResult{Document: doc, Score: float32(scores[id])})
+		if len(results) == topK {
+			break
+		}
+	}
+
+	return results, nil
+}
+
+// termGroup is the set of index terms one query term matches, each with the
+// weight its BM25 contribution is scaled by.
+type termGroup map[string]float64
+
+// bm25Corpus reads the postings and document lengths of one text index and
+// remembers the lengths it has already looked up.
+type bm25Corpus struct {
+	kv        wt.WTService
+	tableUri  string
+	n         float64 // documents in the index
+	avgLength float64
+	lengths   map[primitive.ObjectID]float64
+}
+
+func (c *bm25Corpus) docLength(id primitive.ObjectID) (float64, error) {
+	if length, ok := c.lengths[id]; ok {
+		return length, nil
+	}
+	val, _, err := c.kv.GetBinary(c.tableUri, textLengthKey(id))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read text length of %s: %w", id.Hex(), err)
+	}
+	length, _ := binary.Uvarint(val)
+	c.lengths[id] = float64(length)
+	return c.lengths[id], nil
+}
+
+// termScores returns the BM25 contribution of term to every document containing it.
+func (c *bm25Corpus) termScores(term string) (map[primitive.ObjectID]float64, error) {
+	tfs := map[primitive.ObjectID]float64{}
+
+	prefix := textPostingPrefix(term)
+	cursor, err := c.kv.ScanRangeBinary(c.tableUri, prefix, prefixEnd(prefix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan postings of %q: %w", term, err)
+	}
+	defer cursor.Close()
+
+	for cursor.Next() {
+		key, val, err := cursor.Current()
+		if err != nil {
+			return nil, err
+		}
+		var id primitive.ObjectID
+		copy(id[:], key[len(prefix):])
+		tf, _ := binary.Uvarint(val)
+		tfs[id] = float64(tf)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("postings scan of %q failed: %w", term, err)
+	}
+
+	df := float64(len(tfs))
+	idf := math.Log(1 + (c.n-df+0.5)/(df+0.5))
+
+	scores := make(map[primitive.ObjectID]float64, len(tfs))
+	for id, tf := range tfs {
+		length, err := c.docLength(id)
+		if err != nil {
+			return nil, err
+		}
+		norm := bm25K1 * (1 - bm25B + bm25B*length/c.avgLength)
+		scores[id] = idf * tf * (bm25K1 + 1) / (tf + norm)
+	}
+	return scores, nil
+}
+
+// bm25Scores returns the BM25 score of every document containing at least one
+// term of groups. A document scores the sum, over groups, of its best weighted
+// term in each group, so a query term expanding to several variants present in
+// one document does not count several times.
+func (s *GDBService) bm25Scores(collection CollectionCatalogEntry, groups []termGroup) (map[primitive.ObjectID]float64, error) {
+	kv := s.KvService
+
+	statsBin, found, err := kv.GetBinary(collection.TextIndexUri, []byte{textTagStats})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read text index stats: %w", err)
+	}
+	var stats textIndexStats
+	if found {
+		if err := bson.Unmarshal(statsBin, &stats); err != nil {
+			return nil, fmt.Errorf("failed to decode text index stats: %w", err)
+		}
+	}
+
+	scores := map[primitive.ObjectID]float64{}
+	if stats.DocCount <= 0 {
+		return scores, nil
+	}
+
+	corpus := &bm25Corpus{
+		kv:        kv,
+		tableUri:  collection.TextIndexUri,
+		n:         float64(stats.DocCount),
+		avgLength: float64(stats.TokenCount) / float64(stats.DocCount),
+		lengths:   map[primitive.ObjectID]float64{},
+	}
+	if corpus.avgLength == 0 {
+		corpus.avgLength = 1
+	}
+
+	for _, group := range groups {
+		best := map[primitive.ObjectID]float64{}
+		for term, weight := range group {
+			termScores, err := corpus.termScores(term)
+			if err != nil {
+				return nil, err
+			}
+			for id, score := range termScores {
+				best[id] = max(best[id], weight*score)
+			}
+		}
+		for id, score := range best {
+			scores[id] += score
+		}
+	}
+
+	return scores, nil
+}
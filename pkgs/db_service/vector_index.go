@@ -0,0 +1,251 @@
+package dbservice
+
+import (
+	"errors"
+	"fmt"
+	bsonvalidator "glowstickdb/pkgs/bson-validator"
+	"glowstickdb/pkgs/embedder"
+	"glowstickdb/pkgs/faiss"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+// Metrics a collection's vector index can be built with. Cosine collections
+// use an inner-product index over unit-length vectors: embeddings are
+// normalized before they reach the index and so are query embeddings.
+const (
+	MetricL2           = "l2"
+	MetricInnerProduct = "ip"
+	MetricCosine       = "cosine"
+)
+
+const defaultIndexFactory = "Flat"
+
+// FAISS warns when a k-means quantizer is trained on fewer than 39 points per
+// centroid, so that is the default amount of data buffered before training.
+const minTrainPointsPerCentroid = 39
+
+// CollectionOptions configures a collection's vector and text indexes at creation time.
+// The zero value builds an exact L2 ("Flat") index whose dimension is taken
+// from the first inserted document.
+type CollectionOptions struct {
+	Dimension    int    // embedding length; 0 to infer it from the first insert
+	Metric       string // MetricL2 (default), MetricInnerProduct or MetricCosine
+	IndexType    string // FAISS index_factory description, e.g. "HNSW32", "IVF1024,PQ16", "IVF256,Flat"
+	TrainSize    int    // vectors to buffer before training IVF/PQ indexes; 0 for the FAISS recommended minimum
+	TextAnalyzer string // AnalyzerStandard (default) or AnalyzerSimple, for the text index over Content
+
+	// Embedder embeds documents written, and queries made, with Content but
+	// no embedding. Its dimension, if known, becomes the collection's.
+	Embedder *embedder.Config
+
+	// Schema, if set, is checked against every document inserted or updated,
+	// as stored: its fields are _id, key, content, embedding and metadata.
+	Schema *bsonvalidator.Schema
+}
+
+// VectorIndexConfig is the vector index section of a collection's catalog entry.
+type VectorIndexConfig struct {
+	Dimension int    `bson:"dimension"`
+	Metric    string `bson:"metric"`
+	Factory   string `bson:"factory"`
+	TrainSize int    `bson:"train_size,omitempty"`
+}
+
+// errNoVectorIndex is returned by openVectorIndex for a collection that has
+// neither an index file nor a known dimension, i.e. nothing was inserted yet.
+var errNoVectorIndex = errors.New("collection has no vector index yet")
+
+var (
+	ivfPattern = regexp.MustCompile(`IVF(\d+)`)
+	pqPattern  = regexp.MustCompile(`PQ(\d+)(?:x(\d+))?`)
+)
+
+func newVectorIndexConfig(opts CollectionOptions) (VectorIndexConfig, error) {
+	config := VectorIndexConfig{
+		Dimension: opts.Dimension,
+		Metric:    opts.Metric,
+		Factory:   opts.IndexType,
+		TrainSize: opts.TrainSize,
+	}.withDefaults()
+
+	if config.Dimension < 0 {
+		return config, fmt.Errorf("dimension must not be negative, got %d", config.Dimension)
+	}
+	if config.TrainSize < 0 {
+		return config, fmt.Errorf("train size must not be negative, got %d", config.TrainSize)
+	}
+	if _, err := faissMetric(config.Metric); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// withDefaults fills in the settings of collections created before indexes
+// were configurable, which all used an L2 "Flat" index.
+func (c VectorIndexConfig) withDefaults() VectorIndexConfig {
+	if c.Metric == "" {
+		c.Metric = MetricL2
+	}
+	if c.Factory == "" {
+		c.Factory = defaultIndexFactory
+	}
+	if c.TrainSize == 0 {
+		c.TrainSize = defaultTrainSize(c.Factory)
+	}
+	return c
+}
+
+func faissMetric(metric string) (faiss.MetricType, error) {
+	switch metric {
+	case MetricL2:
+		return faiss.MetricL2, nil
+	case MetricInnerProduct, MetricCosine:
+		return faiss.MetricInnerProduct, nil
+	}
+	return 0, fmt.Errorf("unsupported metric %q", metric)
+}
+
+// indexVector returns the form of an embedding that is stored in, or searched
+// against, an index with the given metric.
+func indexVector(metric string, embedding []float32) []float32 {
+	if metric != MetricCosine || len(embedding) == 0 {
+		return embedding
+	}
+	return faiss.FAISS().NormalizeBatch(embedding, len(embedding))
+}
+
+// metricDistance turns a value reported by a FAISS search into a distance,
+// where smaller is always closer: squared L2 as is, the negated inner product,
+// and 1 - cosine similarity for cosine.
+func metricDistance(metric string, raw float32) float32 {
+	switch metric {
+	case MetricInnerProduct:
+		return -raw
+	case MetricCosine:
+		return 1 - raw
+	}
+	return raw
+}
+
+// distanceScore maps a metricDistance onto [0, 1], higher is better:
+// 1/(1+d) for squared L2, the cosine similarity rescaled from [-1, 1], and the
+// logistic function of the inner product, which has no natural bound.
+func distanceScore(metric string, distance float32) float32 {
+	switch metric {
+	case MetricInnerProduct:
+		return float32(1 / (1 + math.Exp(float64(distance))))
+	case MetricCosine:
+		return min(max((2-distance)/2, 0), 1)
+	}
+	return 1 / (1 + distance)
+}
+
+// vectorDistance computes metricDistance between two raw embeddings without
+// going through the index.
+func vectorDistance(metric string, a, b []float32) float32 {
+	switch metric {
+	case MetricInnerProduct:
+		return metricDistance(metric, dot(a, b))
+	case MetricCosine:
+		return metricDistance(metric, dot(indexVector(metric, a), indexVector(metric, b)))
+	}
+	return l2DistanceSqr(a, b)
+}
+
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+// defaultTrainSize returns how many vectors an index built from factory needs
+// before it can be trained: enough for every IVF list and PQ codebook centroid.
+// Indexes that need no training never consult it.
+func defaultTrainSize(factory string) int {
+	size := 1
+	if m := ivfPattern.FindStringSubmatch(factory); m != nil {
+		nlist, _ := strconv.Atoi(m[1])
+		size = max(size, minTrainPointsPerCentroid*nlist)
+	}
+	if m := pqPattern.FindStringSubmatch(factory); m != nil {
+		nbits := 8
+		if m[2] != "" {
+			nbits, _ = strconv.Atoi(m[2])
+		}
+		size = max(size, minTrainPointsPerCentroid*(1<<nbits))
+	}
+	return size
+}
+
+func vectorIndexPath(collection CollectionCatalogEntry) (string, error) {
+	u, err := url.Parse(collection.VectorIndexUri)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse vector index URI: %v", err)
+	}
+	return u.Path, nil
+}
+
+// createVectorIndex builds an empty index from the collection's configuration
+// and writes it to the collection's index file.
+func createVectorIndex(collection CollectionCatalogEntry) (*faiss.Index, error) {
+	config := collection.VectorIndex.withDefaults()
+
+	metric, err := faissMetric(config.Metric)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := faiss.FAISS().IndexFactory(config.Dimension, config.Factory, metric)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %q vector index of dimension %d: %v", config.Factory, config.Dimension, err)
+	}
+
+	filePath, err := vectorIndexPath(collection)
+	if err != nil {
+		idx.Free()
+		return nil, err
+	}
+	if err := idx.WriteToFile(filePath); err != nil {
+		idx.Free()
+		return nil, fmt.Errorf("failed to persist new vector index to %s: %v", filePath, err)
+	}
+	return idx, nil
+}
+
+// openVectorIndex loads a collection's index, creating it if the collection
+// has a dimension but no index file yet, and replays the vector WAL into it.
+// pending is the number of vectors still buffered in the WAL because the index
+// is waiting for enough of them to be trained.
+func (s *GDBService) openVectorIndex(collection CollectionCatalogEntry, collectionDefKey string) (idx *faiss.Index, filePath string, pending int64, err error) {
+	lock := s.collectionLock(collectionDefKey)
+	lock.load.Lock()
+	defer lock.load.Unlock()
+
+	filePath, err = vectorIndexPath(collection)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	idx, err = faiss.FAISS().ReadIndex(filePath)
+	if err != nil {
+		if collection.VectorIndex.Dimension == 0 {
+			return nil, filePath, 0, errNoVectorIndex
+		}
+		idx, err = createVectorIndex(collection)
+		if err != nil {
+			return nil, filePath, 0, err
+		}
+	}
+
+	pending, err = s.replayVectorWal(idx, collectionDefKey, filePath, collection.VectorIndex.withDefaults().TrainSize)
+	if err != nil {
+		idx.Free()
+		return nil, filePath, 0, fmt.Errorf("failed to recover vector index: %w", err)
+	}
+	return idx, filePath, pending, nil
+}
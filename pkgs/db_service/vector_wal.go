@@ -0,0 +1,162 @@
+package dbservice
+
+import (
+	"fmt"
+	"glowstickdb/pkgs/faiss"
+	wt "glowstickdb/pkgs/wiredtiger"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The vector WAL couples the FAISS index file to the WiredTiger tables.
+//
+// Every vector added to a collection's index is first recorded in VECTOR_WAL,
+// in the same transaction that writes the document and its label mapping.
+// Only after that transaction commits is the index file rewritten, and only
+// after the file is on disk are the WAL rows removed. A crash at any point
+// therefore leaves one of two states, both of which replayVectorWal repairs
+// the next time the index is loaded:
+//
+//   - WAL rows with label >= ntotal: the vectors never reached the file and are
+//     re-added from the WAL, in label order.
+//   - WAL rows with label < ntotal: the file was written but the WAL was not
+//     cleared; the rows are simply dropped.
+//
+// Indexes that must be trained before use keep their vectors in the WAL until
+// there are enough to train on; see replayVectorWal.
+//
+// Keys are "<db>.<collection>/<zero-padded label>" so a collection's pending
+// entries sort by label and can be range-scanned by prefix. The value carries
+// the vector itself, so replay does not depend on the document still existing:
+// a document deleted before its vector reached the file must still occupy its
+// label.
+
+// VectorWalEntry is the BSON value of a VECTOR_WAL row.
+type VectorWalEntry struct {
+	DocID     primitive.ObjectID `bson:"doc_id"`
+	Embedding []float32          `bson:"embedding"`
+}
+
+// walKey returns the VECTOR_WAL key for a label in the given collection.
+func walKey(collectionDefKey string, label int64) []byte {
+	return []byte(fmt.Sprintf("%s/%020d", collectionDefKey, label))
+}
+
+// walRange returns the [start, end) key range covering every WAL entry of a collection.
+func walRange(collectionDefKey string) ([]byte, []byte) {
+	return []byte(collectionDefKey + "/"), []byte(collectionDefKey + "0") // '0' sorts right after '/'
+}
+
+// logVectorAdd records a pending vector add inside the caller's transaction.
+func logVectorAdd(sess wt.Session, collectionDefKey string, label int64, docID primitive.ObjectID, embedding []float32) error {
+	entry, err := bson.Marshal(VectorWalEntry{DocID: docID, Embedding: embedding})
+	if err != nil {
+		return fmt.Errorf("failed to encode vector WAL entry for label %d: %w", label, err)
+	}
+	if err := sess.PutBinary(VECTOR_WAL, walKey(collectionDefKey, label), entry); err != nil {
+		return fmt.Errorf("failed to write vector WAL entry for label %d: %w", label, err)
+	}
+	return nil
+}
+
+// checkpointVectorWal removes the WAL entries for labels that are now durable in the index file.
+func (s *GDBService) checkpointVectorWal(collectionDefKey string, labels []int64) error {
+	if len(labels) == 0 {
+		return nil
+	}
+	return s.withTransaction(func(sess wt.Session) error {
+		for _, label := range labels {
+			if err := sess.DeleteBinary(VECTOR_WAL, walKey(collectionDefKey, label)); err != nil {
+				return fmt.Errorf("failed to clear vector WAL entry for label %d: %w", label, err)
+			}
+		}
+		return nil
+	})
+}
+
+// replayVectorWal reconciles idx with the WAL of a collection. Pending vectors
+// that are missing from the index are re-added and the index is rewritten to
+// filePath before the WAL entries are cleared.
+//
+// An index that still needs training (IVF, PQ) cannot take vectors, so its WAL
+// doubles as the training buffer: entries stay pending until at least
+// trainSize of them have accumulated, at which point the index is trained on
+// all of them and they are added. The number of vectors left pending is returned.
+func (s *GDBService) replayVectorWal(idx *faiss.Index, collectionDefKey string, filePath string, trainSize int) (int64, error) {
+	start, end := walRange(collectionDefKey)
+	cursor, err := s.KvService.ScanRangeBinary(VECTOR_WAL, start, end)
+	if err != nil {
+		return 0, fmt.Errorf("failed to scan vector WAL: %w", err)
+	}
+	defer cursor.Close()
+
+	nTotal, err := idx.NTotal()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read vector index size: %w", err)
+	}
+
+	var labels []int64
+	var vectors []float32
+	missing := 0
+
+	for cursor.Next() {
+		key, val, err := cursor.Current()
+		if err != nil {
+			return 0, err
+		}
+
+		var label int64
+		if _, err := fmt.Sscanf(string(key[len(start):]), "%d", &label); err != nil {
+			return 0, fmt.Errorf("malformed vector WAL key %q: %w", key, err)
+		}
+		labels = append(labels, label)
+
+		// Already persisted before the crash; the entry is just stale.
+		if label < nTotal {
+			continue
+		}
+
+		if expected := nTotal + int64(missing); label != expected {
+			return 0, fmt.Errorf("vector WAL for %s has a gap: expected label %d, found %d", collectionDefKey, expected, label)
+		}
+
+		var entry VectorWalEntry
+		if err := bson.Unmarshal(val, &entry); err != nil {
+			return 0, fmt.Errorf("failed to decode vector WAL entry for label %d: %w", label, err)
+		}
+		vectors = append(vectors, entry.Embedding...)
+		missing++
+	}
+
+	if err := cursor.Err(); err != nil {
+		return 0, fmt.Errorf("vector WAL scan failed: %w", err)
+	}
+	cursor.Close()
+
+	if missing > 0 {
+		trained, err := idx.IsTrained()
+		if err != nil {
+			return 0, fmt.Errorf("failed to read vector index training state: %w", err)
+		}
+
+		if !trained {
+			if missing < trainSize {
+				return int64(missing), nil
+			}
+			if err := idx.Train(vectors, missing); err != nil {
+				return 0, fmt.Errorf("failed to train vector index on %d buffered vectors: %w", missing, err)
+			}
+		}
+
+		if err := idx.Add(vectors, missing); err != nil {
+			return 0, fmt.Errorf("failed to replay %d vectors from the WAL: %w", missing, err)
+		}
+
+		if err := idx.WriteToFile(filePath); err != nil {
+			return 0, fmt.Errorf("failed to persist replayed vector index: %w", err)
+		}
+	}
+
+	return 0, s.checkpointVectorWal(collectionDefKey, labels)
+}
@@ -0,0 +1,70 @@
+package embedder
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Embedder turns text into embedding vectors.
+type Embedder interface {
+	// Embed returns one vector per text, in order. Every vector has the same length.
+	Embed(texts []string) ([][]float32, error)
+
+	// Dimension is the length of the vectors Embed returns, or 0 if it is only
+	// known once something has been embedded.
+	Dimension() int
+}
+
+// Providers built into the package.
+const (
+	ProviderOpenAI = "openai" // HTTP to an OpenAI-compatible /embeddings endpoint
+	ProviderHash   = "hash"   // deterministic feature hashing of words, no model needed
+)
+
+// Config selects and configures an embedding provider. It is stored in a
+// collection's catalog entry, so it holds no secrets: API keys are read from
+// the environment variable named by APIKeyEnv when the embedder is built.
+type Config struct {
+	Provider  string `bson:"provider"`
+	Model     string `bson:"model,omitempty"`
+	BaseURL   string `bson:"base_url,omitempty"`    // e.g. "http://localhost:11434/v1"; defaults to OpenAI's API
+	APIKeyEnv string `bson:"api_key_env,omitempty"` // environment variable holding the API key
+	Dimension int    `bson:"dimension,omitempty"`   // vector length; ProviderHash defaults to 256
+}
+
+// Factory builds an Embedder from its configuration.
+type Factory func(config Config) (Embedder, error)
+
+var providers = map[string]Factory{
+	ProviderOpenAI: newOpenAI,
+	ProviderHash:   newHash,
+}
+
+// Register makes a provider available to New under name, replacing any
+// provider registered under the same name. It is meant to be called from init
+// functions and is not safe for concurrent use with New.
+func Register(name string, factory Factory) {
+	providers[name] = factory
+}
+
+// Providers returns the names of the registered providers, sorted.
+func Providers() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New builds the embedder described by config.
+func New(config Config) (Embedder, error) {
+	if config.Dimension < 0 {
+		return nil, fmt.Errorf("embedder dimension must not be negative, got %d", config.Dimension)
+	}
+	factory, ok := providers[config.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unsupported embedding provider %q", config.Provider)
+	}
+	return factory(config)
+}
@@ -0,0 +1,75 @@
+package embedder
+
+import (
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+const defaultHashDimension = 256
+
+// hashEmbedder embeds text by feature hashing: every lowercased word adds ±1
+// to the coordinate its hash selects, and the result is scaled to unit
+// length. Texts sharing words get similar vectors, which is all tests and
+// offline setups need from it.
+type hashEmbedder struct {
+	dimension int
+}
+
+func newHash(config Config) (Embedder, error) {
+	return NewHash(config.Dimension), nil
+}
+
+// NewHash returns a hashing embedder producing vectors of the given length,
+// 256 if it is not positive.
+func NewHash(dimension int) Embedder {
+	if dimension <= 0 {
+		dimension = defaultHashDimension
+	}
+	return &hashEmbedder{dimension: dimension}
+}
+
+func (h *hashEmbedder) Dimension() int {
+	return h.dimension
+}
+
+func (h *hashEmbedder) Embed(texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = h.embed(text)
+	}
+	return vectors, nil
+}
+
+func (h *hashEmbedder) embed(text string) []float32 {
+	vector := make([]float32, h.dimension)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, word := range words {
+		hasher := fnv.New64a()
+		hasher.Write([]byte(word))
+		sum := hasher.Sum64()
+
+		// The top bit picks the sign so colliding words tend to cancel out
+		// rather than pile up.
+		sign := float32(1)
+		if sum>>63 == 1 {
+			sign = -1
+		}
+		vector[sum%uint64(h.dimension)] += sign
+	}
+
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm > 0 {
+		scale := float32(1 / math.Sqrt(norm))
+		for i := range vector {
+			vector[i] *= scale
+		}
+	}
+	return vector
+}
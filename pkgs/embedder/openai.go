@@ -0,0 +1,144 @@
+package embedder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultOpenAIBaseURL = "https://api.openai.com/v1"
+	defaultOpenAIModel   = "text-embedding-3-small"
+
+	// openAIBatchSize is how many texts go into one request. OpenAI accepts
+	// more, but compatible servers are often stricter.
+	openAIBatchSize = 128
+	openAITimeout   = 60 * time.Second
+)
+
+// openAIEmbedder calls the /embeddings endpoint of OpenAI or any server
+// speaking the same protocol (vLLM, Ollama, LocalAI, ...).
+type openAIEmbedder struct {
+	url       string
+	model     string
+	apiKey    string
+	dimension int
+	client    *http.Client
+}
+
+type openAIRequest struct {
+	Model      string   `json:"model"`
+	Input      []string `json:"input"`
+	Dimensions int      `json:"dimensions,omitempty"`
+}
+
+type openAIResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+type openAIErrorResponse struct {
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func newOpenAI(config Config) (Embedder, error) {
+	e := &openAIEmbedder{
+		url:       strings.TrimSuffix(config.BaseURL, "/") + "/embeddings",
+		model:     config.Model,
+		dimension: config.Dimension,
+		client:    &http.Client{Timeout: openAITimeout},
+	}
+	if config.BaseURL == "" {
+		e.url = defaultOpenAIBaseURL + "/embeddings"
+	}
+	if e.model == "" {
+		e.model = defaultOpenAIModel
+	}
+	if config.APIKeyEnv != "" {
+		e.apiKey = os.Getenv(config.APIKeyEnv)
+		if e.apiKey == "" {
+			return nil, fmt.Errorf("environment variable %s holding the embedding API key is not set", config.APIKeyEnv)
+		}
+	}
+	return e, nil
+}
+
+func (e *openAIEmbedder) Dimension() int {
+	return e.dimension
+}
+
+func (e *openAIEmbedder) Embed(texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += openAIBatchSize {
+		batch, err := e.embedBatch(texts[start:min(start+openAIBatchSize, len(texts))])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+func (e *openAIEmbedder) embedBatch(texts []string) ([][]float32, error) {
+	body, err := json.Marshal(openAIRequest{Model: e.model, Input: texts, Dimensions: e.dimension})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode embedding request: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build embedding request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("embedding request to %s failed: %w", e.url, err)
+	}
+	defer resp.Body.Close()
+
+	payload, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedding response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr openAIErrorResponse
+		if json.Unmarshal(payload, &apiErr) == nil && apiErr.Error.Message != "" {
+			return nil, fmt.Errorf("embedding request failed with status %d: %s", resp.StatusCode, apiErr.Error.Message)
+		}
+		return nil, fmt.Errorf("embedding request failed with status %d", resp.StatusCode)
+	}
+
+	var decoded openAIResponse
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		return nil, fmt.Errorf("failed to decode embedding response: %w", err)
+	}
+	if len(decoded.Data) != len(texts) {
+		return nil, fmt.Errorf("embedding response has %d vectors for %d inputs", len(decoded.Data), len(texts))
+	}
+
+	// Results carry their input's index and are not guaranteed to be in order.
+	vectors := make([][]float32, len(texts))
+	for _, item := range decoded.Data {
+		if item.Index < 0 || item.Index >= len(texts) || vectors[item.Index] != nil {
+			return nil, fmt.Errorf("embedding response has an unexpected index %d", item.Index)
+		}
+		if len(item.Embedding) == 0 {
+			return nil, fmt.Errorf("embedding response has an empty vector for input %d", item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	return vectors, nil
+}
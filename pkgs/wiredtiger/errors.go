@@ -2,6 +2,11 @@ package wiredtiger
 
 import "errors"
 
+// ErrRollback is returned when WiredTiger aborts an operation because of a
+// conflict with a concurrent transaction. The transaction must be rolled back
+// and may be retried.
+var ErrRollback = errors.New("wiredtiger: transaction conflict, rollback required")
+
 func errNoCgo() error {
 	return errors.New("wiredtiger: cgo disabled or headers not found; enable cgo and install WiredTiger headers")
 }
@@ -2,10 +2,12 @@ package wiredtiger
 
 // Service provides a minimal API for interacting with WiredTiger.
 // This abstracts the underlying cgo implementation to allow testing and !cgo builds.
+// It is safe for concurrent use; the Sessions and cursors it hands out are not.
 type WTService interface {
 	Open(home string, config string) error
 	Close() error
 	CreateTable(name string, config string) error
+	DropTable(name string, config string) error // e.g. "force=true" to ignore a missing table
 	PutString(table string, key string, value string) error
 	GetString(table string, key string) (string, bool, error)
 	DeleteString(table string, key string) error
@@ -23,12 +25,78 @@ type WTService interface {
 	DeleteBinaryWithStringKey(table string, stringKey string) error
 	ScanRange(table string, startKey string, endKey string) (StringRangeCursor, error)
 	ScanRangeBinary(table string, startKey, endKey []byte) (BinaryRangeCursor, error)
+	OpenSession() (Session, error)
+	OpenBulkCursor(table string) (BulkCursor, error)
+	Checkpoint() error
 }
 
 func WiredTiger() WTService {
 	return WiredTigerService()
 }
 
+// WiredTigerWithOptions returns a WTService configured by opts.
+func WiredTigerWithOptions(opts ServiceOptions) WTService {
+	return WiredTigerServiceWithOptions(opts)
+}
+
+// DefaultSessionPoolSize is the number of idle sessions a WTService keeps for
+// reuse unless ServiceOptions says otherwise.
+const DefaultSessionPoolSize = 32
+
+// ServiceOptions configures a WTService.
+type ServiceOptions struct {
+	// SessionPoolSize bounds the idle sessions, each with a cursor per table
+	// it has used, kept for the single-key Put/Get/Delete/Exists calls. 0 means
+	// DefaultSessionPoolSize; a negative size disables pooling, so every call
+	// opens and closes a session and cursor of its own.
+	SessionPoolSize int
+}
+
+// IsolationLevel selects the isolation of a transaction started with Session.Begin.
+type IsolationLevel string
+
+const (
+	IsolationSnapshot        IsolationLevel = "snapshot"
+	IsolationReadCommitted   IsolationLevel = "read-committed"
+	IsolationReadUncommitted IsolationLevel = "read-uncommitted"
+)
+
+// Session wraps a single WiredTiger session so several operations can share one
+// transaction. Between Begin and Commit/Rollback every operation issued through
+// the session belongs to that transaction; outside of one, each operation
+// autocommits. Closing a session with an open transaction rolls it back.
+// A Session must not be used from more than one goroutine at a time.
+type Session interface {
+	Begin(isolation IsolationLevel) error
+	Commit() error
+	Rollback() error
+	InTransaction() bool
+	Close() error
+
+	PutString(table string, key string, value string) error
+	GetString(table string, key string) (string, bool, error)
+	DeleteString(table string, key string) error
+	Exists(table string, key string) (bool, error)
+	PutBinary(table string, key []byte, value []byte) error
+	GetBinary(table string, key []byte) ([]byte, bool, error)
+	DeleteBinary(table string, key []byte) error
+	ExistsBinary(table string, key []byte) (bool, error)
+	PutBinaryWithStringKey(table string, stringKey string, value []byte) error
+	GetBinaryWithStringKey(table string, stringKey string) ([]byte, bool, error)
+	DeleteBinaryWithStringKey(table string, stringKey string) error
+}
+
+// BulkCursor loads rows into a newly created, empty table with binary keys
+// and values, writing them out directly instead of through transactions.
+// Keys must be inserted in strictly increasing order. Until the cursor is
+// closed no other cursor can be opened on the table, and rows become visible
+// only once it is. They are not logged either: they are durable only after
+// the next Checkpoint.
+type BulkCursor interface {
+	Insert(key []byte, value []byte) error
+	Close() error
+}
+
 // KeyValuePair represents a string key/value row.
 type KeyValuePair struct {
 	Key   string
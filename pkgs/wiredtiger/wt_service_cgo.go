@@ -38,110 +38,176 @@ static int wt_create_wrap(WT_CONNECTION *conn, const char* name, const char* con
 	return err != 0 ? err : cerr;
 }
 
+static int wt_drop_wrap(WT_CONNECTION *conn, const char* name, const char* config) {
+	if (!conn || !name || !config) return -1;
+	WT_SESSION *session = NULL;
+	int err = conn->open_session(conn, NULL, NULL, &session);
+	if (err != 0) return err;
+	if (!session) return -1;
+	err = session->drop(session, name, config);
+	int cerr = session->close(session, NULL);
+	return err != 0 ? err : cerr;
+}
+
+static int wt_checkpoint_wrap(WT_CONNECTION *conn) {
+	if (!conn) return -1;
+	WT_SESSION *session = NULL;
+	int err = conn->open_session(conn, NULL, NULL, &session);
+	if (err != 0) return err;
+	if (!session) return -1;
+	err = session->checkpoint(session, NULL);
+	int cerr = session->close(session, NULL);
+	return err != 0 ? err : cerr;
+}
+
+// ============================================================================
+// SESSION & TRANSACTION OPERATIONS
+// ============================================================================
+
+static int wt_session_open(WT_CONNECTION *conn, WT_SESSION **session_out) {
+	if (!conn || !session_out) return -1;
+	int err = conn->open_session(conn, NULL, NULL, session_out);
+	if (err != 0) return err;
+	if (!*session_out) return -1;
+	return 0;
+}
+
+static int wt_session_close(WT_SESSION *session) {
+	if (!session) return -1;
+	return session->close(session, NULL);
+}
+
+static int wt_session_begin(WT_SESSION *session, const char* config) {
+	if (!session || !config) return -1;
+	return session->begin_transaction(session, config);
+}
+
+static int wt_session_commit(WT_SESSION *session) {
+	if (!session) return -1;
+	return session->commit_transaction(session, NULL);
+}
+
+static int wt_session_rollback(WT_SESSION *session) {
+	if (!session) return -1;
+	return session->rollback_transaction(session, NULL);
+}
+
 // ============================================================================
 // STRING KEY/VALUE OPERATIONS
 // ============================================================================
 
-static int wt_put_str(WT_CONNECTION *conn, const char* uri, const char* key, const char* val) {
-	if (!conn || !uri || !key || !val) return -1;
-    WT_SESSION *session = NULL;
-    WT_CURSOR *cursor = NULL;
-    int err = conn->open_session(conn, NULL, NULL, &session);
-    if (err != 0) return err;
-    if (!session) return -1;
-    err = session->open_cursor(session, uri, NULL, NULL, &cursor);
-    if (err != 0) { session->close(session, NULL); return err; }
-    if (!cursor) { session->close(session, NULL); return -1; }
+// The wt_cur_* helpers run one operation on a caller-owned cursor and reset it
+// afterwards, so that a cursor kept open between operations holds no position
+// or snapshot. The wt_sess_* helpers run against a caller-owned session so
+// that several operations can share one transaction.
+
+static int wt_cursor_open(WT_SESSION *session, const char* uri, WT_CURSOR **cursor_out) {
+	if (!session || !uri || !cursor_out) return -1;
+	int err = session->open_cursor(session, uri, NULL, NULL, cursor_out);
+	if (err != 0) return err;
+	if (!*cursor_out) return -1;
+	return 0;
+}
+
+static int wt_cursor_close(WT_CURSOR *cursor) {
+	if (!cursor) return -1;
+	return cursor->close(cursor);
+}
+
+static int wt_cur_put_str(WT_CURSOR *cursor, const char* key, const char* val) {
+	if (!cursor || !key || !val) return -1;
     cursor->set_key(cursor, key);
     cursor->set_value(cursor, val);
-    err = cursor->insert(cursor);
-    int cerr = cursor->close(cursor);
-    int serr = session->close(session, NULL);
-    if (err != 0) return err;
-    if (cerr != 0) return cerr;
-    return serr;
+    int err = cursor->insert(cursor);
+    int rerr = cursor->reset(cursor);
+    return err != 0 ? err : rerr;
 }
 
-static int wt_get_str(WT_CONNECTION *conn, const char* uri, const char* key, const char **outVal) {
-	if (!conn || !uri || !key || !outVal) return -1;
-    WT_SESSION *session = NULL;
-    WT_CURSOR *cursor = NULL;
-    int err = conn->open_session(conn, NULL, NULL, &session);
-    if (err != 0) return err;
-    if (!session) return -1;
-    err = session->open_cursor(session, uri, NULL, NULL, &cursor);
-    if (err != 0) { session->close(session, NULL); return err; }
-    if (!cursor) { session->close(session, NULL); return -1; }
+// On success *outVal is a malloc'd copy owned by the caller.
+static int wt_cur_get_str(WT_CURSOR *cursor, const char* key, char **outVal) {
+	if (!cursor || !key || !outVal) return -1;
+    *outVal = NULL;
     cursor->set_key(cursor, key);
-    err = cursor->search(cursor);
-    if (err != 0) { cursor->close(cursor); session->close(session, NULL); return err; }
+    int err = cursor->search(cursor);
+    if (err != 0) { cursor->reset(cursor); return err; }
     const char *val;
     err = cursor->get_value(cursor, &val);
-    if (err == 0) { *outVal = val; }
-    int cerr = cursor->close(cursor);
-    int serr = session->close(session, NULL);
-    if (err != 0) return err;
-    if (cerr != 0) return cerr;
-    return serr;
+    if (err == 0) {
+        *outVal = strdup(val);
+        if (!*outVal) err = -1;
+    }
+    int rerr = cursor->reset(cursor);
+    return err != 0 ? err : rerr;
 }
 
-static int wt_del_str(WT_CONNECTION *conn, const char* uri, const char* key) {
-	if (!conn || !uri || !key) return -1;
-    WT_SESSION *session = NULL;
+static int wt_cur_del_str(WT_CURSOR *cursor, const char* key) {
+	if (!cursor || !key) return -1;
+    cursor->set_key(cursor, key);
+    int err = cursor->remove(cursor);
+    int rerr = cursor->reset(cursor);
+    return err != 0 ? err : rerr;
+}
+
+static int wt_cur_exists_str(WT_CURSOR *cursor, const char* key, int *found) {
+	if (!cursor || !key || !found) return -1;
+    *found = 0;
+    cursor->set_key(cursor, key);
+    int err = cursor->search(cursor);
+    if (err == 0) *found = 1;
+    int rerr = cursor->reset(cursor);
+    if (err != 0 && err != WT_NOTFOUND) return err;
+    return rerr;
+}
+
+static int wt_sess_put_str(WT_SESSION *session, const char* uri, const char* key, const char* val) {
     WT_CURSOR *cursor = NULL;
-    int err = conn->open_session(conn, NULL, NULL, &session);
+    int err = wt_cursor_open(session, uri, &cursor);
     if (err != 0) return err;
-    if (!session) return -1;
-    err = session->open_cursor(session, uri, NULL, NULL, &cursor);
-    if (err != 0) { session->close(session, NULL); return err; }
-    if (!cursor) { session->close(session, NULL); return -1; }
-    cursor->set_key(cursor, key);
-    err = cursor->remove(cursor);
+    err = wt_cur_put_str(cursor, key, val);
     int cerr = cursor->close(cursor);
-    int serr = session->close(session, NULL);
+    return err != 0 ? err : cerr;
+}
+
+// On success *outVal is a malloc'd copy owned by the caller.
+static int wt_sess_get_str(WT_SESSION *session, const char* uri, const char* key, char **outVal) {
+	if (!outVal) return -1;
+    *outVal = NULL;
+    WT_CURSOR *cursor = NULL;
+    int err = wt_cursor_open(session, uri, &cursor);
     if (err != 0) return err;
-    if (cerr != 0) return cerr;
-    return serr;
+    err = wt_cur_get_str(cursor, key, outVal);
+    int cerr = cursor->close(cursor);
+    return err != 0 ? err : cerr;
 }
 
-static int wt_exists_str(WT_CONNECTION *conn, const char* uri, const char* key, int *found) {
-	if (!conn || !uri || !key || !found) return -1;
-    WT_SESSION *session = NULL;
+static int wt_sess_del_str(WT_SESSION *session, const char* uri, const char* key) {
     WT_CURSOR *cursor = NULL;
+    int err = wt_cursor_open(session, uri, &cursor);
+    if (err != 0) return err;
+    err = wt_cur_del_str(cursor, key);
+    int cerr = cursor->close(cursor);
+    return err != 0 ? err : cerr;
+}
+
+static int wt_sess_exists_str(WT_SESSION *session, const char* uri, const char* key, int *found) {
+	if (!found) return -1;
     *found = 0;
-    int err = conn->open_session(conn, NULL, NULL, &session);
+    WT_CURSOR *cursor = NULL;
+    int err = wt_cursor_open(session, uri, &cursor);
     if (err != 0) return err;
-    if (!session) return -1;
-    err = session->open_cursor(session, uri, NULL, NULL, &cursor);
-    if (err != 0) { session->close(session, NULL); return err; }
-    if (!cursor) { session->close(session, NULL); return -1; }
-    cursor->set_key(cursor, key);
-    err = cursor->search(cursor);
-    if (err == 0) *found = 1;
+    err = wt_cur_exists_str(cursor, key, found);
     int cerr = cursor->close(cursor);
-    int serr = session->close(session, NULL);
-    if (err != 0 && err != WT_NOTFOUND) return err;
-    if (cerr != 0) return cerr;
-    return serr;
+    return err != 0 ? err : cerr;
 }
 
 // ============================================================================
 // BINARY KEY/VALUE OPERATIONS
 // ============================================================================
 
-static int wt_put_bin(WT_CONNECTION *conn, const char* uri,
-                      const unsigned char* key, size_t key_len,
-                      const unsigned char* val, size_t val_len) {
-	if (!conn || !uri || !key || !val) return -1;
-    WT_SESSION *session = NULL;
-    WT_CURSOR *cursor = NULL;
-    int err = conn->open_session(conn, NULL, NULL, &session);
-    if (err != 0) return err;
-    if (!session) return -1;
-
-    err = session->open_cursor(session, uri, NULL, NULL, &cursor);
-    if (err != 0) { session->close(session, NULL); return err; }
-    if (!cursor) { session->close(session, NULL); return -1; }
+static int wt_cur_put_bin(WT_CURSOR *cursor,
+                          const unsigned char* key, size_t key_len,
+                          const unsigned char* val, size_t val_len) {
+	if (!cursor || !key || !val) return -1;
 
     WT_ITEM key_item;
     key_item.data = (void*)key;
@@ -153,18 +219,15 @@ static int wt_put_bin(WT_CONNECTION *conn, const char* uri,
     val_item.size = val_len;
     cursor->set_value(cursor, &val_item);
 
-    err = cursor->insert(cursor);
-    int cerr = cursor->close(cursor);
-    int serr = session->close(session, NULL);
-    if (err != 0) return err;
-    if (cerr != 0) return cerr;
-    return serr;
+    int err = cursor->insert(cursor);
+    int rerr = cursor->reset(cursor);
+    return err != 0 ? err : rerr;
 }
 
-static int wt_get_bin(WT_CONNECTION *conn, const char* uri,
-                      const unsigned char* key, size_t key_len,
-                      WT_ITEM *outVal) {
-	if (!conn || !uri || !key || !outVal) return -1;
+static int wt_cur_get_bin(WT_CURSOR *cursor,
+                          const unsigned char* key, size_t key_len,
+                          WT_ITEM *outVal) {
+	if (!cursor || !key || !outVal) return -1;
 
 	// Initialize output to safe values
 	outVal->data = NULL;
@@ -174,31 +237,14 @@ static int wt_get_bin(WT_CONNECTION *conn, const char* uri,
 		return -1; // Invalid key length
 	}
 
-    WT_SESSION *session = NULL;
-    WT_CURSOR *cursor = NULL;
-    int err = conn->open_session(conn, NULL, NULL, &session);
-    if (err != 0) return err;
-    if (!session) return -1;
-
-    err = session->open_cursor(session, uri, NULL, NULL, &cursor);
-    if (err != 0) {
-    	session->close(session, NULL);
-    	return err;
-    }
-    if (!cursor) {
-    	session->close(session, NULL);
-    	return -1;
-    }
-
     WT_ITEM key_item;
     key_item.data = (void*)key;
     key_item.size = key_len;
     cursor->set_key(cursor, &key_item);
 
-    err = cursor->search(cursor);
+    int err = cursor->search(cursor);
     if (err != 0) {
-    	cursor->close(cursor);
-    	session->close(session, NULL);
+    	cursor->reset(cursor);
     	return err;
     }
 
@@ -207,8 +253,7 @@ static int wt_get_bin(WT_CONNECTION *conn, const char* uri,
     if (err == 0 && val.data && val.size > 0) {
         outVal->data = malloc(val.size);
         if (!outVal->data) {
-            cursor->close(cursor);
-            session->close(session, NULL);
+            cursor->reset(cursor);
             return -1;
         }
         memcpy(outVal->data, val.data, val.size);
@@ -219,68 +264,89 @@ static int wt_get_bin(WT_CONNECTION *conn, const char* uri,
     	outVal->size = 0;
     }
 
-    int cerr = cursor->close(cursor);
-    int serr = session->close(session, NULL);
-    if (err != 0) return err;
-    if (cerr != 0) return cerr;
-    return serr;
+    int rerr = cursor->reset(cursor);
+    return err != 0 ? err : rerr;
 }
 
-static int wt_del_bin(WT_CONNECTION *conn, const char* uri,
-                      const unsigned char* key, size_t key_len) {
-	if (!conn || !uri || !key) return -1;
-    WT_SESSION *session = NULL;
-    WT_CURSOR *cursor = NULL;
-    int err = conn->open_session(conn, NULL, NULL, &session);
-    if (err != 0) return err;
-    if (!session) return -1;
-
-    err = session->open_cursor(session, uri, NULL, NULL, &cursor);
-    if (err != 0) { session->close(session, NULL); return err; }
-    if (!cursor) { session->close(session, NULL); return -1; }
+static int wt_cur_del_bin(WT_CURSOR *cursor,
+                          const unsigned char* key, size_t key_len) {
+	if (!cursor || !key) return -1;
 
     WT_ITEM key_item;
     key_item.data = (void*)key;
     key_item.size = key_len;
     cursor->set_key(cursor, &key_item);
 
-    err = cursor->remove(cursor);
-    int cerr = cursor->close(cursor);
-    int serr = session->close(session, NULL);
-    if (err != 0) return err;
-    if (cerr != 0) return cerr;
-    return serr;
+    int err = cursor->remove(cursor);
+    int rerr = cursor->reset(cursor);
+    return err != 0 ? err : rerr;
 }
 
-static int wt_exists_bin(WT_CONNECTION *conn, const char* uri,
-                         const unsigned char* key, size_t key_len,
-                         int *found) {
-	if (!conn || !uri || !key || !found) return -1;
-    WT_SESSION *session = NULL;
-    WT_CURSOR *cursor = NULL;
+static int wt_cur_exists_bin(WT_CURSOR *cursor,
+                             const unsigned char* key, size_t key_len,
+                             int *found) {
+	if (!cursor || !key || !found) return -1;
     *found = 0;
 
-    int err = conn->open_session(conn, NULL, NULL, &session);
-    if (err != 0) return err;
-    if (!session) return -1;
-
-    err = session->open_cursor(session, uri, NULL, NULL, &cursor);
-    if (err != 0) { session->close(session, NULL); return err; }
-    if (!cursor) { session->close(session, NULL); return -1; }
-
     WT_ITEM key_item;
     key_item.data = (void*)key;
     key_item.size = key_len;
     cursor->set_key(cursor, &key_item);
 
-    err = cursor->search(cursor);
+    int err = cursor->search(cursor);
     if (err == 0) *found = 1;
 
-    int cerr = cursor->close(cursor);
-    int serr = session->close(session, NULL);
+    int rerr = cursor->reset(cursor);
     if (err != 0 && err != WT_NOTFOUND) return err;
-    if (cerr != 0) return cerr;
-    return serr;
+    return rerr;
+}
+
+static int wt_sess_put_bin(WT_SESSION *session, const char* uri,
+                           const unsigned char* key, size_t key_len,
+                           const unsigned char* val, size_t val_len) {
+    WT_CURSOR *cursor = NULL;
+    int err = wt_cursor_open(session, uri, &cursor);
+    if (err != 0) return err;
+    err = wt_cur_put_bin(cursor, key, key_len, val, val_len);
+    int cerr = cursor->close(cursor);
+    return err != 0 ? err : cerr;
+}
+
+static int wt_sess_get_bin(WT_SESSION *session, const char* uri,
+                           const unsigned char* key, size_t key_len,
+                           WT_ITEM *outVal) {
+	if (!outVal) return -1;
+	outVal->data = NULL;
+	outVal->size = 0;
+    WT_CURSOR *cursor = NULL;
+    int err = wt_cursor_open(session, uri, &cursor);
+    if (err != 0) return err;
+    err = wt_cur_get_bin(cursor, key, key_len, outVal);
+    int cerr = cursor->close(cursor);
+    return err != 0 ? err : cerr;
+}
+
+static int wt_sess_del_bin(WT_SESSION *session, const char* uri,
+                           const unsigned char* key, size_t key_len) {
+    WT_CURSOR *cursor = NULL;
+    int err = wt_cursor_open(session, uri, &cursor);
+    if (err != 0) return err;
+    err = wt_cur_del_bin(cursor, key, key_len);
+    int cerr = cursor->close(cursor);
+    return err != 0 ? err : cerr;
+}
+
+static int wt_sess_exists_bin(WT_SESSION *session, const char* uri,
+                              const unsigned char* key, size_t key_len,
+                              int *found) {
+	if (!found) return -1;
+    *found = 0;
+    WT_CURSOR *cursor = NULL;
+    int err = wt_cursor_open(session, uri, &cursor);
+    if (err != 0) return err;
+    err = wt_cur_exists_bin(cursor, key, key_len, found);
+    int cerr = cursor->close(cursor);
+    return err != 0 ? err : cerr;
 }
 
 // ============================================================================
@@ -1097,26 +1163,88 @@ static int wt_range_scan_next_batch_bin(wt_range_ctx_bin_t* ctx, size_t max_buf_
 
 static void wt_free_batch_buf_bin(unsigned char *buf) { if (buf) free(buf); }
 
+// ============================================================================
+// BULK LOAD OPERATIONS
+// ============================================================================
+
+// A bulk cursor writes a newly created, empty table straight into its file.
+// WiredTiger takes its keys in strictly increasing order only, and keeps the
+// table to the cursor until it is closed.
+
+static int wt_bulk_open(WT_CONNECTION *conn, const char* uri, WT_SESSION **session_out, WT_CURSOR **cursor_out) {
+	if (!conn || !uri || !session_out || !cursor_out) return -1;
+	*session_out = NULL;
+	*cursor_out = NULL;
+	int err = wt_session_open(conn, session_out);
+	if (err != 0) return err;
+	err = (*session_out)->open_cursor(*session_out, uri, NULL, "bulk=true", cursor_out);
+	if (err == 0 && !*cursor_out) err = -1;
+	if (err != 0) {
+		(*session_out)->close(*session_out, NULL);
+		*session_out = NULL;
+		return err;
+	}
+	return 0;
+}
+
+static int wt_bulk_insert(WT_CURSOR *cursor,
+                          const unsigned char* key, size_t key_len,
+                          const unsigned char* val, size_t val_len) {
+	if (!cursor || !key || !val) return -1;
+
+    WT_ITEM key_item;
+    key_item.data = (void*)key;
+    key_item.size = key_len;
+    cursor->set_key(cursor, &key_item);
+
+    WT_ITEM val_item;
+    val_item.data = (void*)val;
+    val_item.size = val_len;
+    cursor->set_value(cursor, &val_item);
+
+    return cursor->insert(cursor);
+}
+
 */
 import "C"
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"sync"
 	"unsafe"
 )
 
+// cgoService is safe for concurrent use. WiredTiger connections are
+// thread-safe and every call below works on a session of its own, opened for
+// the call or taken from pool, so calls only share conn: they hold mu for
+// reading while they use it, and Open and Close hold it for writing, so Close
+// waits for calls in flight. Sessions and range cursors outlive the call that
+// opened them and must be closed before the connection.
 type cgoService struct {
+	mu   sync.RWMutex
 	conn *C.WT_CONNECTION
+	pool sessionPool
 }
 
-func WiredTigerService() WTService { return &cgoService{} }
+func WiredTigerService() WTService { return WiredTigerServiceWithOptions(ServiceOptions{}) }
+
+func WiredTigerServiceWithOptions(opts ServiceOptions) WTService {
+	size := opts.SessionPoolSize
+	if size == 0 {
+		size = DefaultSessionPoolSize
+	}
+	return &cgoService{pool: sessionPool{size: size}}
+}
 
 // ============================================================================
 // CONNECTION OPERATIONS
 // ============================================================================
 
 func (s *cgoService) Open(home string, config string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	chome := C.CString(home)
 	cconfig := C.CString(config)
 	defer C.free(unsafe.Pointer(chome))
@@ -1134,9 +1262,12 @@ func (s *cgoService) Open(home string, config string) error {
 }
 
 func (s *cgoService) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.conn == nil {
 		return nil
 	}
+	s.pool.drain()
 	err := C.wt_close_wrap(s.conn)
 	s.conn = nil
 	if err != 0 {
@@ -1146,6 +1277,8 @@ func (s *cgoService) Close() error {
 }
 
 func (s *cgoService) CreateTable(name string, config string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if s.conn == nil {
 		return errors.New("connection not open")
 	}
@@ -1160,21 +1293,204 @@ func (s *cgoService) CreateTable(name string, config string) error {
 	return nil
 }
 
+func (s *cgoService) DropTable(name string, config string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.conn == nil {
+		return errors.New("connection not open")
+	}
+	cname := C.CString(name)
+	cconfig := C.CString(config)
+	defer C.free(unsafe.Pointer(cname))
+	defer C.free(unsafe.Pointer(cconfig))
+	// WiredTiger refuses to drop a table while a cursor is open on it.
+	s.pool.inUse.Lock()
+	defer s.pool.inUse.Unlock()
+	s.pool.closeCursors(name)
+	err := C.wt_drop_wrap(s.conn, cname, cconfig)
+	if err != 0 {
+		return fmt.Errorf("wiredtiger drop failed with error code %d", int(err))
+	}
+	return nil
+}
+
+// Checkpoint writes a checkpoint of every table, making durable what the log
+// does not cover, such as the rows of a closed bulk cursor.
+func (s *cgoService) Checkpoint() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.conn == nil {
+		return errors.New("connection not open")
+	}
+	err := C.wt_checkpoint_wrap(s.conn)
+	if err != 0 {
+		return fmt.Errorf("wiredtiger checkpoint failed with error code %d", int(err))
+	}
+	return nil
+}
+
+// ============================================================================
+// SESSION POOL
+// ============================================================================
+
+// maxPooledCursors bounds the cursors a pooled session keeps open.
+const maxPooledCursors = 64
+
+// sessionPool keeps idle sessions, with a cursor per table they have used, for
+// the single-key operations, which would otherwise open a session and a cursor
+// on every call. Calls hold inUse for reading while they have a session out;
+// DropTable holds it for writing, so that every session is idle and its
+// cursors on the table can be closed.
+type sessionPool struct {
+	inUse sync.RWMutex
+	mu    sync.Mutex
+	idle  []*pooledSession
+	size  int // idle sessions kept; negative disables pooling
+}
+
+// pooledSession is a session owned by the pool and the cursors it keeps open,
+// by table.
+type pooledSession struct {
+	session *C.WT_SESSION
+	cursors map[string]pooledCursor
+}
+
+type pooledCursor struct {
+	uri    *C.char
+	cursor *C.WT_CURSOR
+}
+
+// withCursor runs op on a cursor over table taken from a pooled session and
+// returns op's result, or the error that kept it from running. The caller
+// holds s.mu for reading.
+func (s *cgoService) withCursor(table string, op func(cursor *C.WT_CURSOR) C.int) C.int {
+	s.pool.inUse.RLock()
+	defer s.pool.inUse.RUnlock()
+
+	ps, err := s.pool.get(s.conn)
+	if err != 0 {
+		return err
+	}
+	cursor, err := ps.cursor(table)
+	if err != 0 {
+		s.pool.put(ps, true)
+		return err
+	}
+	err = op(cursor)
+	// Any failure but a missing key may leave the session unusable.
+	s.pool.put(ps, err == 0 || err == C.WT_NOTFOUND)
+	return err
+}
+
+func (p *sessionPool) get(conn *C.WT_CONNECTION) (*pooledSession, C.int) {
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		ps := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return ps, 0
+	}
+	p.mu.Unlock()
+
+	var session *C.WT_SESSION
+	if err := C.wt_session_open(conn, &session); err != 0 {
+		return nil, err
+	}
+	return &pooledSession{session: session, cursors: make(map[string]pooledCursor)}, 0
+}
+
+// put returns a session to the pool, or closes it if it may not be reused or
+// the pool is full.
+func (p *sessionPool) put(ps *pooledSession, reuse bool) {
+	if reuse {
+		p.mu.Lock()
+		if len(p.idle) < p.size {
+			p.idle = append(p.idle, ps)
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+	}
+	ps.close()
+}
+
+// closeCursors closes the cursors idle sessions keep on table.
+func (p *sessionPool) closeCursors(table string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, ps := range p.idle {
+		ps.closeCursor(table)
+	}
+}
+
+// drain closes every idle session.
+func (p *sessionPool) drain() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	for _, ps := range idle {
+		ps.close()
+	}
+}
+
+// cursor returns the session's cursor on table, opening it on first use.
+func (ps *pooledSession) cursor(table string) (*C.WT_CURSOR, C.int) {
+	if pc, ok := ps.cursors[table]; ok {
+		return pc.cursor, 0
+	}
+	if len(ps.cursors) >= maxPooledCursors {
+		for evicted := range ps.cursors {
+			ps.closeCursor(evicted)
+			break
+		}
+	}
+	curi := C.CString(table)
+	var cursor *C.WT_CURSOR
+	if err := C.wt_cursor_open(ps.session, curi, &cursor); err != 0 {
+		C.free(unsafe.Pointer(curi))
+		return nil, err
+	}
+	ps.cursors[table] = pooledCursor{uri: curi, cursor: cursor}
+	return cursor, 0
+}
+
+func (ps *pooledSession) closeCursor(table string) {
+	pc, ok := ps.cursors[table]
+	if !ok {
+		return
+	}
+	C.wt_cursor_close(pc.cursor)
+	C.free(unsafe.Pointer(pc.uri))
+	delete(ps.cursors, table)
+}
+
+// close closes the session, which closes its cursors.
+func (ps *pooledSession) close() {
+	C.wt_session_close(ps.session)
+	for _, pc := range ps.cursors {
+		C.free(unsafe.Pointer(pc.uri))
+	}
+	ps.cursors = nil
+}
+
 // ============================================================================
 // STRING KEY/VALUE OPERATIONS (existing)
 // ============================================================================
 
 func (s *cgoService) PutString(table string, key string, value string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if s.conn == nil {
 		return errors.New("connection not open")
 	}
-	curi := C.CString(table)
 	ckey := C.CString(key)
 	cval := C.CString(value)
-	defer C.free(unsafe.Pointer(curi))
 	defer C.free(unsafe.Pointer(ckey))
 	defer C.free(unsafe.Pointer(cval))
-	err := C.wt_put_str(s.conn, curi, ckey, cval)
+	err := s.withCursor(table, func(cursor *C.WT_CURSOR) C.int {
+		return C.wt_cur_put_str(cursor, ckey, cval)
+	})
 	if err != 0 {
 		return fmt.Errorf("wiredtiger put failed with error code %d", int(err))
 	}
@@ -1182,30 +1498,35 @@ func (s *cgoService) PutString(table string, key string, value string) error {
 }
 
 func (s *cgoService) GetString(table string, key string) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if s.conn == nil {
 		return "", false, errors.New("connection not open")
 	}
-	curi := C.CString(table)
 	ckey := C.CString(key)
-	defer C.free(unsafe.Pointer(curi))
 	defer C.free(unsafe.Pointer(ckey))
 	var cval *C.char
-	err := C.wt_get_str(s.conn, curi, ckey, &cval)
+	err := s.withCursor(table, func(cursor *C.WT_CURSOR) C.int {
+		return C.wt_cur_get_str(cursor, ckey, &cval)
+	})
 	if err != 0 {
 		return "", false, nil
 	}
+	defer C.free(unsafe.Pointer(cval))
 	return C.GoString(cval), true, nil
 }
 
 func (s *cgoService) DeleteString(table string, key string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if s.conn == nil {
 		return errors.New("connection not open")
 	}
-	curi := C.CString(table)
 	ckey := C.CString(key)
-	defer C.free(unsafe.Pointer(curi))
 	defer C.free(unsafe.Pointer(ckey))
-	err := C.wt_del_str(s.conn, curi, ckey)
+	err := s.withCursor(table, func(cursor *C.WT_CURSOR) C.int {
+		return C.wt_cur_del_str(cursor, ckey)
+	})
 	if err != 0 {
 		return fmt.Errorf("wiredtiger delete failed with error code %d", int(err))
 	}
@@ -1213,15 +1534,17 @@ func (s *cgoService) DeleteString(table string, key string) error {
 }
 
 func (s *cgoService) Exists(table string, key string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if s.conn == nil {
 		return false, errors.New("connection not open")
 	}
-	curi := C.CString(table)
 	ckey := C.CString(key)
-	defer C.free(unsafe.Pointer(curi))
 	defer C.free(unsafe.Pointer(ckey))
 	var found C.int
-	err := C.wt_exists_str(s.conn, curi, ckey, &found)
+	err := s.withCursor(table, func(cursor *C.WT_CURSOR) C.int {
+		return C.wt_cur_exists_str(cursor, ckey, &found)
+	})
 	if err != 0 && err != C.int(-31804) {
 		return false, fmt.Errorf("wiredtiger exists failed with error code %d", int(err))
 	}
@@ -1242,6 +1565,8 @@ func (s *cgoService) Scan(table string, threshold ...int) ([]KeyValuePair, error
 // Offset is currently not implemented due to WiredTiger API limitations and is for API compatibility.
 // Limit sets the maximum number of results returned.
 func (s *cgoService) ScanBatch(table string, offset int, limit int) ([]KeyValuePair, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if s.conn == nil {
 		return nil, errors.New("connection not open")
 	}
@@ -1267,17 +1592,18 @@ func (s *cgoService) ScanBatch(table string, offset int, limit int) ([]KeyValueP
 }
 
 func (s *cgoService) ExistsBinary(table string, key []byte) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if s.conn == nil {
 		return false, errors.New("connection not open")
 	}
 	if len(key) == 0 {
 		return false, errors.New("key cannot be empty")
 	}
-	curi := C.CString(table)
-	defer C.free(unsafe.Pointer(curi))
-
 	var found C.int
-	err := C.wt_exists_bin(s.conn, curi, (*C.uchar)(unsafe.Pointer(&key[0])), C.size_t(len(key)), &found)
+	err := s.withCursor(table, func(cursor *C.WT_CURSOR) C.int {
+		return C.wt_cur_exists_bin(cursor, (*C.uchar)(unsafe.Pointer(&key[0])), C.size_t(len(key)), &found)
+	})
 	if err != 0 && err != C.int(-31804) {
 		return false, fmt.Errorf("wiredtiger binary exists failed with error code %d", int(err))
 	}
@@ -1285,6 +1611,8 @@ func (s *cgoService) ExistsBinary(table string, key []byte) (bool, error) {
 }
 
 func (s *cgoService) ScanBinary(table string) ([]BinaryKeyValuePair, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if s.conn == nil {
 		return nil, errors.New("connection not open")
 	}
@@ -1317,6 +1645,8 @@ func (s *cgoService) ScanBinary(table string) ([]BinaryKeyValuePair, error) {
 }
 
 func (s *cgoService) SearchNearBinary(table string, probeKey []byte) ([]byte, []byte, int, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if s.conn == nil {
 		return nil, nil, 0, false, errors.New("connection not open")
 	}
@@ -1350,6 +1680,8 @@ func (s *cgoService) SearchNearBinary(table string, probeKey []byte) ([]byte, []
 // ============================================================================
 
 func (s *cgoService) SearchNear(table string, probeKey string) (string, string, int, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if s.conn == nil {
 		return "", "", 0, false, errors.New("connection not open")
 	}
@@ -1372,17 +1704,18 @@ func (s *cgoService) SearchNear(table string, probeKey string) (string, string,
 // ============================================================================
 
 func (s *cgoService) PutBinary(table string, key []byte, value []byte) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if s.conn == nil {
 		return errors.New("connection not open")
 	}
 	if len(key) == 0 || len(value) == 0 {
 		return errors.New("key and value cannot be empty")
 	}
-	curi := C.CString(table)
-	defer C.free(unsafe.Pointer(curi))
-
-	err := C.wt_put_bin(s.conn, curi, (*C.uchar)(unsafe.Pointer(&key[0])), C.size_t(len(key)),
-		(*C.uchar)(unsafe.Pointer(&value[0])), C.size_t(len(value)))
+	err := s.withCursor(table, func(cursor *C.WT_CURSOR) C.int {
+		return C.wt_cur_put_bin(cursor, (*C.uchar)(unsafe.Pointer(&key[0])), C.size_t(len(key)),
+			(*C.uchar)(unsafe.Pointer(&value[0])), C.size_t(len(value)))
+	})
 	if err != 0 {
 		return fmt.Errorf("wiredtiger binary put failed with error code %d", int(err))
 	}
@@ -1390,17 +1723,18 @@ func (s *cgoService) PutBinary(table string, key []byte, value []byte) error {
 }
 
 func (s *cgoService) GetBinary(table string, key []byte) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if s.conn == nil {
 		return nil, false, errors.New("connection not open")
 	}
 	if len(key) == 0 {
 		return nil, false, errors.New("key cannot be empty")
 	}
-	curi := C.CString(table)
-	defer C.free(unsafe.Pointer(curi))
-
 	var outVal C.WT_ITEM
-	err := C.wt_get_bin(s.conn, curi, (*C.uchar)(unsafe.Pointer(&key[0])), C.size_t(len(key)), &outVal)
+	err := s.withCursor(table, func(cursor *C.WT_CURSOR) C.int {
+		return C.wt_cur_get_bin(cursor, (*C.uchar)(unsafe.Pointer(&key[0])), C.size_t(len(key)), &outVal)
+	})
 	if err != 0 {
 		return nil, false, nil
 	}
@@ -1413,16 +1747,17 @@ func (s *cgoService) GetBinary(table string, key []byte) ([]byte, bool, error) {
 }
 
 func (s *cgoService) DeleteBinary(table string, key []byte) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if s.conn == nil {
 		return errors.New("connection not open")
 	}
 	if len(key) == 0 {
 		return errors.New("key cannot be empty")
 	}
-	curi := C.CString(table)
-	defer C.free(unsafe.Pointer(curi))
-
-	err := C.wt_del_bin(s.conn, curi, (*C.uchar)(unsafe.Pointer(&key[0])), C.size_t(len(key)))
+	err := s.withCursor(table, func(cursor *C.WT_CURSOR) C.int {
+		return C.wt_cur_del_bin(cursor, (*C.uchar)(unsafe.Pointer(&key[0])), C.size_t(len(key)))
+	})
 	if err != 0 {
 		return fmt.Errorf("wiredtiger binary delete failed with error code %d", int(err))
 	}
@@ -1591,6 +1926,8 @@ func (c *stringRangeCursor) Valid() bool { return c.valid }
 
 // ScanRange creates a cursor for iterating over string keys in the range [startKey, endKey)
 func (s *cgoService) ScanRange(table, startKey, endKey string) (StringRangeCursor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if s.conn == nil {
 		return nil, errors.New("connection not open")
 	}
@@ -1621,6 +1958,8 @@ func (s *cgoService) ScanRange(table, startKey, endKey string) (StringRangeCurso
 // ============================================================================
 
 func (s *cgoService) ScanRangeBinary(table string, startKey, endKey []byte) (BinaryRangeCursor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if s.conn == nil {
 		return nil, errors.New("connection not open")
 	}
@@ -1770,3 +2109,324 @@ func (c *binaryRangeCursor) SetBatchSize(size int) {
 func (c *binaryRangeCursor) GetBatchSize() int {
 	return c.maxBatchSize
 }
+
+// ============================================================================
+// SESSION & TRANSACTION OPERATIONS
+// ============================================================================
+
+const wtRollback = -31800 // WT_ROLLBACK
+
+type cgoSession struct {
+	session *C.WT_SESSION
+	inTxn   bool
+}
+
+// sessionErr converts a WiredTiger return code into a Go error, surfacing
+// transaction conflicts as ErrRollback so callers can retry.
+func sessionErr(op string, code C.int) error {
+	if code == wtRollback {
+		return fmt.Errorf("wiredtiger %s: %w", op, ErrRollback)
+	}
+	return fmt.Errorf("wiredtiger %s failed with error code %d", op, int(code))
+}
+
+// OpenSession opens a dedicated session on the connection. The caller owns the
+// session and must Close it.
+func (s *cgoService) OpenSession() (Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.conn == nil {
+		return nil, errors.New("connection not open")
+	}
+	var session *C.WT_SESSION
+	if err := C.wt_session_open(s.conn, &session); err != 0 {
+		return nil, fmt.Errorf("wiredtiger open_session failed with error code %d", int(err))
+	}
+	return &cgoSession{session: session}, nil
+}
+
+func (ss *cgoSession) Begin(isolation IsolationLevel) error {
+	if ss.session == nil {
+		return errors.New("session closed")
+	}
+	if ss.inTxn {
+		return errors.New("transaction already in progress")
+	}
+	if isolation == "" {
+		isolation = IsolationSnapshot
+	}
+	cconfig := C.CString(fmt.Sprintf("isolation=%s", isolation))
+	defer C.free(unsafe.Pointer(cconfig))
+	if err := C.wt_session_begin(ss.session, cconfig); err != 0 {
+		return sessionErr("begin_transaction", err)
+	}
+	ss.inTxn = true
+	return nil
+}
+
+func (ss *cgoSession) Commit() error {
+	if ss.session == nil {
+		return errors.New("session closed")
+	}
+	if !ss.inTxn {
+		return errors.New("no transaction in progress")
+	}
+	// WiredTiger ends the transaction whether or not commit succeeds.
+	ss.inTxn = false
+	if err := C.wt_session_commit(ss.session); err != 0 {
+		return sessionErr("commit_transaction", err)
+	}
+	return nil
+}
+
+func (ss *cgoSession) Rollback() error {
+	if ss.session == nil {
+		return errors.New("session closed")
+	}
+	if !ss.inTxn {
+		return nil
+	}
+	ss.inTxn = false
+	if err := C.wt_session_rollback(ss.session); err != 0 {
+		return sessionErr("rollback_transaction", err)
+	}
+	return nil
+}
+
+func (ss *cgoSession) InTransaction() bool { return ss.inTxn }
+
+func (ss *cgoSession) Close() error {
+	if ss.session == nil {
+		return nil
+	}
+	err := C.wt_session_close(ss.session)
+	ss.session = nil
+	ss.inTxn = false
+	if err != 0 {
+		return fmt.Errorf("wiredtiger session close failed with error code %d", int(err))
+	}
+	return nil
+}
+
+func (ss *cgoSession) PutString(table string, key string, value string) error {
+	if ss.session == nil {
+		return errors.New("session closed")
+	}
+	curi := C.CString(table)
+	ckey := C.CString(key)
+	cval := C.CString(value)
+	defer C.free(unsafe.Pointer(curi))
+	defer C.free(unsafe.Pointer(ckey))
+	defer C.free(unsafe.Pointer(cval))
+	if err := C.wt_sess_put_str(ss.session, curi, ckey, cval); err != 0 {
+		return sessionErr("put", err)
+	}
+	return nil
+}
+
+func (ss *cgoSession) GetString(table string, key string) (string, bool, error) {
+	if ss.session == nil {
+		return "", false, errors.New("session closed")
+	}
+	curi := C.CString(table)
+	ckey := C.CString(key)
+	defer C.free(unsafe.Pointer(curi))
+	defer C.free(unsafe.Pointer(ckey))
+	var cval *C.char
+	err := C.wt_sess_get_str(ss.session, curi, ckey, &cval)
+	if err == C.WT_NOTFOUND {
+		return "", false, nil
+	}
+	if err != 0 {
+		return "", false, sessionErr("get", err)
+	}
+	defer C.free(unsafe.Pointer(cval))
+	return C.GoString(cval), true, nil
+}
+
+func (ss *cgoSession) DeleteString(table string, key string) error {
+	if ss.session == nil {
+		return errors.New("session closed")
+	}
+	curi := C.CString(table)
+	ckey := C.CString(key)
+	defer C.free(unsafe.Pointer(curi))
+	defer C.free(unsafe.Pointer(ckey))
+	if err := C.wt_sess_del_str(ss.session, curi, ckey); err != 0 {
+		return sessionErr("delete", err)
+	}
+	return nil
+}
+
+func (ss *cgoSession) Exists(table string, key string) (bool, error) {
+	if ss.session == nil {
+		return false, errors.New("session closed")
+	}
+	curi := C.CString(table)
+	ckey := C.CString(key)
+	defer C.free(unsafe.Pointer(curi))
+	defer C.free(unsafe.Pointer(ckey))
+	var found C.int
+	if err := C.wt_sess_exists_str(ss.session, curi, ckey, &found); err != 0 {
+		return false, sessionErr("exists", err)
+	}
+	return found == 1, nil
+}
+
+func (ss *cgoSession) PutBinary(table string, key []byte, value []byte) error {
+	if ss.session == nil {
+		return errors.New("session closed")
+	}
+	if len(key) == 0 || len(value) == 0 {
+		return errors.New("key and value cannot be empty")
+	}
+	curi := C.CString(table)
+	defer C.free(unsafe.Pointer(curi))
+
+	err := C.wt_sess_put_bin(ss.session, curi, (*C.uchar)(unsafe.Pointer(&key[0])), C.size_t(len(key)),
+		(*C.uchar)(unsafe.Pointer(&value[0])), C.size_t(len(value)))
+	if err != 0 {
+		return sessionErr("binary put", err)
+	}
+	return nil
+}
+
+func (ss *cgoSession) GetBinary(table string, key []byte) ([]byte, bool, error) {
+	if ss.session == nil {
+		return nil, false, errors.New("session closed")
+	}
+	if len(key) == 0 {
+		return nil, false, errors.New("key cannot be empty")
+	}
+	curi := C.CString(table)
+	defer C.free(unsafe.Pointer(curi))
+
+	var outVal C.WT_ITEM
+	err := C.wt_sess_get_bin(ss.session, curi, (*C.uchar)(unsafe.Pointer(&key[0])), C.size_t(len(key)), &outVal)
+	if err == C.WT_NOTFOUND {
+		return nil, false, nil
+	}
+	if err != 0 {
+		return nil, false, sessionErr("binary get", err)
+	}
+
+	result := C.GoBytes(unsafe.Pointer(outVal.data), C.int(outVal.size))
+	C.free(unsafe.Pointer(outVal.data))
+
+	return result, true, nil
+}
+
+func (ss *cgoSession) DeleteBinary(table string, key []byte) error {
+	if ss.session == nil {
+		return errors.New("session closed")
+	}
+	if len(key) == 0 {
+		return errors.New("key cannot be empty")
+	}
+	curi := C.CString(table)
+	defer C.free(unsafe.Pointer(curi))
+
+	if err := C.wt_sess_del_bin(ss.session, curi, (*C.uchar)(unsafe.Pointer(&key[0])), C.size_t(len(key))); err != 0 {
+		return sessionErr("binary delete", err)
+	}
+	return nil
+}
+
+func (ss *cgoSession) ExistsBinary(table string, key []byte) (bool, error) {
+	if ss.session == nil {
+		return false, errors.New("session closed")
+	}
+	if len(key) == 0 {
+		return false, errors.New("key cannot be empty")
+	}
+	curi := C.CString(table)
+	defer C.free(unsafe.Pointer(curi))
+
+	var found C.int
+	if err := C.wt_sess_exists_bin(ss.session, curi, (*C.uchar)(unsafe.Pointer(&key[0])), C.size_t(len(key)), &found); err != 0 {
+		return false, sessionErr("binary exists", err)
+	}
+	return found == 1, nil
+}
+
+func (ss *cgoSession) PutBinaryWithStringKey(table string, stringKey string, value []byte) error {
+	return ss.PutBinary(table, []byte(stringKey), value)
+}
+
+func (ss *cgoSession) GetBinaryWithStringKey(table string, stringKey string) ([]byte, bool, error) {
+	return ss.GetBinary(table, []byte(stringKey))
+}
+
+func (ss *cgoSession) DeleteBinaryWithStringKey(table string, stringKey string) error {
+	return ss.DeleteBinary(table, []byte(stringKey))
+}
+
+// ============================================================================
+// BULK LOAD OPERATIONS
+// ============================================================================
+
+type cgoBulkCursor struct {
+	session *C.WT_SESSION
+	cursor  *C.WT_CURSOR
+	last    []byte
+}
+
+// OpenBulkCursor opens a bulk cursor on its own session. The table must have
+// binary keys and values and be empty; WiredTiger refuses the cursor while any
+// other cursor is open on it.
+func (s *cgoService) OpenBulkCursor(table string) (BulkCursor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.conn == nil {
+		return nil, errors.New("connection not open")
+	}
+	curi := C.CString(table)
+	defer C.free(unsafe.Pointer(curi))
+
+	s.pool.inUse.Lock()
+	s.pool.closeCursors(table)
+	s.pool.inUse.Unlock()
+
+	var session *C.WT_SESSION
+	var cursor *C.WT_CURSOR
+	if err := C.wt_bulk_open(s.conn, curi, &session, &cursor); err != 0 {
+		return nil, fmt.Errorf("wiredtiger bulk open_cursor failed with error code %d", int(err))
+	}
+	return &cgoBulkCursor{session: session, cursor: cursor}, nil
+}
+
+func (c *cgoBulkCursor) Insert(key []byte, value []byte) error {
+	if c.cursor == nil {
+		return errors.New("bulk cursor closed")
+	}
+	if len(key) == 0 || len(value) == 0 {
+		return errors.New("key and value cannot be empty")
+	}
+	if c.last != nil && bytes.Compare(key, c.last) <= 0 {
+		return fmt.Errorf("bulk insert key %x does not sort after the previous key %x", key, c.last)
+	}
+	err := C.wt_bulk_insert(c.cursor, (*C.uchar)(unsafe.Pointer(&key[0])), C.size_t(len(key)),
+		(*C.uchar)(unsafe.Pointer(&value[0])), C.size_t(len(value)))
+	if err != 0 {
+		return fmt.Errorf("wiredtiger bulk insert failed with error code %d", int(err))
+	}
+	c.last = append(c.last[:0], key...)
+	return nil
+}
+
+func (c *cgoBulkCursor) Close() error {
+	if c.cursor == nil {
+		return nil
+	}
+	err := C.wt_cursor_close(c.cursor)
+	serr := C.wt_session_close(c.session)
+	c.cursor = nil
+	c.session = nil
+	if err != 0 {
+		return fmt.Errorf("wiredtiger bulk cursor close failed with error code %d", int(err))
+	}
+	if serr != 0 {
+		return fmt.Errorf("wiredtiger session close failed with error code %d", int(serr))
+	}
+	return nil
+}
@@ -4,7 +4,11 @@ package wiredtiger
 
 type nocgoService struct{}
 
-func newService() Service { return &nocgoService{} }
+// WiredTigerService returns a WTService whose every call fails: WiredTiger
+// needs cgo.
+func WiredTigerService() WTService { return &nocgoService{} }
+
+func WiredTigerServiceWithOptions(opts ServiceOptions) WTService { return &nocgoService{} }
 
 func (s *nocgoService) Open(home string, config string) error {
 	return errNoCgo()
@@ -16,8 +20,59 @@ func (s *nocgoService) CreateTable(name string, config string) error {
 	return errNoCgo()
 }
 
+func (s *nocgoService) DropTable(name string, config string) error {
+	return errNoCgo()
+}
+
 func (s *nocgoService) PutString(table string, key string, value string) error { return errNoCgo() }
 func (s *nocgoService) GetString(table string, key string) (string, bool, error) {
 	return "", false, errNoCgo()
 }
 func (s *nocgoService) DeleteString(table string, key string) error { return errNoCgo() }
+func (s *nocgoService) Exists(table string, key string) (bool, error) {
+	return false, errNoCgo()
+}
+func (s *nocgoService) Scan(table string, threshold ...int) ([]KeyValuePair, error) {
+	return nil, errNoCgo()
+}
+func (s *nocgoService) SearchNear(table string, probeKey string) (string, string, int, bool, error) {
+	return "", "", 0, false, errNoCgo()
+}
+
+func (s *nocgoService) PutBinary(table string, key []byte, value []byte) error { return errNoCgo() }
+func (s *nocgoService) GetBinary(table string, key []byte) ([]byte, bool, error) {
+	return nil, false, errNoCgo()
+}
+func (s *nocgoService) DeleteBinary(table string, key []byte) error { return errNoCgo() }
+func (s *nocgoService) ExistsBinary(table string, key []byte) (bool, error) {
+	return false, errNoCgo()
+}
+func (s *nocgoService) ScanBinary(table string) ([]BinaryKeyValuePair, error) {
+	return nil, errNoCgo()
+}
+func (s *nocgoService) SearchNearBinary(table string, probeKey []byte) ([]byte, []byte, int, bool, error) {
+	return nil, nil, 0, false, errNoCgo()
+}
+
+func (s *nocgoService) PutBinaryWithStringKey(table string, stringKey string, value []byte) error {
+	return errNoCgo()
+}
+func (s *nocgoService) GetBinaryWithStringKey(table string, stringKey string) ([]byte, bool, error) {
+	return nil, false, errNoCgo()
+}
+func (s *nocgoService) DeleteBinaryWithStringKey(table string, stringKey string) error {
+	return errNoCgo()
+}
+
+func (s *nocgoService) ScanRange(table string, startKey string, endKey string) (StringRangeCursor, error) {
+	return nil, errNoCgo()
+}
+func (s *nocgoService) ScanRangeBinary(table string, startKey, endKey []byte) (BinaryRangeCursor, error) {
+	return nil, errNoCgo()
+}
+
+func (s *nocgoService) OpenSession() (Session, error) { return nil, errNoCgo() }
+
+func (s *nocgoService) OpenBulkCursor(table string) (BulkCursor, error) { return nil, errNoCgo() }
+
+func (s *nocgoService) Checkpoint() error { return errNoCgo() }